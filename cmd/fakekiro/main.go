@@ -0,0 +1,16 @@
+// Command fakekiro stands in for kiro-cli in tests and demos. It runs the
+// script named by FAKE_KIRO_SCRIPT; see internal/fakekiro for the format.
+//
+//	go build -o fakekiro ./cmd/fakekiro
+//	FAKE_KIRO_SCRIPT=script.txt budgie --kiro-binary ./fakekiro
+package main
+
+import (
+	"os"
+
+	"budgie/internal/fakekiro"
+)
+
+func main() {
+	os.Exit(fakekiro.Main(os.Args[1:]))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"budgie/internal/lease"
+	"budgie/internal/workspace"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type UndoCallInput struct {
+	CallID string `json:"callId"`
+	Force  bool   `json:"force,omitempty"`
+}
+
+type UndoCallOutput struct {
+	CallID    string                 `json:"callId"`
+	Agent     string                 `json:"agent"`
+	SessionID string                 `json:"sessionId,omitempty"`
+	Directory string                 `json:"directory"`
+	Files     []workspace.FileChange `json:"files"`
+	Warning   string                 `json:"warning,omitempty"`
+}
+
+// registerUndoTool registers the undo-call tool, which restores the files
+// an agent call changed.
+func registerUndoTool(server *mcp.Server, toolPrefix string, calls *workspace.Calls, leases *lease.Manager) {
+	undoTool := &mcp.Tool{
+		Name:        toolPrefix + "undo-call",
+		Description: "Undo an agent call (by callId from its output): restore the files it added, modified or deleted to their state before the call. Refuses without writing anything if any of those files changed again since, e.g. in a later call; set force to restore them anyway.",
+	}
+	mcp.AddTool(server, undoTool, func(ctx context.Context, req *mcp.CallToolRequest, input UndoCallInput) (*mcp.CallToolResult, UndoCallOutput, error) {
+		call, ok := calls.Get(input.CallID)
+		if !ok {
+			return nil, UndoCallOutput{}, fmt.Errorf("unknown call: %s", input.CallID)
+		}
+		// Undo writes to the directory; keep agents out of it meanwhile
+		release, err := leases.Acquire(ctx, call.Dir, lease.Exclusive, lease.Holder{Agent: "undo-call", SessionID: call.SessionID})
+		if err != nil {
+			return nil, UndoCallOutput{}, err
+		}
+		defer release()
+
+		call, conflicts, err := calls.Undo(input.CallID, input.Force)
+		if err != nil {
+			return nil, UndoCallOutput{}, err
+		}
+		log.Printf("Undid call %s (agent: %s, %d files) in %s", call.ID, call.Agent, len(call.Files), call.Dir)
+
+		output := UndoCallOutput{
+			CallID:    call.ID,
+			Agent:     call.Agent,
+			SessionID: call.SessionID,
+			Directory: call.Dir,
+			Files:     call.Files,
+		}
+		if len(conflicts) > 0 {
+			output.Warning = "overwrote changes made after the call to " + strings.Join(conflicts, ", ")
+		}
+		return nil, output, nil
+	})
+
+	log.Printf("Registered undo-call tool")
+}
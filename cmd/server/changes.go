@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"budgie/internal/lease"
+	"budgie/internal/workspace"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type ChangeInput struct {
+	ChangeID string `json:"changeId"`
+}
+
+type ListChangesInput struct {
+	SessionID string `json:"sessionId,omitempty"`
+}
+
+type ChangeOutput struct {
+	ChangeID  string                 `json:"changeId"`
+	Agent     string                 `json:"agent"`
+	SessionID string                 `json:"sessionId,omitempty"`
+	Directory string                 `json:"directory"`
+	Status    string                 `json:"status"`
+	Files     []workspace.FileChange `json:"files"`
+	Diff      string                 `json:"diff,omitempty"`
+}
+
+type ListChangesOutput struct {
+	Changes []ChangeOutput `json:"changes"`
+}
+
+func newChangeOutput(change workspace.Change, status string, withDiff bool) ChangeOutput {
+	output := ChangeOutput{
+		ChangeID:  change.ID,
+		Agent:     change.Agent,
+		SessionID: change.SessionID,
+		Directory: change.Dir,
+		Status:    status,
+		Files:     change.Files,
+	}
+	if withDiff {
+		output.Diff = change.Diff
+	}
+	return output
+}
+
+// isolateCopy wraps an agent handler so the agent works in a copy of the
+// working directory. Its edits come back as a unified diff and a change ID
+// for apply-changes or discard-changes; the real directory is untouched
+// until then. A new copy is made under a shared lease on the directory so
+// no writer changes it halfway through; with shareObjects the copy borrows
+// the directory's git object store instead of duplicating it.
+func isolateCopy(agentName string, handler agentHandler, changes *workspace.Changes, leases *lease.Manager, shareObjects bool) agentHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
+		// Dry runs preview the real directory rather than make a copy
+		if input.Directory == "" || input.DryRun {
+			return handler(ctx, req, input)
+		}
+
+		var release func()
+		if _, pending := changes.SessionChange(input.SessionID, input.Directory); !pending {
+			var err error
+			release, err = leases.Acquire(ctx, input.Directory, lease.Shared, lease.Holder{Agent: agentName, SessionID: input.SessionID})
+			if err != nil {
+				return nil, ToolOutput{}, err
+			}
+		}
+		change, created, err := changes.Prepare(agentName, input.SessionID, input.Directory, shareObjects)
+		if release != nil {
+			release()
+		}
+		if err != nil {
+			return nil, ToolOutput{}, fmt.Errorf("failed to isolate working directory: %w", err)
+		}
+
+		isolated := input
+		isolated.Directory = change.CopyDir
+		result, output, err := handler(ctx, req, isolated)
+		if err != nil || output.ErrorClass != "" {
+			// Edits from earlier turns of the session stay pending
+			if created {
+				changes.Discard(change.ID)
+			}
+			return result, output, err
+		}
+
+		finished, err := changes.Finish(change.ID, output.SessionID)
+		if err != nil {
+			return nil, ToolOutput{}, err
+		}
+		// The agent only knew the copy; report paths in the real directory
+		output.Response = strings.ReplaceAll(output.Response, change.CopyDir, input.Directory)
+		output.ChangeID = finished.ID
+		output.Diff = strings.ReplaceAll(finished.Diff, change.CopyDir, input.Directory)
+		return result, output, nil
+	}
+}
+
+// registerChangeTools registers the tools that review, apply and discard the
+// pending changes of copy-isolated agents.
+func registerChangeTools(server *mcp.Server, toolPrefix string, changes *workspace.Changes, leases *lease.Manager) {
+	listTool := &mcp.Tool{
+		Name:        toolPrefix + "list-changes",
+		Description: "List pending changes made by copy-isolated agents, with their files and unified diffs. Optionally filter by sessionId.",
+	}
+	mcp.AddTool(server, listTool, func(ctx context.Context, req *mcp.CallToolRequest, input ListChangesInput) (*mcp.CallToolResult, ListChangesOutput, error) {
+		output := ListChangesOutput{Changes: []ChangeOutput{}}
+		for _, change := range changes.List(input.SessionID) {
+			output.Changes = append(output.Changes, newChangeOutput(change, "pending", true))
+		}
+		return nil, output, nil
+	})
+
+	applyTool := &mcp.Tool{
+		Name:        toolPrefix + "apply-changes",
+		Description: "Apply a pending change (by changeId from an agent call) to the real working directory. Refuses without writing anything if a file it touches was modified there since the agent's copy was made.",
+	}
+	mcp.AddTool(server, applyTool, func(ctx context.Context, req *mcp.CallToolRequest, input ChangeInput) (*mcp.CallToolResult, ChangeOutput, error) {
+		change, ok := changes.Get(input.ChangeID)
+		if !ok {
+			return nil, ChangeOutput{}, fmt.Errorf("unknown change: %s", input.ChangeID)
+		}
+		// Applying writes to the real directory; keep agents out of it meanwhile
+		release, err := leases.Acquire(ctx, change.Dir, lease.Exclusive, lease.Holder{Agent: "apply-changes", SessionID: change.SessionID})
+		if err != nil {
+			return nil, ChangeOutput{}, err
+		}
+		defer release()
+
+		change, err = changes.Apply(input.ChangeID)
+		if err != nil {
+			return nil, ChangeOutput{}, err
+		}
+		log.Printf("Applied change %s (agent: %s, %d files) to %s", change.ID, change.Agent, len(change.Files), change.Dir)
+		return nil, newChangeOutput(change, "applied", false), nil
+	})
+
+	discardTool := &mcp.Tool{
+		Name:        toolPrefix + "discard-changes",
+		Description: "Discard a pending change (by changeId from an agent call) without touching the real working directory",
+	}
+	mcp.AddTool(server, discardTool, func(ctx context.Context, req *mcp.CallToolRequest, input ChangeInput) (*mcp.CallToolResult, ChangeOutput, error) {
+		change, err := changes.Discard(input.ChangeID)
+		if err != nil {
+			return nil, ChangeOutput{}, err
+		}
+		log.Printf("Discarded change %s (agent: %s)", change.ID, change.Agent)
+		return nil, newChangeOutput(change, "discarded", false), nil
+	})
+
+	log.Printf("Registered change tools")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"budgie/internal/config"
+	"budgie/internal/kiro"
+	"budgie/internal/lease"
+	"budgie/internal/sessions"
+	"budgie/internal/workspace"
+)
+
+func TestIsolateCopy(t *testing.T) {
+	project := t.TempDir()
+	runner := &fakeRunner{response: "done", edit: "agent edit\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+	changes := workspace.NewChanges(t.TempDir(), os.RemoveAll)
+
+	handler := isolateCopy("developer", createHandler("developer", "", sessionMgr, executor, newCalls(t), &config.Config{}), changes, lease.NewManager(time.Minute), false)
+	_, output, err := handler(context.Background(), nil, ToolInput{Prompt: "edit", Directory: project})
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	if runner.invocations[0].WorkDir == project {
+		t.Fatal("Expected the agent to run in a copy")
+	}
+	if _, err := os.Stat(filepath.Join(project, "edit.txt")); err == nil {
+		t.Fatal("Expected the real directory to be untouched")
+	}
+	if output.ChangeID == "" || !strings.Contains(output.Diff, "+agent edit") {
+		t.Fatalf("Expected a change ID and diff, got %+v", output)
+	}
+
+	if _, err := changes.Apply(output.ChangeID); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(project, "edit.txt")); string(data) != "agent edit\n" {
+		t.Errorf("Expected the edit to be applied, got %q", data)
+	}
+}
+
+func TestIsolateCopy_SharedLease(t *testing.T) {
+	project := t.TempDir()
+	runner := &fakeRunner{response: "done", edit: "agent edit\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+	changes := workspace.NewChanges(t.TempDir(), os.RemoveAll)
+	leases := lease.NewManager(0)
+
+	handler := isolateCopy("developer", createHandler("developer", "", sessionMgr, executor, nil, &config.Config{}), changes, leases, false)
+
+	// A writer holding the directory keeps the copy from being made
+	release, err := leases.Acquire(context.Background(), project, lease.Exclusive, lease.Holder{Agent: "writer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := handler(context.Background(), nil, ToolInput{Prompt: "edit", Directory: project}); !errors.Is(err, lease.ErrBusy) {
+		t.Fatalf("Expected the copy to wait for the writer, got %v", err)
+	}
+	release()
+
+	if _, _, err := handler(context.Background(), nil, ToolInput{Prompt: "edit", Directory: project}); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if held := leases.List(); len(held) != 0 {
+		t.Errorf("Expected the lease released after copying, got %+v", held)
+	}
+}
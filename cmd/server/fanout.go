@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"budgie/internal/workspace"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type FanOutItem struct {
+	Agent     string `json:"agent"`
+	Prompt    string `json:"prompt"`
+	Directory string `json:"directory,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+}
+
+type FanOutInput struct {
+	Items          []FanOutItem `json:"items"`
+	MaxConcurrency int          `json:"maxConcurrency,omitempty"`
+}
+
+type FanOutResult struct {
+	Agent        string                 `json:"agent"`
+	Response     string                 `json:"response,omitempty"`
+	SessionID    string                 `json:"sessionId,omitempty"`
+	Duration     string                 `json:"duration"`
+	Error        string                 `json:"error,omitempty"`
+	ErrorClass   string                 `json:"errorClass,omitempty"`
+	ChangeID     string                 `json:"changeId,omitempty"`
+	Diff         string                 `json:"diff,omitempty"`
+	Branch       string                 `json:"branch,omitempty"`
+	Worktree     string                 `json:"worktree,omitempty"`
+	CallID       string                 `json:"callId,omitempty"`
+	ChangedFiles []workspace.FileChange `json:"changedFiles,omitempty"`
+}
+
+type FanOutOutput struct {
+	Results   []FanOutResult `json:"results"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Duration  string         `json:"duration"`
+}
+
+// registerFanOutTool registers the fan-out tool, which runs several agent
+// calls concurrently and returns their results in input order.
+func registerFanOutTool(server *mcp.Server, toolPrefix string, maxConcurrency int, handlers map[string]agentHandler) {
+	tool := &mcp.Tool{
+		Name:        toolPrefix + "fan-out",
+		Description: fmt.Sprintf("Run several sub-agent calls in parallel and return all results at once. Each item takes agent, prompt, directory and optional sessionId and dryRun; copy and worktree isolation apply as for single calls. At most %d calls run at a time unless maxConcurrency is lower.", maxConcurrency),
+	}
+
+	mcp.AddTool(server, tool, func(ctx context.Context, req *mcp.CallToolRequest, input FanOutInput) (*mcp.CallToolResult, FanOutOutput, error) {
+		if len(input.Items) == 0 {
+			return nil, FanOutOutput{}, fmt.Errorf("items is required")
+		}
+
+		limit := maxConcurrency
+		if input.MaxConcurrency > 0 && input.MaxConcurrency < limit {
+			limit = input.MaxConcurrency
+		}
+
+		start := time.Now()
+		results := make([]FanOutResult, len(input.Items))
+		sem := make(chan struct{}, limit)
+		var wg sync.WaitGroup
+
+		for i, item := range input.Items {
+			wg.Add(1)
+			go func(i int, item FanOutItem) {
+				defer wg.Done()
+
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx.Done():
+					results[i] = FanOutResult{Agent: item.Agent, Duration: "0s", Error: ctx.Err().Error()}
+					return
+				}
+
+				results[i] = runFanOutItem(ctx, item, handlers)
+			}(i, item)
+		}
+		wg.Wait()
+
+		output := FanOutOutput{
+			Results:  results,
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				output.Failed++
+			} else {
+				output.Succeeded++
+			}
+		}
+
+		log.Printf("Fan-out finished: %d succeeded, %d failed", output.Succeeded, output.Failed)
+		return nil, output, nil
+	})
+
+	log.Printf("Registered fan-out tool (max concurrency: %d)", maxConcurrency)
+}
+
+func runFanOutItem(ctx context.Context, item FanOutItem, handlers map[string]agentHandler) FanOutResult {
+	start := time.Now()
+	result := FanOutResult{Agent: item.Agent}
+
+	handler, ok := handlers[item.Agent]
+	if !ok {
+		result.Error = fmt.Sprintf("unknown agent: %s", item.Agent)
+		result.Duration = time.Since(start).Round(time.Millisecond).String()
+		return result
+	}
+
+	_, output, err := handler(ctx, nil, ToolInput{
+		Prompt:    item.Prompt,
+		SessionID: item.SessionID,
+		Directory: item.Directory,
+		DryRun:    item.DryRun,
+	})
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	result.SessionID = output.SessionID
+	result.CallID = output.CallID
+	result.ChangedFiles = output.ChangedFiles
+
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case output.ErrorClass != "":
+		// Execution failures are reported in the response body by the handler
+		result.Error = strings.TrimPrefix(output.Response, "ERROR: ")
+		result.ErrorClass = output.ErrorClass
+	default:
+		result.Response = output.Response
+		result.ChangeID = output.ChangeID
+		result.Diff = output.Diff
+		result.Branch = output.Branch
+		result.Worktree = output.Worktree
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"budgie/internal/config"
+	"budgie/internal/kiro"
+	"budgie/internal/lease"
+	"budgie/internal/sessions"
+	"budgie/internal/workspace"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// callFanOut serves the fan-out tool over handlers and calls it with input.
+func callFanOut(t *testing.T, handlers map[string]agentHandler, input FanOutInput) FanOutOutput {
+	t.Helper()
+	server := mcp.NewServer(&mcp.Implementation{Name: "kiro-subagents", Version: "test"}, nil)
+	registerFanOutTool(server, "kiro-subagents.", 4, handlers)
+
+	ctx := context.Background()
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	if err != nil {
+		t.Fatalf("Server connect failed: %v", err)
+	}
+	client := mcp.NewClient(&mcp.Implementation{Name: "orchestrator", Version: "test"}, nil)
+	session, err := client.Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatalf("Client connect failed: %v", err)
+	}
+	defer func() {
+		session.Close()
+		serverSession.Wait()
+	}()
+
+	result, err := session.CallTool(ctx, &mcp.CallToolParams{Name: "kiro-subagents.fan-out", Arguments: input})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("Tool returned an error: %+v", result.Content)
+	}
+	data, err := json.Marshal(result.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output FanOutOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatalf("Failed to decode output %s: %v", data, err)
+	}
+	return output
+}
+
+func TestFanOut_Isolation(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "README"), []byte("readme\n"), 0644)
+	for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}, {"commit", "-q", "-m", "init"}} {
+		if output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+
+	runner := &fakeRunner{response: "done", edit: "agent edit\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+	defer sessionMgr.Cleanup()
+	changes := workspace.NewChanges(t.TempDir(), os.RemoveAll)
+	cfg := &config.Config{}
+
+	handlers := map[string]agentHandler{
+		"developer": isolateWorktree(createHandler("developer", "", sessionMgr, executor, newCalls(t), cfg), sessionMgr),
+		"designer":  isolateCopy("designer", createHandler("designer", "", sessionMgr, executor, newCalls(t), cfg), changes, lease.NewManager(time.Minute), true),
+	}
+
+	output := callFanOut(t, handlers, FanOutInput{Items: []FanOutItem{
+		{Agent: "developer", Prompt: "edit", Directory: repo},
+		{Agent: "designer", Prompt: "edit", Directory: repo},
+		{Agent: "developer", Prompt: "preview", Directory: repo, DryRun: true},
+		{Agent: "reviewer", Prompt: "review", Directory: repo},
+	}})
+
+	if output.Succeeded != 3 || output.Failed != 1 {
+		t.Fatalf("Expected 3 successes and 1 failure, got %+v", output)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "edit.txt")); err == nil {
+		t.Fatal("Expected the repository checkout to be untouched")
+	}
+
+	worktree := output.Results[0]
+	if worktree.Branch == "" || worktree.Worktree == "" {
+		t.Errorf("Expected a branch and worktree, got %+v", worktree)
+	}
+	if data, _ := os.ReadFile(filepath.Join(worktree.Worktree, "edit.txt")); string(data) != "agent edit\n" {
+		t.Errorf("Expected the edit in the worktree, got %q", data)
+	}
+	if copied := output.Results[1]; copied.ChangeID == "" || copied.Diff == "" {
+		t.Errorf("Expected a pending change, got %+v", copied)
+	}
+	if output.Results[2].SessionID != "" || output.Results[2].Response == "" {
+		t.Errorf("Expected a preview without a session, got %+v", output.Results[2])
+	}
+	if output.Results[3].Error == "" {
+		t.Errorf("Expected an unknown agent to fail, got %+v", output.Results[3])
+	}
+
+	// The dry run previews without running kiro-cli
+	if len(runner.invocations) != 2 {
+		t.Errorf("Expected 2 runs, got %d", len(runner.invocations))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"budgie/internal/config"
+	"budgie/internal/kiro"
+	"budgie/internal/sessions"
+	"budgie/internal/workspace"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// createHandler returns the tool handler for an agent. Where and how kiro-cli
+// runs is left to the executor's runner. Calls that change the working
+// directory are recorded in calls so they can be undone; with nil calls the
+// directory is not snapshotted and changed files are not reported.
+func createHandler(agentName, model string, sessionMgr *sessions.Manager, executor *kiro.Executor, calls *workspace.Calls, cfg *config.Config) agentHandler {
+	runner := executor.RunnerFor(agentName)
+
+	return func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
+		if input.Prompt == "" {
+			return nil, ToolOutput{}, fmt.Errorf("prompt is required")
+		}
+
+		if input.Directory == "" {
+			return nil, ToolOutput{}, fmt.Errorf("directory is required")
+		}
+
+		// Dry runs must not create a session
+		getWorkspaceDir := sessionMgr.GetWorkspaceDir
+		if input.DryRun {
+			getWorkspaceDir = sessionMgr.WorkspacePath
+		}
+		sessionDir, err := getWorkspaceDir(input.SessionID)
+		if err != nil {
+			return nil, ToolOutput{}, fmt.Errorf("failed to create workspace: %w", err)
+		}
+
+		sessionID := sessionMgr.GetSessionID(sessionDir)
+
+		// Generate unique response file name
+		responseFile := kiro.GetUniqueResponseFile(sessionDir)
+
+		// Paths as the agent sees them
+		workingDir := runner.WorkDirPath(input.Directory)
+		responsePath := runner.ResponsePath(sessionDir, responseFile)
+
+		// Augment prompt with directory instruction
+		enhancedPrompt := fmt.Sprintf("In directory %s, %s", workingDir, input.Prompt)
+		if executor.ReadOnlyWorkDir(agentName) {
+			enhancedPrompt = fmt.Sprintf("In directory %s (read-only: do not create, edit or delete files there), %s", workingDir, input.Prompt)
+		}
+
+		// Load and inject system prompt with response file placeholder
+		if systemPromptTemplate, err := os.ReadFile(cfg.SystemPromptPath); err == nil {
+			// Response file path must be absolute so agent knows where to write
+			systemPrompt := strings.ReplaceAll(string(systemPromptTemplate), "{{RESPONSE_FILE}}", responsePath)
+			systemPrompt = strings.ReplaceAll(systemPrompt, "{{WORKING_DIRECTORY}}", workingDir)
+			enhancedPrompt = enhancedPrompt + "\n\n" + systemPrompt
+		}
+
+		if input.DryRun {
+			preview, err := executor.Preview(agentName, enhancedPrompt, sessionDir, input.SessionID, model, input.Directory, responseFile)
+			if err != nil {
+				return nil, ToolOutput{}, fmt.Errorf("failed to preview run: %w", err)
+			}
+			return nil, ToolOutput{
+				Response:  "Dry run: kiro-cli was not started",
+				SessionID: input.SessionID,
+				Preview:   newPreviewOutput(enhancedPrompt, preview),
+			}, nil
+		}
+
+		// Snapshot the working directory to report and undo what the run
+		// changed. Read-only agents cannot change it.
+		var snapshot *workspace.Snapshot
+		if calls != nil && !executor.ReadOnlyWorkDir(agentName) {
+			if snapshot, err = calls.Begin(input.Directory); err != nil {
+				log.Printf("Failed to snapshot %s, changed files will not be reported: %v", input.Directory, err)
+			}
+		}
+
+		// Pass working directory for sandbox mount
+		result := executor.ExecuteWithWorkDir(ctx, agentName, enhancedPrompt, sessionDir, input.SessionID, model, input.Directory, responseFile)
+		if result.Error != nil {
+			// Return error in response body with sessionID so orchestrator can retry
+			call := finishCall(calls, agentName, sessionID, snapshot)
+			return nil, ToolOutput{
+				Response:     fmt.Sprintf("ERROR: %v", result.Error),
+				SessionID:    sessionID,
+				ErrorClass:   string(kiro.ClassOf(result.Error)),
+				CallID:       call.ID,
+				ChangedFiles: call.Files,
+			}, nil
+		}
+
+		// Try to read response file first
+		responseOutput := result.Output
+		content, err := runner.ReadResponse(sessionDir, responseFile)
+		responseFound := err == nil
+		if responseFound {
+			responseOutput = content
+		}
+
+		// Fallback: Request file creation using template
+		if !responseFound {
+			if contextSummaryTemplate, err := os.ReadFile(cfg.ContextSummaryPath); err == nil {
+				fallbackPrompt := strings.ReplaceAll(string(contextSummaryTemplate), "{{RESPONSE_FILE}}", responsePath)
+
+				fallbackResult := executor.ExecuteWithWorkDir(ctx, agentName, fallbackPrompt, sessionDir, sessionID, model, input.Directory, responseFile)
+				if fallbackResult.Error == nil {
+					if content, err := runner.ReadResponse(sessionDir, responseFile); err == nil {
+						responseOutput = content
+					}
+				}
+			}
+		}
+
+		call := finishCall(calls, agentName, sessionID, snapshot)
+		return nil, ToolOutput{
+			Response:     responseOutput,
+			SessionID:    sessionID,
+			CallID:       call.ID,
+			ChangedFiles: call.Files,
+		}, nil
+	}
+}
+
+// finishCall records a call against the snapshot taken before it, if there
+// is one, and returns it with the files it changed.
+func finishCall(calls *workspace.Calls, agentName, sessionID string, snapshot *workspace.Snapshot) workspace.Call {
+	if snapshot == nil {
+		return workspace.Call{}
+	}
+	call, err := calls.Finish(agentName, sessionID, snapshot)
+	if err != nil {
+		log.Printf("Failed to record changed files: %v", err)
+	}
+	return call
+}
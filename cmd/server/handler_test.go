@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"budgie/internal/config"
+	"budgie/internal/kiro"
+	"budgie/internal/sessions"
+	"budgie/internal/workspace"
+)
+
+// fakeRunner writes a canned response instead of running kiro-cli.
+type fakeRunner struct {
+	kiro.DirectRunner
+	response string
+	// edit, if set, is written to the working directory as edit.txt
+	edit string
+
+	mu          sync.Mutex
+	invocations []kiro.Invocation
+}
+
+func (r *fakeRunner) Command(ctx context.Context, inv kiro.Invocation) (*exec.Cmd, error) {
+	r.mu.Lock()
+	r.invocations = append(r.invocations, inv)
+	r.mu.Unlock()
+
+	if r.response != "" {
+		if err := os.WriteFile(filepath.Join(inv.SessionDir, inv.ResponseFile), []byte(r.response), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if r.edit != "" {
+		if err := os.WriteFile(filepath.Join(inv.WorkDir, "edit.txt"), []byte(r.edit), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return exec.CommandContext(ctx, "true"), nil
+}
+
+// newCalls returns a call history for handlers under test.
+func newCalls(t *testing.T) *workspace.Calls {
+	return workspace.NewCalls(t.TempDir(), os.RemoveAll)
+}
+
+func TestCreateHandler_FakeRunner(t *testing.T) {
+	runner := &fakeRunner{response: "fake response\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+
+	systemPrompt := filepath.Join(t.TempDir(), "_system.md")
+	os.WriteFile(systemPrompt, []byte("Write to {{RESPONSE_FILE}} in {{WORKING_DIRECTORY}}"), 0644)
+	cfg := &config.Config{SystemPromptPath: systemPrompt}
+
+	handler := createHandler("test-agent", "test-model", sessionMgr, executor, newCalls(t), cfg)
+	_, output, err := handler(context.Background(), nil, ToolInput{Prompt: "do it", Directory: "/project"})
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	if output.Response != "fake response" {
+		t.Errorf("Expected response from file, got %q", output.Response)
+	}
+	if output.SessionID == "" {
+		t.Error("Expected a session ID")
+	}
+	if len(runner.invocations) != 1 {
+		t.Fatalf("Expected 1 invocation, got %d", len(runner.invocations))
+	}
+
+	inv := runner.invocations[0]
+	if inv.Agent != "test-agent" || inv.Model != "test-model" || inv.WorkDir != "/project" {
+		t.Errorf("Unexpected invocation: %+v", inv)
+	}
+	if !strings.Contains(inv.Prompt, filepath.Join(inv.SessionDir, inv.ResponseFile)) {
+		t.Errorf("Prompt should contain the response path, got %q", inv.Prompt)
+	}
+}
+
+func TestCreateHandler_FallbackWhenNoResponseFile(t *testing.T) {
+	runner := &fakeRunner{}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+
+	contextSummary := filepath.Join(t.TempDir(), "_context-summary.md")
+	os.WriteFile(contextSummary, []byte("Summarize into {{RESPONSE_FILE}}"), 0644)
+	cfg := &config.Config{ContextSummaryPath: contextSummary}
+
+	handler := createHandler("test-agent", "", sessionMgr, executor, newCalls(t), cfg)
+	if _, _, err := handler(context.Background(), nil, ToolInput{Prompt: "do it", Directory: "/project"}); err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	if len(runner.invocations) != 2 {
+		t.Fatalf("Expected a fallback invocation, got %d invocations", len(runner.invocations))
+	}
+	if !strings.HasPrefix(runner.invocations[1].Prompt, "Summarize into ") {
+		t.Errorf("Expected context summary prompt, got %q", runner.invocations[1].Prompt)
+	}
+}
+
+func TestCreateHandler_ReportsChangedFiles(t *testing.T) {
+	runner := &fakeRunner{response: "done", edit: "one\ntwo\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+
+	handler := createHandler("developer", "", sessionMgr, executor, newCalls(t), &config.Config{})
+	_, output, err := handler(context.Background(), nil, ToolInput{Prompt: "edit", Directory: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	expected := []workspace.FileChange{{Path: "edit.txt", Status: workspace.StatusAdded, LinesAdded: 2}}
+	if !reflect.DeepEqual(output.ChangedFiles, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, output.ChangedFiles)
+	}
+}
+
+func TestCreateHandler_NoSnapshots(t *testing.T) {
+	runner := &fakeRunner{response: "done", edit: "one\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+
+	handler := createHandler("developer", "", sessionMgr, executor, nil, &config.Config{})
+	_, output, err := handler(context.Background(), nil, ToolInput{Prompt: "edit", Directory: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if output.CallID != "" || output.ChangedFiles != nil {
+		t.Errorf("Expected no call recorded without snapshots, got %+v", output)
+	}
+}
+
+func TestCreateHandler_DryRun(t *testing.T) {
+	runner := &fakeRunner{response: "done", edit: "edit\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionsDir := t.TempDir()
+	sessionMgr := sessions.NewManager(sessionsDir, false)
+	project := t.TempDir()
+
+	systemPrompt := filepath.Join(t.TempDir(), "_system.md")
+	os.WriteFile(systemPrompt, []byte("Write to {{RESPONSE_FILE}}"), 0644)
+	cfg := &config.Config{SystemPromptPath: systemPrompt}
+
+	handler := createHandler("developer", "test-model", sessionMgr, executor, newCalls(t), cfg)
+	_, output, err := handler(context.Background(), nil, ToolInput{Prompt: "edit", Directory: project, DryRun: true})
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	if len(runner.invocations) != 0 {
+		t.Fatal("Expected kiro-cli not to run")
+	}
+	if entries, _ := os.ReadDir(sessionsDir); len(entries) != 0 {
+		t.Error("Expected no session workspace to be created")
+	}
+	if output.SessionID != "" || output.Preview == nil {
+		t.Fatalf("Expected a preview without a session, got %+v", output)
+	}
+	if !strings.HasPrefix(output.Preview.Prompt, "In directory "+project+", edit") || strings.Contains(output.Preview.Prompt, "{{RESPONSE_FILE}}") {
+		t.Errorf("Expected the rendered prompt, got %q", output.Preview.Prompt)
+	}
+	if args := output.Preview.Command; strings.Contains(strings.Join(args, " "), output.Preview.Prompt) || !strings.Contains(strings.Join(args, " "), "--model test-model") {
+		t.Errorf("Expected the kiro-cli argv without the prompt, got %v", args)
+	}
+	if output.Preview.PromptDelivery != "stdin" {
+		t.Errorf("Expected the prompt on stdin, got %q", output.Preview.PromptDelivery)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestServeHTTP_ListTools(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "kiro-subagents", Version: "test"}, nil)
+	mcp.AddTool(server, &mcp.Tool{Name: "kiro-subagents.developer"}, func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
+		return nil, ToolOutput{}, nil
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serveHTTP(ctx, server, listener, "") }()
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "orchestrator", Version: "test"}, nil)
+	session, err := client.Connect(context.Background(), &mcp.StreamableClientTransport{Endpoint: "http://" + listener.Addr().String() + "/mcp"}, nil)
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	tools, err := session.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListTools failed: %v", err)
+	}
+	if len(tools.Tools) != 1 || tools.Tools[0].Name != "kiro-subagents.developer" {
+		t.Errorf("Unexpected tools %+v", tools.Tools)
+	}
+	session.Close()
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serveHTTP returned %v after shutdown", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serveHTTP did not return after ctx was cancelled")
+	}
+}
+
+// bearerTransport adds a bearer token to every request.
+type bearerTransport struct {
+	token string
+}
+
+func (b bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestServeHTTP_Token(t *testing.T) {
+	server := mcp.NewServer(&mcp.Implementation{Name: "kiro-subagents", Version: "test"}, nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serveHTTP(ctx, server, listener, "s3cret")
+	endpoint := "http://" + listener.Addr().String() + "/mcp"
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "orchestrator", Version: "test"}, nil)
+	for _, token := range []string{"", "wrong"} {
+		transport := &mcp.StreamableClientTransport{Endpoint: endpoint, HTTPClient: &http.Client{Transport: bearerTransport{token}}}
+		if session, err := client.Connect(context.Background(), transport, nil); err == nil {
+			session.Close()
+			t.Errorf("Expected token %q to be rejected", token)
+		}
+	}
+
+	transport := &mcp.StreamableClientTransport{Endpoint: endpoint, HTTPClient: &http.Client{Transport: bearerTransport{"s3cret"}}}
+	session, err := client.Connect(context.Background(), transport, nil)
+	if err != nil {
+		t.Fatalf("Connect with the token failed: %v", err)
+	}
+	session.Close()
+}
+
+func TestCheckListenAddr(t *testing.T) {
+	tests := []struct {
+		addr, token string
+		ok          bool
+	}{
+		{"127.0.0.1:8765", "", true},
+		{"[::1]:8765", "", true},
+		{"localhost:8765", "", true},
+		{"0.0.0.0:8765", "", false},
+		{":8765", "", false},
+		{"devbox.example.com:8765", "", false},
+		{"0.0.0.0:8765", "s3cret", true},
+	}
+	for _, tt := range tests {
+		if err := checkListenAddr(tt.addr, tt.token); (err == nil) != tt.ok {
+			t.Errorf("checkListenAddr(%q, %q) = %v", tt.addr, tt.token, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"budgie/internal/lease"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// agentLeaseMode returns the lease an agent's calls hold on their working
+// directory: exclusive for any agent that can change it, unless it opts out
+// with writer: false. Snapshotted agents cannot opt out: their snapshots
+// cover the whole directory, so a call running alongside would have its
+// edits reported, and undone, as theirs.
+func agentLeaseMode(writer *bool, readOnly, snapshot bool) lease.Mode {
+	if readOnly || (writer != nil && !*writer && !snapshot) {
+		return lease.Shared
+	}
+	return lease.Exclusive
+}
+
+// leaseDirectory wraps an agent handler so each call holds a lease on its
+// working directory in the mode agentLeaseMode picks.
+// Calls that conflict wait for each other, or fail once the wait runs out.
+// It wraps the handler before any isolation, so the lease is on the
+// directory the agent actually works in.
+func leaseDirectory(agentName string, handler agentHandler, leases *lease.Manager, mode lease.Mode) agentHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
+		if input.Directory == "" || input.DryRun {
+			return handler(ctx, req, input)
+		}
+
+		start := time.Now()
+		release, err := leases.Acquire(ctx, input.Directory, mode, lease.Holder{Agent: agentName, SessionID: input.SessionID})
+		if err != nil {
+			return nil, ToolOutput{}, err
+		}
+		defer release()
+		if wait := time.Since(start); wait > time.Second {
+			log.Printf("%s waited %s for a %s lease on %s", agentName, wait.Round(time.Millisecond), mode, input.Directory)
+		}
+
+		return handler(ctx, req, input)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"budgie/internal/lease"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestLeaseDirectory(t *testing.T) {
+	leases := lease.NewManager(0)
+	dir := t.TempDir()
+
+	// The writer's handler makes the other calls while it holds its lease
+	var readerErr, writerErr error
+	reader := leaseDirectory("analyzer", func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
+		return nil, ToolOutput{Response: "read"}, nil
+	}, leases, lease.Shared)
+	var writer agentHandler
+	writer = leaseDirectory("developer", func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
+		if input.Prompt == "first" {
+			_, _, readerErr = reader(ctx, req, ToolInput{Prompt: "read", Directory: dir})
+			_, _, writerErr = writer(ctx, req, ToolInput{Prompt: "second", Directory: dir})
+		}
+		return nil, ToolOutput{Response: "written"}, nil
+	}, leases, lease.Exclusive)
+
+	if _, _, err := writer(context.Background(), nil, ToolInput{Prompt: "first", Directory: dir}); err != nil {
+		t.Fatalf("Writer failed: %v", err)
+	}
+	if !errors.Is(readerErr, lease.ErrBusy) || !errors.Is(writerErr, lease.ErrBusy) {
+		t.Errorf("Expected calls during a write to fail fast, got %v and %v", readerErr, writerErr)
+	}
+
+	if _, output, err := reader(context.Background(), nil, ToolInput{Prompt: "read", Directory: dir}); err != nil || output.Response != "read" {
+		t.Errorf("Expected the lease released after the write, got %v", err)
+	}
+	if leases := leases.List(); len(leases) != 0 {
+		t.Errorf("Expected no leases left, got %+v", leases)
+	}
+}
+
+func TestAgentLeaseMode(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		writer             *bool
+		readOnly, snapshot bool
+		want               lease.Mode
+	}{
+		{nil, false, false, lease.Exclusive},
+		{&yes, false, false, lease.Exclusive},
+		{&no, false, false, lease.Shared},
+		{&no, false, true, lease.Exclusive},
+		{nil, true, false, lease.Shared},
+		{nil, true, true, lease.Shared},
+	}
+	for i, tt := range tests {
+		if got := agentLeaseMode(tt.writer, tt.readOnly, tt.snapshot); got != tt.want {
+			t.Errorf("Case %d: expected %s, got %s", i, tt.want, got)
+		}
+	}
+}
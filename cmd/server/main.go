@@ -2,23 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 	"time"
 
 	"budgie/internal/agents"
 	"budgie/internal/config"
+	"budgie/internal/container"
+	"budgie/internal/egress"
 	"budgie/internal/frontmatter"
 	"budgie/internal/health"
 	"budgie/internal/kiro"
+	"budgie/internal/lease"
+	"budgie/internal/limiter"
 	"budgie/internal/sessions"
+	"budgie/internal/tasks"
+	"budgie/internal/workspace"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
@@ -27,19 +37,39 @@ type ToolInput struct {
 	Prompt    string `json:"prompt"`
 	SessionID string `json:"sessionId,omitempty"`
 	Directory string `json:"directory,omitempty"`
+	// DryRun returns the rendered prompt and command without running
+	// kiro-cli
+	DryRun bool `json:"dryRun,omitempty"`
 }
 
 type ToolOutput struct {
-	Response  string `json:"response"`
-	SessionID string `json:"sessionId"`
+	Response   string `json:"response"`
+	SessionID  string `json:"sessionId"`
+	ErrorClass string `json:"errorClass,omitempty"`
+	ChangeID   string `json:"changeId,omitempty"`
+	Diff       string `json:"diff,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	Worktree   string `json:"worktree,omitempty"`
+	// CallID identifies a call that changed the working directory, for
+	// undo-call
+	CallID string `json:"callId,omitempty"`
+	// ChangedFiles lists the files the call added, modified or deleted in
+	// the working directory, for agents that are snapshotted
+	ChangedFiles []workspace.FileChange `json:"changedFiles,omitempty"`
+	// Preview is what a dry run would have executed
+	Preview *PreviewOutput `json:"preview,omitempty"`
 }
 
+type agentHandler = func(context.Context, *mcp.CallToolRequest, ToolInput) (*mcp.CallToolResult, ToolOutput, error)
+
 func main() {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatalf("Failed to get home directory: %v", err)
 	}
 
+	workDir, _ := os.Getwd()
+
 	agentsDir := flag.String("agents-dir", filepath.Join(homeDir, ".kiro", "agents"), "Directory containing agent JSON files")
 	sessionsDir := flag.String("sessions-dir", filepath.Join(homeDir, ".kiro", "sub-agents", "sessions"), "Base directory for session workspaces")
 	promptsDir := flag.String("prompts-dir", filepath.Join(homeDir, ".kiro", "sub-agents", "prompts"), "Directory containing agent prompt files")
@@ -48,12 +78,78 @@ func main() {
 	kiroBinary := flag.String("kiro-binary", "kiro-cli", "Path to kiro-cli binary")
 	toolPrefix := flag.String("tool-prefix", "kiro-subagents.", "Prefix for registered tool names")
 	agentTimeout := flag.Duration("agent-timeout", 10*time.Minute, "Timeout for agent execution")
+	killGrace := flag.Duration("kill-grace", 5*time.Second, "Time a timed-out or cancelled agent gets after SIGTERM before it is killed")
 	sandboxEnabled := flag.Bool("sandbox", false, "Enable sandbox mode (run agents in Docker containers)")
 	sandboxImage := flag.String("sandbox-image", "budgie-sandbox:latest", "Docker image for sandbox mode")
+	containerRuntime := flag.String("container-runtime", "docker", "Container runtime for sandbox mode: docker or podman")
+	sandboxWarm := flag.Bool("sandbox-warm", false, "Keep one container per session and run each turn with exec instead of a new container (container backend only)")
+	sandboxIdleTimeout := flag.Duration("sandbox-idle-timeout", 10*time.Minute, "Remove warm session containers after this long without a turn")
+	sandboxCPUs := flag.Float64("sandbox-cpus", 0, "CPU limit per sandboxed run, e.g. 2 or 1.5 (0 = unlimited)")
+	sandboxMemory := flag.String("sandbox-memory", "", "Memory limit per sandboxed run, e.g. 4g (empty = unlimited)")
+	sandboxPIDs := flag.Int("sandbox-pids", 0, "Process limit per sandboxed run (0 = unlimited)")
+	sandboxTmpfsSize := flag.String("sandbox-tmpfs-size", "", "Size of a tmpfs mounted at /tmp in sandboxed runs, e.g. 1g (empty = no tmpfs)")
+	sandboxNetwork := flag.String("sandbox-network", "full", "Default network policy for sandboxed runs: none, kiro-api-only or full")
+	egressAllow := flag.String("egress-allow", strings.Join(egress.DefaultAllow, ","), "Comma-separated hosts reachable under the kiro-api-only network policy; * matches one DNS label and a leading ** one or more")
+	sandboxBackend := flag.String("sandbox-backend", "container", "Sandbox backend: container (Docker/Podman) or bwrap (bubblewrap namespaces, Linux only)")
 	verbose := flag.Bool("verbose", false, "Enable verbose output including chat debug logs")
 	listTools := flag.Bool("list-tools", false, "Print tool information and exit")
+	previewDirectory := flag.String("directory", workDir, "Working directory of the previewed call (preview only)")
+	previewSessionID := flag.String("session-id", "", "Session the previewed call continues (preview only)")
+	fanOutConcurrency := flag.Int("fan-out-concurrency", 4, "Maximum number of agent calls the fan-out tool runs at once")
+	maxConcurrent := flag.Int("max-concurrent", 0, "Maximum number of agent runs at once across all agents (0 = unlimited)")
+	maxConcurrentPerAgent := flag.Int("max-concurrent-per-agent", 0, "Maximum number of runs at once per agent, overridable with max_concurrent in frontmatter (0 = unlimited)")
+	taskTTL := flag.Duration("task-ttl", tasks.DefaultTTL, "How long a finished task's result is kept (0 = until --max-finished-tasks is exceeded)")
+	maxFinishedTasks := flag.Int("max-finished-tasks", tasks.DefaultMaxFinished, "Maximum number of finished tasks kept; the oldest are dropped first (0 = unlimited)")
+	leaseWait := flag.Duration("lease-wait", 30*time.Minute, "How long a call waits for a conflicting directory lease to be released; must be longer than --agent-timeout (0 = fail at once)")
+	snapshots := flag.Bool("snapshots", false, "Snapshot the working directory around each call to report changedFiles and allow undo-call, overridable with snapshot in frontmatter")
+	snapshotMaxBackup := flag.Int64("snapshot-max-backup", workspace.DefaultMaxBackup, "Largest directory in bytes outside a git repository that is copied before a snapshotted call so it can be undone (0 = never copy)")
+	retryMaxAttempts := flag.Int("retry-max-attempts", 2, "Total attempts per agent run including the first (1 disables retries)")
+	retryInitialBackoff := flag.Duration("retry-initial-backoff", 2*time.Second, "Wait before the first retry; doubles on each further retry")
+	retryMaxBackoff := flag.Duration("retry-max-backoff", 30*time.Second, "Maximum wait between retries")
+	retryJitter := flag.Float64("retry-jitter", 0.2, "Randomize each backoff by up to this fraction (0-1)")
+	retryMaxElapsed := flag.Duration("retry-max-elapsed", 0, "Stop retrying once this much time has passed since the first attempt (0 = no limit)")
+	retryOn := flag.String("retry-on", "timeout,crash,exit,rate_limited", "Comma-separated error classes to retry")
+	listenAddr := flag.String("listen", "", "Serve MCP over Streamable HTTP on this address (e.g. 127.0.0.1:8765) instead of stdio")
+	httpToken := flag.String("http-token", os.Getenv("BUDGIE_HTTP_TOKEN"), "Bearer token HTTP clients must send; required to --listen on a non-loopback address (default $BUDGIE_HTTP_TOKEN)")
+	promptDelivery := flag.String("prompt-delivery", "auto", "How prompts reach kiro-cli: auto (stdin), stdin, file, or argv (visible in the process list; argv up to --prompt-arg-max bytes, file above)")
+	promptArgMax := flag.Int("prompt-arg-max", kiro.DefaultPromptArgMax, "Largest prompt in bytes passed as an argument with --prompt-delivery argv")
+	recordDir := flag.String("record", "", "Record every kiro-cli run as a cassette in this directory; cassettes contain the full prompt and response content")
+	replayDir := flag.String("replay", "", "Answer kiro-cli runs from the cassettes in this directory instead of running kiro-cli")
 	flag.Parse()
 
+	// budgie [flags] preview [flags] <agent> [prompt] dry-runs one call and
+	// exits; flags may come before or after the subcommand
+	preview := flag.Arg(0) == "preview"
+	var previewAgent, previewPrompt string
+	if preview {
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if flag.NArg() < 1 {
+			log.Fatalf("Usage: budgie preview [flags] <agent> [prompt]")
+		}
+		previewAgent = flag.Arg(0)
+		previewPrompt = strings.Join(flag.Args()[1:], " ")
+		if previewPrompt == "" {
+			previewPrompt = "<prompt>"
+		}
+	}
+
+	if *fanOutConcurrency < 1 {
+		log.Fatalf("--fan-out-concurrency must be at least 1")
+	}
+	// A lease is held for a whole call, so a shorter wait fails calls
+	// queued behind a writer that is still within its timeout
+	if *leaseWait != 0 && *leaseWait <= *agentTimeout {
+		log.Fatalf("--lease-wait (%s) must be longer than --agent-timeout (%s), or 0", *leaseWait, *agentTimeout)
+	}
+	if *listenAddr != "" {
+		if err := checkListenAddr(*listenAddr, *httpToken); err != nil {
+			log.Fatalf("Invalid --listen: %v", err)
+		}
+	}
+	if *recordDir != "" && *replayDir != "" {
+		log.Fatalf("--record and --replay cannot be used together")
+	}
+
 	agentList, err := agents.Load(*agentsDir)
 	if err != nil {
 		log.Fatalf("Failed to load agents: %v", err)
@@ -73,15 +169,116 @@ func main() {
 		KiroBinary:         *kiroBinary,
 		ToolPrefix:         *toolPrefix,
 		AgentTimeout:       *agentTimeout,
+		KillGrace:          *killGrace,
 		SandboxEnabled:     *sandboxEnabled,
 		SandboxImage:       *sandboxImage,
+		ContainerRuntime:   *containerRuntime,
+		SandboxBackend:     *sandboxBackend,
+		SandboxWarm:        *sandboxWarm,
+		SandboxIdleTimeout: *sandboxIdleTimeout,
+		SandboxCPUs:        *sandboxCPUs,
+		SandboxMemory:      *sandboxMemory,
+		SandboxPIDs:        *sandboxPIDs,
+		SandboxTmpfsSize:   *sandboxTmpfsSize,
+		SandboxNetwork:     *sandboxNetwork,
+		EgressAllow:        *egressAllow,
 		Verbose:            *verbose,
+		ListenAddr:         *listenAddr,
+		HTTPToken:          *httpToken,
+		FanOutConcurrency:  *fanOutConcurrency,
+		PromptDelivery:     *promptDelivery,
+		PromptArgMax:       *promptArgMax,
+		RecordDir:          *recordDir,
+		ReplayDir:          *replayDir,
+
+		MaxConcurrent:         *maxConcurrent,
+		MaxConcurrentPerAgent: *maxConcurrentPerAgent,
+		LeaseWait:             *leaseWait,
+		Snapshots:             *snapshots,
+		SnapshotMaxBackup:     *snapshotMaxBackup,
+		TaskTTL:               *taskTTL,
+		MaxFinishedTasks:      *maxFinishedTasks,
+
+		RetryMaxAttempts:    *retryMaxAttempts,
+		RetryInitialBackoff: *retryInitialBackoff,
+		RetryMaxBackoff:     *retryMaxBackoff,
+		RetryJitter:         *retryJitter,
+		RetryMaxElapsed:     *retryMaxElapsed,
+		RetryOn:             *retryOn,
+	}
+
+	retryPolicy, err := newRetryPolicy(cfg)
+	if err != nil {
+		log.Fatalf("Invalid retry settings: %v", err)
 	}
 
+	resources := kiro.Resources{
+		CPUs:      cfg.SandboxCPUs,
+		Memory:    cfg.SandboxMemory,
+		PIDs:      cfg.SandboxPIDs,
+		TmpfsSize: cfg.SandboxTmpfsSize,
+	}
+	if err := resources.Validate(); err != nil {
+		log.Fatalf("Invalid sandbox resource limits: %v", err)
+	}
+
+	network, err := kiro.ParseNetworkPolicy(cfg.SandboxNetwork)
+	if err != nil {
+		log.Fatalf("Invalid --sandbox-network: %v", err)
+	}
+
+	containerRT, err := container.Parse(cfg.ContainerRuntime)
+	if err != nil {
+		log.Fatalf("Invalid --container-runtime: %v", err)
+	}
+
+	// Session volumes are only needed when kiro-cli runs in a container
+	containerized := cfg.SandboxEnabled && cfg.SandboxBackend == "container"
+
 	// Create dependencies
 	healthMonitor := health.NewMonitor()
-	sessionMgr := sessions.NewManager(cfg.SessionsDir, cfg.SandboxEnabled)
-	executor := kiro.NewExecutor(cfg.KiroBinary, cfg.AgentTimeout, healthMonitor, cfg.SandboxEnabled, cfg.SandboxImage, cfg.Verbose)
+	sessionMgr := sessions.NewManager(cfg.SessionsDir, containerized)
+	sessionMgr.SetContainerRuntime(containerRT, cfg.SandboxImage)
+	egressGateway := egress.NewGateway(containerRT, egress.ParseAllow(cfg.EgressAllow))
+	runner, err := newRunner(cfg, containerRT, sessionMgr, egressGateway)
+	if err != nil {
+		log.Fatalf("Invalid sandbox settings: %v", err)
+	}
+	executor := kiro.NewExecutorWithRunner(runner, cfg.AgentTimeout, healthMonitor, cfg.Verbose)
+	slots := limiter.New(cfg.MaxConcurrent, cfg.MaxConcurrentPerAgent)
+	executor.SetLimiter(slots)
+	leases := lease.NewManager(cfg.LeaseWait)
+	executor.SetRetryPolicy(retryPolicy)
+	executor.SetKillGrace(cfg.KillGrace)
+	executor.SetResources(resources)
+	if !resources.IsZero() && !runner.Containerized() {
+		log.Printf("Warning: sandbox resource limits are only enforced by the container backend")
+	}
+	executor.SetNetworkPolicy(network)
+	if err := checkNetworkPolicy(cfg, network); err != nil {
+		log.Fatalf("Invalid --sandbox-network: %v", err)
+	}
+	delivery, err := kiro.ParsePromptDelivery(cfg.PromptDelivery)
+	if err != nil {
+		log.Fatalf("Invalid --prompt-delivery: %v", err)
+	}
+	executor.SetPromptDelivery(delivery, cfg.PromptArgMax)
+	if cfg.RecordDir != "" {
+		recorder, err := kiro.NewRecorder(cfg.RecordDir)
+		if err != nil {
+			log.Fatalf("Invalid --record: %v", err)
+		}
+		executor.SetRecorder(recorder)
+		log.Printf("Recording kiro-cli runs to %s", cfg.RecordDir)
+	}
+	if cfg.ReplayDir != "" {
+		replayer, err := kiro.NewReplayer(cfg.ReplayDir)
+		if err != nil {
+			log.Fatalf("Invalid --replay: %v", err)
+		}
+		executor.SetReplayer(replayer)
+		log.Printf("Replaying %d kiro-cli runs from %s", replayer.Len(), cfg.ReplayDir)
+	}
 
 	// List tools mode: print tool information and exit
 	if *listTools {
@@ -122,12 +319,25 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if containerized && cfg.SandboxWarm {
+		sessionMgr.StartIdleReaper(ctx, cfg.SandboxIdleTimeout)
+	}
+
+	// Copies for copy-isolated agents, created on first use
+	changes := workspace.NewChanges(filepath.Join(os.TempDir(), fmt.Sprintf("budgie-changes-%d", os.Getpid())), sessionMgr.RemoveAll)
+	// Snapshots of calls that changed their working directory, for undo-call
+	calls := workspace.NewCalls(filepath.Join(os.TempDir(), fmt.Sprintf("budgie-calls-%d", os.Getpid())), sessionMgr.RemoveAll)
+	calls.SetMaxBackup(cfg.SnapshotMaxBackup)
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigChan
 		log.Println("Shutting down, cleaning up sessions...")
+		calls.Cleanup()
 		sessionMgr.Cleanup()
+		changes.Cleanup()
+		egressGateway.Close()
 		cancel()
 	}()
 
@@ -136,6 +346,9 @@ func main() {
 		Version: "1.0.0",
 	}, nil)
 
+	copyIsolation, worktreeIsolation, snapshotted := false, false, false
+
+	handlers := make(map[string]agentHandler)
 	for _, agent := range agentList {
 		agentName := agent.Name
 		
@@ -152,6 +365,9 @@ func main() {
 		toolName := agents.NormalizeToolName(agentName, cfg.ToolPrefix)
 		description := agents.FilterDescription(agent.Description)
 		model := "claude-sonnet-4.5"
+		isolation := workspace.IsolationNone
+		var writer *bool
+		snapshot := cfg.Snapshots
 		
 		// Try to load frontmatter from prompt file
 		if metadata, err := frontmatter.LoadFromPrompt(cfg.PromptsDir, agentName); err == nil && metadata != nil {
@@ -159,12 +375,78 @@ func main() {
 			if metadata.Model != "" {
 				model = metadata.Model
 			}
+			opts, err := agentOptions(metadata, retryPolicy)
+			if err != nil {
+				log.Fatalf("Invalid frontmatter for %s: %v", agentName, err)
+			}
+			agentCfg := cfg
+			if sc := metadata.Sandbox; sc != nil && sc.Enabled != nil && *sc.Enabled != cfg.SandboxEnabled {
+				agentCfg = sandboxOverride(cfg, *sc.Enabled)
+				if opts.Runner, err = newRunner(agentCfg, containerRT, sessionMgr, egressGateway); err != nil {
+					log.Fatalf("Invalid frontmatter for %s: %v", agentName, err)
+				}
+				if opts.Runner.Containerized() {
+					sessionMgr.SetSandboxMode(true)
+				}
+			}
+			if opts.Network != "" {
+				if err := checkNetworkPolicy(agentCfg, opts.Network); err != nil {
+					log.Fatalf("Invalid frontmatter for %s: %v", agentName, err)
+				}
+			}
+			if opts.Sandbox.SandboxOnly() && !agentCfg.SandboxEnabled {
+				log.Printf("Warning: sandbox profile for %s only applies to sandboxed runs", agentName)
+			}
+			if opts.Sandbox.ReadOnlyWorkDir && !agentCfg.SandboxEnabled {
+				if err := kiro.ReadOnlySupported(); err != nil {
+					log.Fatalf("Invalid frontmatter for %s: readonly cannot be enforced on this host: %v", agentName, err)
+				}
+			}
+			if isolation, err = workspace.ParseIsolation(metadata.Isolation); err != nil {
+				log.Fatalf("Invalid frontmatter for %s: %v", agentName, err)
+			}
+			if isolation == workspace.IsolationWorktree {
+				agentRunner := runner
+				if opts.Runner != nil {
+					agentRunner = opts.Runner
+				}
+				if err := checkWorktreeRunner(agentRunner); err != nil {
+					log.Fatalf("Invalid frontmatter for %s: %v", agentName, err)
+				}
+			}
+			if metadata.Writer != nil && *metadata.Writer && metadata.ReadOnly {
+				log.Fatalf("Invalid frontmatter for %s: writer conflicts with readonly", agentName)
+			}
+			writer = metadata.Writer
+			if metadata.Snapshot != nil {
+				snapshot = *metadata.Snapshot
+			}
+			executor.SetAgentOptions(agentName, opts)
 			log.Printf("Loaded frontmatter for %s (model: %s)", agentName, model)
 		} else if err != nil {
 			log.Printf("Failed to load frontmatter for %s: %v", agentName, err)
 		}
 		
-		handler := createHandler(agentName, model, sessionMgr, executor, cfg)
+		// Snapshotting walks, and outside git copies, the working directory
+		// on every call, so only agents that ask for it pay for it
+		var agentCalls *workspace.Calls
+		if snapshot {
+			agentCalls = calls
+			snapshotted = true
+		}
+		handler := createHandler(agentName, model, sessionMgr, executor, agentCalls, cfg)
+		handler = leaseDirectory(agentName, handler, leases, agentLeaseMode(writer, executor.ReadOnlyWorkDir(agentName), snapshot))
+		switch isolation {
+		case workspace.IsolationCopy:
+			// Sandboxed agents cannot see the source's object store to borrow it
+			shareObjects := checkWorktreeRunner(executor.RunnerFor(agentName)) == nil
+			handler = isolateCopy(agentName, handler, changes, leases, shareObjects)
+			copyIsolation = true
+		case workspace.IsolationWorktree:
+			handler = isolateWorktree(handler, sessionMgr)
+			worktreeIsolation = true
+		}
+		handlers[agentName] = handler
 		tool := &mcp.Tool{
 			Name:        toolName,
 			Description: description,
@@ -174,6 +456,14 @@ func main() {
 		log.Printf("Registered tool: %s (agent: %s)", toolName, agentName)
 	}
 
+	if preview {
+		input := ToolInput{Prompt: previewPrompt, SessionID: *previewSessionID, Directory: *previewDirectory}
+		if err := runPreview(handlers, previewAgent, input); err != nil {
+			log.Fatalf("Preview failed: %v", err)
+		}
+		return
+	}
+
 	// Register health-check tool
 	healthTool := &mcp.Tool{
 		Name:        cfg.ToolPrefix + "health-check",
@@ -194,16 +484,20 @@ func main() {
 			totalSuccess += metrics.SuccessCalls
 
 			agentStats = append(agentStats, map[string]interface{}{
-				"agent":        agent,
-				"totalCalls":   metrics.TotalCalls,
-				"successCalls": metrics.SuccessCalls,
-				"failedCalls":  metrics.FailedCalls,
-				"timeoutCalls": metrics.TimeoutCalls,
-				"successRate":  fmt.Sprintf("%.1f%%", metrics.SuccessRate()*100),
-				"avgDuration":  metrics.AvgDuration().String(),
-				"lastSuccess":  metrics.LastSuccess.Format(time.RFC3339),
-				"lastFailure":  metrics.LastFailure.Format(time.RFC3339),
-				"lastError":    metrics.LastError,
+				"agent":              agent,
+				"totalCalls":         metrics.TotalCalls,
+				"successCalls":       metrics.SuccessCalls,
+				"failedCalls":        metrics.FailedCalls,
+				"timeoutCalls":       metrics.TimeoutCalls,
+				"resourceLimitCalls": metrics.ResourceLimitCalls,
+				"successRate":        fmt.Sprintf("%.1f%%", metrics.SuccessRate()*100),
+				"avgDuration":        metrics.AvgDuration().String(),
+				"avgQueueWait":       metrics.AvgQueueWait().String(),
+				"maxQueueWait":       metrics.MaxQueueWait.String(),
+				"lastSuccess":        metrics.LastSuccess.Format(time.RFC3339),
+				"lastFailure":        metrics.LastFailure.Format(time.RFC3339),
+				"lastError":          metrics.LastError,
+				"errorClasses":       metrics.ErrorClasses,
 			})
 		}
 
@@ -219,130 +513,338 @@ func main() {
 		}
 		result["agents"] = agentStats
 
+		queueStats := slots.Stats()
+		result["queue"] = map[string]interface{}{
+			"running": queueStats.Running,
+			"waiting": queueStats.Waiting,
+		}
+
+		leaseStats := make([]map[string]interface{}, 0)
+		for _, l := range leases.List() {
+			state := "held"
+			if l.Waiting {
+				state = "waiting"
+			}
+			leaseStats = append(leaseStats, map[string]interface{}{
+				"directory": l.Dir,
+				"mode":      l.Mode,
+				"state":     state,
+				"agent":     l.Holder.Agent,
+				"sessionId": l.Holder.SessionID,
+				"since":     l.Since.Format(time.RFC3339),
+			})
+		}
+		result["leases"] = leaseStats
+
 		return nil, result, nil
 	}
 
 	mcp.AddTool(server, healthTool, healthHandler)
 	log.Printf("Registered health-check tool")
 
+	taskMgr := tasks.NewManager(ctx)
+	taskMgr.SetRetention(cfg.TaskTTL, cfg.MaxFinishedTasks)
+	registerTaskTools(server, cfg.ToolPrefix, taskMgr, handlers)
+	registerFanOutTool(server, cfg.ToolPrefix, cfg.FanOutConcurrency, handlers)
+	if snapshotted {
+		registerUndoTool(server, cfg.ToolPrefix, calls, leases)
+	}
+	if copyIsolation {
+		registerChangeTools(server, cfg.ToolPrefix, changes, leases)
+	}
+	if worktreeIsolation {
+		registerWorktreeTools(server, cfg.ToolPrefix, sessionMgr, leases)
+	}
+
 	log.Printf("Starting Kiro sub-agents MCP server with %d agents", len(agentList))
 	if cfg.SandboxEnabled {
-		log.Printf("Sandbox mode enabled with image: %s", cfg.SandboxImage)
+		if containerized {
+			log.Printf("Sandbox mode enabled with image: %s (runtime: %s, warm: %v)", cfg.SandboxImage, containerRT, cfg.SandboxWarm)
+		} else {
+			log.Printf("Sandbox mode enabled with backend: %s", cfg.SandboxBackend)
+		}
 	}
-	if err := server.Run(ctx, &mcp.StdioTransport{}); err != nil {
+	if cfg.ListenAddr != "" {
+		listener, err := net.Listen("tcp", cfg.ListenAddr)
+		if err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+		if err := serveHTTP(ctx, server, listener, cfg.HTTPToken); err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	} else if err := server.Run(ctx, &mcp.StdioTransport{}); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 
+	calls.Cleanup()
 	sessionMgr.Cleanup()
+	changes.Cleanup()
+	egressGateway.Close()
 }
 
-func createHandler(agentName, model string, sessionMgr *sessions.Manager, executor *kiro.Executor, cfg *config.Config) func(context.Context, *mcp.CallToolRequest, ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
-	return func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
-		if input.Prompt == "" {
-			return nil, ToolOutput{}, fmt.Errorf("prompt is required")
-		}
+// newRunner returns the execution backend selected by the sandbox flags.
+func newRunner(cfg *config.Config, containerRT container.Runtime, sessionMgr *sessions.Manager, egressProvider kiro.EgressProvider) (kiro.Runner, error) {
+	if !cfg.SandboxEnabled {
+		return kiro.NewDirectRunner(cfg.KiroBinary), nil
+	}
+	if cfg.SandboxWarm && cfg.SandboxBackend != "container" {
+		return nil, fmt.Errorf("--sandbox-warm requires the container backend")
+	}
 
-		if input.Directory == "" {
-			return nil, ToolOutput{}, fmt.Errorf("directory is required")
+	switch cfg.SandboxBackend {
+	case "container":
+		docker := kiro.NewDockerRunner(containerRT, cfg.SandboxImage)
+		docker.Egress = egressProvider
+		if cfg.SandboxWarm {
+			return kiro.NewWarmDockerRunner(docker, sessionMgr), nil
+		}
+		return docker, nil
+	case "bwrap":
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("the bwrap backend is only available on Linux")
+		}
+		if _, err := exec.LookPath("bwrap"); err != nil {
+			return nil, fmt.Errorf("the bwrap backend requires bubblewrap: %w", err)
+		}
+		if err := kiro.CheckBwrap("bwrap"); err != nil {
+			return nil, err
 		}
+		return kiro.NewBwrapRunner(cfg.KiroBinary), nil
+	}
+	return nil, fmt.Errorf("unknown sandbox backend %q (expected container or bwrap)", cfg.SandboxBackend)
+}
 
-		sessionDir, err := sessionMgr.GetWorkspaceDir(input.SessionID)
+// sandboxOverride returns the settings for an agent whose sandbox profile
+// turns sandboxing on or off against --sandbox. Warm containers are only
+// reaped with --sandbox, so per-agent sandboxes always use a container per run.
+func sandboxOverride(cfg *config.Config, enabled bool) *config.Config {
+	agentCfg := *cfg
+	agentCfg.SandboxEnabled = enabled
+	agentCfg.SandboxWarm = false
+	return &agentCfg
+}
+
+// checkNetworkPolicy reports whether the selected backend can enforce policy.
+// Without a sandbox policies are not enforced at all, which is only worth a
+// warning since the default is full access.
+func checkNetworkPolicy(cfg *config.Config, policy kiro.NetworkPolicy) error {
+	if policy == kiro.NetworkFull {
+		return nil
+	}
+	if !cfg.SandboxEnabled {
+		log.Printf("Warning: network policy %s is only enforced in sandbox mode", policy)
+		return nil
+	}
+	if policy == kiro.NetworkKiroAPIOnly && cfg.SandboxBackend == "bwrap" {
+		return fmt.Errorf("network policy %s requires the container backend", policy)
+	}
+	if policy == kiro.NetworkKiroAPIOnly {
+		// The egress proxy listens on the internal network's gateway, which
+		// a rootless runtime keeps out of the host's network namespace
+		runtime, err := container.Parse(cfg.ContainerRuntime)
+		if err != nil {
+			return err
+		}
+		rootless, err := runtime.Rootless()
 		if err != nil {
-			return nil, ToolOutput{}, fmt.Errorf("failed to create workspace: %w", err)
+			log.Printf("Warning: cannot tell whether %s is rootless: %v", runtime, err)
+		} else if rootless {
+			return fmt.Errorf("network policy %s is not supported with rootless %s: the egress proxy cannot listen on the %s network's gateway; use --sandbox-network none or full, or a rootful runtime", policy, runtime, egress.NetworkName)
 		}
+	}
+	return nil
+}
 
-		sessionID := sessionMgr.GetSessionID(sessionDir)
+func newRetryPolicy(cfg *config.Config) (kiro.RetryPolicy, error) {
+	policy := kiro.DefaultRetryPolicy()
+	policy.MaxAttempts = cfg.RetryMaxAttempts
+	policy.InitialBackoff = cfg.RetryInitialBackoff
+	policy.MaxBackoff = cfg.RetryMaxBackoff
+	policy.Jitter = cfg.RetryJitter
+	policy.MaxElapsed = cfg.RetryMaxElapsed
 
-		// Generate unique response file name
-		responseFile := kiro.GetUniqueResponseFile(sessionDir)
+	retryOn, err := kiro.ParseErrorClasses(cfg.RetryOn)
+	if err != nil {
+		return policy, err
+	}
+	policy.RetryOn = retryOn
 
-		// Determine working directory path for prompt
-		workingDir := input.Directory
-		if cfg.SandboxEnabled {
-			workingDir = "/workspace"
-		}
+	return policy, policy.Validate()
+}
 
-		// Augment prompt with directory instruction
-		enhancedPrompt := fmt.Sprintf("In directory %s, %s", workingDir, input.Prompt)
+// agentOptions builds per-agent execution settings from frontmatter.
+func agentOptions(metadata *frontmatter.AgentMetadata, basePolicy kiro.RetryPolicy) (kiro.AgentOptions, error) {
+	opts := kiro.AgentOptions{
+		MaxConcurrent: metadata.MaxConcurrent,
+		Priority:      metadata.Priority,
+	}
 
-		// Load and inject system prompt with response file placeholder
-		if systemPromptTemplate, err := os.ReadFile(cfg.SystemPromptPath); err == nil {
-			// Response file path must be absolute so agent knows where to write
-			responseFilePath := filepath.Join(sessionDir, responseFile)
-			if cfg.SandboxEnabled {
-				responseFilePath = "/root/.local/share/kiro-cli/" + responseFile
+	if rc := metadata.Retry; rc != nil {
+		policy := basePolicy
+		if rc.MaxAttempts > 0 {
+			policy.MaxAttempts = rc.MaxAttempts
+		}
+		if rc.InitialBackoff > 0 {
+			policy.InitialBackoff = rc.InitialBackoff
+		}
+		if rc.MaxBackoff > 0 {
+			policy.MaxBackoff = rc.MaxBackoff
+		}
+		if rc.Multiplier > 0 {
+			policy.Multiplier = rc.Multiplier
+		}
+		if rc.Jitter != nil {
+			policy.Jitter = *rc.Jitter
+		}
+		if rc.MaxElapsed > 0 {
+			policy.MaxElapsed = rc.MaxElapsed
+		}
+		if rc.RetryOn != nil {
+			retryOn, err := kiro.ParseErrorClasses(strings.Join(rc.RetryOn, ","))
+			if err != nil {
+				return opts, err
 			}
-			systemPrompt := strings.ReplaceAll(string(systemPromptTemplate), "{{RESPONSE_FILE}}", responseFilePath)
-			systemPrompt = strings.ReplaceAll(systemPrompt, "{{WORKING_DIRECTORY}}", workingDir)
-			enhancedPrompt = enhancedPrompt + "\n\n" + systemPrompt
+			policy.RetryOn = retryOn
 		}
-
-		// Pass working directory for sandbox mount
-		result := executor.ExecuteWithWorkDir(ctx, agentName, enhancedPrompt, sessionDir, input.SessionID, model, input.Directory, responseFile)
-		if result.Error != nil {
-			// Return error in response body with sessionID so orchestrator can retry
-			return nil, ToolOutput{
-				Response:  fmt.Sprintf("ERROR: %v", result.Error),
-				SessionID: sessionID,
-			}, nil
+		if err := policy.Validate(); err != nil {
+			return opts, err
 		}
+		opts.Retry = &policy
+	}
 
-		// Determine response file path
-		var responsePath string
-		if cfg.SandboxEnabled {
-			responsePath = "/root/.local/share/kiro-cli/" + responseFile
-		} else {
-			responsePath = filepath.Join(sessionDir, responseFile)
+	if rc := metadata.Resources; rc != nil {
+		resources := kiro.Resources{
+			CPUs:      rc.CPUs,
+			Memory:    rc.Memory,
+			PIDs:      rc.PIDs,
+			TmpfsSize: rc.TmpfsSize,
 		}
+		if err := resources.Validate(); err != nil {
+			return opts, err
+		}
+		opts.Resources = &resources
+	}
 
-		// Try to read response file first
-		responseOutput := result.Output
-		var responseFound bool
-		if cfg.SandboxEnabled {
-			if content := readResponseFromVolume(sessionID, responseFile); content != "" {
-				responseOutput = content
-				responseFound = true
-			}
-		} else if content, err := os.ReadFile(responsePath); err == nil {
-			responseOutput = strings.TrimSpace(string(content))
-			responseFound = true
-		}
-
-		// Fallback: Request file creation using template
-		if !responseFound {
-			if contextSummaryTemplate, err := os.ReadFile(cfg.ContextSummaryPath); err == nil {
-				fallbackPrompt := strings.ReplaceAll(string(contextSummaryTemplate), "{{RESPONSE_FILE}}", responsePath)
-
-				fallbackResult := executor.ExecuteWithWorkDir(ctx, agentName, fallbackPrompt, sessionDir, sessionID, model, input.Directory, responseFile)
-				if fallbackResult.Error == nil {
-					if cfg.SandboxEnabled {
-						if content := readResponseFromVolume(sessionID, responseFile); content != "" {
-							responseOutput = content
-						}
-					} else if content, err := os.ReadFile(responsePath); err == nil {
-						responseOutput = strings.TrimSpace(string(content))
-					}
-				}
+	network := metadata.Network
+	if sc := metadata.Sandbox; sc != nil {
+		profile, err := sandboxProfile(sc)
+		if err != nil {
+			return opts, err
+		}
+		if metadata.ReadOnly && sc.WorkDir == "rw" {
+			return opts, fmt.Errorf("readonly conflicts with sandbox.workdir rw")
+		}
+		opts.Sandbox = profile
+		if sc.Network != "" {
+			if network != "" && network != sc.Network {
+				return opts, fmt.Errorf("network %q conflicts with sandbox.network %q", network, sc.Network)
 			}
+			network = sc.Network
 		}
+	}
+
+	if metadata.ReadOnly {
+		opts.Sandbox.ReadOnlyWorkDir = true
+	}
 
-		return nil, ToolOutput{
-			Response:  responseOutput,
-			SessionID: sessionID,
-		}, nil
+	if network != "" {
+		policy, err := kiro.ParseNetworkPolicy(network)
+		if err != nil {
+			return opts, err
+		}
+		opts.Network = policy
 	}
+
+	return opts, nil
 }
 
-func readResponseFromVolume(sessionID, responseFile string) string {
-	volumeName := "budgie-session-" + sessionID
-	cmd := exec.Command("docker", "run", "--rm",
-		"-v", volumeName+":/data:ro",
-		"alpine:latest",
-		"cat", "/data/"+responseFile)
+// sandboxProfile converts a frontmatter sandbox block. Enabled and network
+// are handled by the caller.
+func sandboxProfile(sc *frontmatter.SandboxConfig) (kiro.SandboxProfile, error) {
+	profile := kiro.SandboxProfile{
+		Image: sc.Image,
+		Env:   sc.Env,
+	}
+
+	switch sc.WorkDir {
+	case "", "rw":
+	case "ro":
+		profile.ReadOnlyWorkDir = true
+	default:
+		return profile, fmt.Errorf("unknown sandbox workdir mode %q (expected ro or rw)", sc.WorkDir)
+	}
+
+	for _, m := range sc.Mounts {
+		mount, err := kiro.ParseMount(m)
+		if err != nil {
+			return profile, err
+		}
+		profile.Mounts = append(profile.Mounts, mount)
+	}
+	return profile, nil
+}
 
-	output, err := cmd.Output()
+// checkListenAddr refuses to serve HTTP beyond the loopback interface
+// without a token: anyone who can reach the port can run agents that write
+// to the host.
+func checkListenAddr(addr, token string) error {
+	if token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return ""
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address; set --http-token to serve it", addr)
+}
+
+// requireToken wraps handler so requests without the bearer token are
+// rejected.
+func requireToken(handler http.Handler, token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// serveHTTP serves the MCP server over Streamable HTTP on listener until ctx
+// is cancelled. All clients share the same server, and with it the same
+// health monitor and session manager. With a token, clients must send it as
+// a bearer token.
+func serveHTTP(ctx context.Context, server *mcp.Server, listener net.Listener, token string) error {
+	var handler http.Handler = mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server {
+		return server
+	}, nil)
+	if token != "" {
+		handler = requireToken(handler, token)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", handler)
+
+	httpServer := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Listening for MCP Streamable HTTP on http://%s/mcp", listener.Addr())
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
 	}
-	return strings.TrimSpace(string(output))
+	return nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"budgie/internal/kiro"
+)
+
+type PreviewOutput struct {
+	Prompt     string   `json:"prompt"`
+	Command    []string `json:"command"`
+	Dir        string   `json:"dir,omitempty"`
+	Env        []string `json:"env,omitempty"`
+	InheritEnv bool     `json:"inheritEnv"`
+	Mounts     []string `json:"mounts,omitempty"`
+	// PromptDelivery is argv, stdin or file
+	PromptDelivery string `json:"promptDelivery"`
+}
+
+func newPreviewOutput(prompt string, p kiro.Preview) *PreviewOutput {
+	return &PreviewOutput{
+		Prompt:     prompt,
+		Command:    p.Command,
+		Dir:        p.Dir,
+		Env:        p.Env,
+		InheritEnv: p.InheritEnv,
+		Mounts:     p.Mounts,
+
+		PromptDelivery: string(p.PromptDelivery),
+	}
+}
+
+// runPreview dry-runs an agent call and prints the preview as JSON, for the
+// preview subcommand.
+func runPreview(handlers map[string]agentHandler, agentName string, input ToolInput) error {
+	handler, ok := handlers[agentName]
+	if !ok {
+		return fmt.Errorf("unknown agent: %s", agentName)
+	}
+
+	input.DryRun = true
+	_, output, err := handler(context.Background(), nil, input)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(output.Preview)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"budgie/internal/config"
+	"budgie/internal/fakekiro"
+	"budgie/internal/health"
+	"budgie/internal/kiro"
+	"budgie/internal/sessions"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// TestMain lets the test binary double as kiro-cli: round-trip tests run
+// it with a fake kiro-cli script in the environment.
+func TestMain(m *testing.M) {
+	if os.Getenv(fakekiro.ScriptEnv) != "" {
+		os.Exit(fakekiro.Main(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// roundTrip is an MCP client connected to a server whose developer tool
+// runs the fake kiro-cli.
+type roundTrip struct {
+	session  *mcp.ClientSession
+	executor *kiro.Executor
+	project  string
+	log      string
+}
+
+// newRoundTrip serves the developer agent with the repository's system and
+// context summary prompts, running the fake kiro-cli with script.
+func newRoundTrip(t *testing.T, script string, timeout time.Duration, retry kiro.RetryPolicy) *roundTrip {
+	t.Helper()
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "script.txt")
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rt := &roundTrip{project: t.TempDir(), log: filepath.Join(dir, "calls.log")}
+	t.Setenv(fakekiro.ScriptEnv, scriptPath)
+	t.Setenv(fakekiro.LogEnv, rt.log)
+
+	cfg := &config.Config{
+		SystemPromptPath:   filepath.Join("..", "..", "agents", "prompts", "_system.md"),
+		ContextSummaryPath: filepath.Join("..", "..", "agents", "prompts", "_context-summary.md"),
+	}
+	executor := kiro.NewExecutor(os.Args[0], timeout, health.NewMonitor(), false, "", false)
+	executor.SetRetryPolicy(retry)
+	executor.SetKillGrace(100 * time.Millisecond)
+	rt.executor = executor
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "kiro-subagents", Version: "test"}, nil)
+	handler := createHandler("developer", "test-model", sessionMgr, executor, newCalls(t), cfg)
+	mcp.AddTool(server, &mcp.Tool{Name: "kiro-subagents.developer"}, handler)
+
+	ctx := context.Background()
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	if err != nil {
+		t.Fatalf("Server connect failed: %v", err)
+	}
+	client := mcp.NewClient(&mcp.Implementation{Name: "orchestrator", Version: "test"}, nil)
+	rt.session, err = client.Connect(ctx, clientTransport, nil)
+	if err != nil {
+		t.Fatalf("Client connect failed: %v", err)
+	}
+	t.Cleanup(func() {
+		rt.session.Close()
+		serverSession.Wait()
+	})
+	return rt
+}
+
+// call calls the developer tool and decodes its output.
+func (rt *roundTrip) call(t *testing.T, input ToolInput) ToolOutput {
+	t.Helper()
+	result, err := rt.session.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      "kiro-subagents.developer",
+		Arguments: input,
+	})
+	if err != nil {
+		t.Fatalf("CallTool failed: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("Tool returned an error: %+v", result.Content)
+	}
+
+	data, err := json.Marshal(result.StructuredContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output ToolOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatalf("Failed to decode output %s: %v", data, err)
+	}
+	return output
+}
+
+func (rt *roundTrip) invocations(t *testing.T) []fakekiro.Invocation {
+	t.Helper()
+	invocations, err := fakekiro.ReadLog(rt.log)
+	if err != nil {
+		t.Fatalf("Failed to read fake kiro-cli log: %v", err)
+	}
+	return invocations
+}
+
+func noRetries() kiro.RetryPolicy {
+	return kiro.RetryPolicy{MaxAttempts: 1}
+}
+
+func TestRoundTrip_ResponseFileAndResume(t *testing.T) {
+	rt := newRoundTrip(t, `
+stdout thinking out loud
+respond validated the signup form
+write signup.go package signup
+`, time.Minute, noRetries())
+
+	first := rt.call(t, ToolInput{Prompt: "add validation", Directory: rt.project})
+	if first.Response != "validated the signup form" {
+		t.Errorf("Expected the response file, got %q", first.Response)
+	}
+	if first.SessionID == "" || first.CallID == "" {
+		t.Errorf("Expected a session and call ID, got %+v", first)
+	}
+	if len(first.ChangedFiles) != 1 || first.ChangedFiles[0].Path != "signup.go" {
+		t.Errorf("Expected signup.go to be reported, got %+v", first.ChangedFiles)
+	}
+
+	second := rt.call(t, ToolInput{Prompt: "now add tests", Directory: rt.project, SessionID: first.SessionID})
+	if second.SessionID != first.SessionID {
+		t.Errorf("Expected session %s to continue, got %s", first.SessionID, second.SessionID)
+	}
+
+	invocations := rt.invocations(t)
+	if len(invocations) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(invocations))
+	}
+	if invocations[0].Resume || !invocations[1].Resume {
+		t.Errorf("Expected only the second turn to resume, got %+v", invocations)
+	}
+	if fakekiro.WorkDir(invocations[1].Prompt) != rt.project {
+		t.Errorf("Expected the prompt to name %s, got %q", rt.project, invocations[1].Prompt)
+	}
+}
+
+func TestRoundTrip_ContextSummaryFallback(t *testing.T) {
+	rt := newRoundTrip(t, `
+stdout forgot the response file
+
+match previous response
+respond summary of the work
+`, time.Minute, noRetries())
+
+	output := rt.call(t, ToolInput{Prompt: "add validation", Directory: rt.project})
+	if output.Response != "summary of the work" {
+		t.Errorf("Expected the fallback response, got %q", output.Response)
+	}
+	if invocations := rt.invocations(t); len(invocations) != 2 || !invocations[1].Resume {
+		t.Errorf("Expected a resumed fallback run, got %+v", invocations)
+	}
+}
+
+func TestRoundTrip_StdoutWhenNoResponseFile(t *testing.T) {
+	rt := newRoundTrip(t, "stdout answer on stdout\n", time.Minute, noRetries())
+
+	output := rt.call(t, ToolInput{Prompt: "add validation", Directory: rt.project})
+	if output.Response != "answer on stdout" {
+		t.Errorf("Expected stdout, got %q", output.Response)
+	}
+}
+
+func TestRoundTrip_Failures(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		class   kiro.ErrorClass
+	}{
+		{"timeout", "sleep 1m\nrespond too late\n", 500 * time.Millisecond, kiro.ErrorTimeout},
+		{"crash", "write partial.txt half done\ncrash\n", time.Minute, kiro.ErrorCrash},
+		{"exit", "stderr something broke\nexit 3\n", time.Minute, kiro.ErrorExit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newRoundTrip(t, tt.script, tt.timeout, noRetries())
+
+			output := rt.call(t, ToolInput{Prompt: "add validation", Directory: rt.project})
+			if output.ErrorClass != string(tt.class) {
+				t.Errorf("Expected error class %s, got %q (%s)", tt.class, output.ErrorClass, output.Response)
+			}
+			if output.SessionID == "" {
+				t.Error("Expected a session ID so the orchestrator can retry")
+			}
+		})
+	}
+}
+
+func TestRoundTrip_RetriesCrash(t *testing.T) {
+	retry := kiro.RetryPolicy{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond, Multiplier: 1, RetryOn: []kiro.ErrorClass{kiro.ErrorCrash}}
+	rt := newRoundTrip(t, `
+call 1
+crash
+
+call 2
+respond second attempt
+`, time.Minute, retry)
+
+	output := rt.call(t, ToolInput{Prompt: "add validation", Directory: rt.project})
+	if output.ErrorClass != "" || output.Response != "second attempt" {
+		t.Errorf("Expected the retry to succeed, got %+v", output)
+	}
+	if invocations := rt.invocations(t); len(invocations) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", len(invocations))
+	}
+}
+
+func TestRoundTrip_RecordAndReplay(t *testing.T) {
+	cassettes := t.TempDir()
+	recorder, err := kiro.NewRecorder(cassettes)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	rt := newRoundTrip(t, `
+match previous response
+respond summary of the tests
+
+call 1
+respond validated the signup form
+
+call 2
+stdout forgot the response file
+`, time.Minute, noRetries())
+	rt.executor.SetRecorder(recorder)
+
+	first := rt.call(t, ToolInput{Prompt: "add validation", Directory: rt.project})
+	second := rt.call(t, ToolInput{Prompt: "now add tests", Directory: rt.project, SessionID: first.SessionID})
+
+	// Replay in another project with a script that would fail every run
+	replayer, err := kiro.NewReplayer(cassettes)
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+	if replayer.Len() != 3 {
+		t.Fatalf("Expected 3 cassettes, got %d", replayer.Len())
+	}
+	replay := newRoundTrip(t, "exit 1\n", time.Minute, noRetries())
+	replay.executor.SetReplayer(replayer)
+
+	replayedFirst := replay.call(t, ToolInput{Prompt: "add validation", Directory: replay.project})
+	if replayedFirst.Response != first.Response || replayedFirst.ErrorClass != "" {
+		t.Errorf("Expected %q, got %+v", first.Response, replayedFirst)
+	}
+	replayedSecond := replay.call(t, ToolInput{Prompt: "now add tests", Directory: replay.project, SessionID: replayedFirst.SessionID})
+	if replayedSecond.Response != second.Response || second.Response != "summary of the tests" {
+		t.Errorf("Expected %q, got %q", second.Response, replayedSecond.Response)
+	}
+	if _, err := os.Stat(replay.log); !os.IsNotExist(err) {
+		t.Error("Expected kiro-cli not to run during replay")
+	}
+}
+
+func TestRoundTrip_PromptDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		delivery kiro.PromptDelivery
+		argMax   int
+		want     string
+	}{
+		{"stdin", kiro.PromptStdin, kiro.DefaultPromptArgMax, "stdin"},
+		{"file", kiro.PromptFile, kiro.DefaultPromptArgMax, "file"},
+		{"argv fallback", kiro.PromptArg, 10, "file"},
+		{"default", "", 0, "stdin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newRoundTrip(t, "respond got it\n", time.Minute, noRetries())
+			if tt.delivery != "" {
+				rt.executor.SetPromptDelivery(tt.delivery, tt.argMax)
+			}
+
+			output := rt.call(t, ToolInput{Prompt: "add validation", Directory: rt.project})
+			if output.Response != "got it" {
+				t.Errorf("Expected the response file, got %q (%s)", output.Response, output.ErrorClass)
+			}
+
+			invocations := rt.invocations(t)
+			if len(invocations) != 1 {
+				t.Fatalf("Expected 1 run, got %d", len(invocations))
+			}
+			inv := invocations[0]
+			if inv.Delivery != tt.want || fakekiro.WorkDir(inv.Prompt) != rt.project {
+				t.Errorf("Expected the prompt by %s, got %s: %q", tt.want, inv.Delivery, inv.Prompt)
+			}
+			for _, arg := range inv.Args {
+				if strings.Contains(arg, "add validation") {
+					t.Errorf("Expected the prompt to stay out of the arguments, got %q", arg)
+				}
+			}
+		})
+	}
+}
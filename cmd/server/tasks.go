@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"budgie/internal/tasks"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type StartTaskInput struct {
+	Agent     string `json:"agent"`
+	Prompt    string `json:"prompt"`
+	SessionID string `json:"sessionId,omitempty"`
+	Directory string `json:"directory,omitempty"`
+}
+
+type TaskInput struct {
+	TaskID string `json:"taskId"`
+}
+
+type TaskOutput struct {
+	TaskID     string `json:"taskId"`
+	Agent      string `json:"agent"`
+	Status     string `json:"status"`
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+	Duration   string `json:"duration"`
+	SessionID  string `json:"sessionId,omitempty"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+func newTaskOutput(task tasks.Task, withResult bool) TaskOutput {
+	output := TaskOutput{
+		TaskID:     task.ID,
+		Agent:      task.Agent,
+		Status:     string(task.Status),
+		StartedAt:  task.StartedAt.Format(time.RFC3339),
+		Duration:   task.Duration().Round(time.Second).String(),
+		SessionID:  task.SessionID,
+		Error:      task.Error,
+		ErrorClass: task.ErrorClass,
+	}
+	if !task.FinishedAt.IsZero() {
+		output.FinishedAt = task.FinishedAt.Format(time.RFC3339)
+	}
+	if withResult {
+		output.Response = task.Response
+	}
+	return output
+}
+
+// registerTaskTools registers the asynchronous task tools. start-task runs an
+// agent handler in the background and returns immediately, so long agent runs
+// do not hold the orchestrator's tool call open.
+func registerTaskTools(server *mcp.Server, toolPrefix string, taskMgr *tasks.Manager, handlers map[string]agentHandler) {
+	startTool := &mcp.Tool{
+		Name:        toolPrefix + "start-task",
+		Description: "Start a sub-agent call in the background and return a task ID immediately. Poll with task-status/task-result, stop with cancel-task. Takes the same prompt, directory and sessionId as the agent tools plus the agent name.",
+	}
+	mcp.AddTool(server, startTool, func(ctx context.Context, req *mcp.CallToolRequest, input StartTaskInput) (*mcp.CallToolResult, TaskOutput, error) {
+		handler, ok := handlers[input.Agent]
+		if !ok {
+			return nil, TaskOutput{}, fmt.Errorf("unknown agent: %s", input.Agent)
+		}
+		if input.Prompt == "" {
+			return nil, TaskOutput{}, fmt.Errorf("prompt is required")
+		}
+		if input.Directory == "" {
+			return nil, TaskOutput{}, fmt.Errorf("directory is required")
+		}
+
+		toolInput := ToolInput{
+			Prompt:    input.Prompt,
+			SessionID: input.SessionID,
+			Directory: input.Directory,
+		}
+		task := taskMgr.Start(input.Agent, func(ctx context.Context) (string, string, string, error) {
+			_, output, err := handler(ctx, nil, toolInput)
+			if err == nil && output.ErrorClass != "" {
+				err = fmt.Errorf("%s", strings.TrimPrefix(output.Response, "ERROR: "))
+			}
+			return output.Response, output.SessionID, output.ErrorClass, err
+		})
+
+		log.Printf("Started task %s (agent: %s)", task.ID, input.Agent)
+		return nil, newTaskOutput(task, false), nil
+	})
+
+	statusTool := &mcp.Tool{
+		Name:        toolPrefix + "task-status",
+		Description: "Get the status of a background task started with start-task: running, completed, failed or cancelled",
+	}
+	mcp.AddTool(server, statusTool, func(ctx context.Context, req *mcp.CallToolRequest, input TaskInput) (*mcp.CallToolResult, TaskOutput, error) {
+		task, ok := taskMgr.Get(input.TaskID)
+		if !ok {
+			return nil, TaskOutput{}, fmt.Errorf("unknown task: %s", input.TaskID)
+		}
+		return nil, newTaskOutput(task, false), nil
+	})
+
+	resultTool := &mcp.Tool{
+		Name:        toolPrefix + "task-result",
+		Description: "Get the result of a background task started with start-task. The response is empty while the task is still running.",
+	}
+	mcp.AddTool(server, resultTool, func(ctx context.Context, req *mcp.CallToolRequest, input TaskInput) (*mcp.CallToolResult, TaskOutput, error) {
+		task, ok := taskMgr.Get(input.TaskID)
+		if !ok {
+			return nil, TaskOutput{}, fmt.Errorf("unknown task: %s", input.TaskID)
+		}
+		return nil, newTaskOutput(task, true), nil
+	})
+
+	cancelTool := &mcp.Tool{
+		Name:        toolPrefix + "cancel-task",
+		Description: "Cancel a running background task, killing its kiro-cli process",
+	}
+	mcp.AddTool(server, cancelTool, func(ctx context.Context, req *mcp.CallToolRequest, input TaskInput) (*mcp.CallToolResult, TaskOutput, error) {
+		task, err := taskMgr.Cancel(input.TaskID)
+		if err != nil {
+			return nil, TaskOutput{}, err
+		}
+		log.Printf("Cancelled task %s (agent: %s)", task.ID, task.Agent)
+		return nil, newTaskOutput(task, true), nil
+	})
+
+	log.Printf("Registered task tools")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"budgie/internal/kiro"
+	"budgie/internal/lease"
+	"budgie/internal/sessions"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type WorktreeInput struct {
+	SessionID string `json:"sessionId"`
+}
+
+type RemoveWorktreeInput struct {
+	SessionID  string `json:"sessionId"`
+	KeepBranch bool   `json:"keepBranch,omitempty"`
+}
+
+type WorktreeOutput struct {
+	SessionID string `json:"sessionId"`
+	Branch    string `json:"branch"`
+	Worktree  string `json:"worktree"`
+	Directory string `json:"directory"`
+	Status    string `json:"status"`
+}
+
+type ListWorktreesOutput struct {
+	Worktrees []WorktreeOutput `json:"worktrees"`
+}
+
+func newWorktreeOutput(wt sessions.Worktree, status string) WorktreeOutput {
+	return WorktreeOutput{
+		SessionID: wt.SessionID,
+		Branch:    wt.Branch,
+		Worktree:  wt.Path,
+		Directory: wt.Repo,
+		Status:    status,
+	}
+}
+
+// checkWorktreeRunner returns an error if runner cannot run agents in a
+// session worktree. A worktree's .git file points at the repository's git
+// directory by host path, which containers and bubblewrap do not mount, so
+// git would fail inside them.
+func checkWorktreeRunner(runner kiro.Runner) error {
+	if _, ok := runner.(*kiro.BwrapRunner); ok || runner.Containerized() {
+		return fmt.Errorf("worktree isolation is not supported in sandbox mode, where the repository's .git directory is not mounted")
+	}
+	return nil
+}
+
+// isolateWorktree wraps an agent handler so every turn of a session runs in
+// the session's own git worktree and branch, created on its first call.
+// Concurrent sessions on one repository then edit separate trees.
+func isolateWorktree(handler agentHandler, sessionMgr *sessions.Manager) agentHandler {
+	return func(ctx context.Context, req *mcp.CallToolRequest, input ToolInput) (*mcp.CallToolResult, ToolOutput, error) {
+		if input.Directory == "" {
+			return handler(ctx, req, input)
+		}
+		if input.DryRun {
+			// Preview in the session's worktree if it has one, but do not
+			// create one
+			if wt, ok := sessionMgr.Worktree(input.SessionID); ok {
+				if dir, err := wt.Dir(input.Directory); err == nil {
+					input.Directory = dir
+				}
+			}
+			return handler(ctx, req, input)
+		}
+
+		wt, created, err := sessionMgr.EnsureWorktree(input.SessionID, input.Directory)
+		if err != nil {
+			return nil, ToolOutput{}, fmt.Errorf("failed to isolate working directory: %w", err)
+		}
+		dir, err := wt.Dir(input.Directory)
+		if err != nil {
+			return nil, ToolOutput{}, err
+		}
+
+		isolated := input
+		isolated.Directory = dir
+		result, output, err := handler(ctx, req, isolated)
+		if created {
+			if output.SessionID == "" {
+				if err := sessionMgr.AbandonWorktree(wt.ID); err != nil {
+					log.Printf("Failed to remove worktree %s: %v", wt.Path, err)
+				}
+				return result, output, err
+			}
+			sessionMgr.AttachWorktree(wt.ID, output.SessionID)
+			log.Printf("Created worktree %s on branch %s for session %s", wt.Path, wt.Branch, output.SessionID)
+		}
+
+		output.Branch = wt.Branch
+		output.Worktree = wt.Path
+		return result, output, err
+	}
+}
+
+// registerWorktreeTools registers the tools that merge and remove session
+// worktrees.
+func registerWorktreeTools(server *mcp.Server, toolPrefix string, sessionMgr *sessions.Manager, leases *lease.Manager) {
+	listTool := &mcp.Tool{
+		Name:        toolPrefix + "list-worktrees",
+		Description: "List the git worktrees of worktree-isolated sessions with their branches",
+	}
+	mcp.AddTool(server, listTool, func(ctx context.Context, req *mcp.CallToolRequest, input struct{}) (*mcp.CallToolResult, ListWorktreesOutput, error) {
+		output := ListWorktreesOutput{Worktrees: []WorktreeOutput{}}
+		for _, wt := range sessionMgr.Worktrees() {
+			output.Worktrees = append(output.Worktrees, newWorktreeOutput(wt, "active"))
+		}
+		return nil, output, nil
+	})
+
+	mergeTool := &mcp.Tool{
+		Name:        toolPrefix + "merge-worktree",
+		Description: "Commit a session's uncommitted worktree changes, merge its branch into the branch checked out in the repository, then remove the worktree and branch. Refused while the repository checkout has uncommitted changes. A failed merge is aborted and the worktree kept.",
+	}
+	mcp.AddTool(server, mergeTool, func(ctx context.Context, req *mcp.CallToolRequest, input WorktreeInput) (*mcp.CallToolResult, WorktreeOutput, error) {
+		wt, ok := sessionMgr.Worktree(input.SessionID)
+		if !ok {
+			return nil, WorktreeOutput{}, fmt.Errorf("session %s has no worktree", input.SessionID)
+		}
+		// The merge commits in the worktree and writes to the checkout; keep
+		// agents out of both meanwhile
+		holder := lease.Holder{Agent: "merge-worktree", SessionID: input.SessionID}
+		releaseWorktree, err := leases.Acquire(ctx, wt.Path, lease.Exclusive, holder)
+		if err != nil {
+			return nil, WorktreeOutput{}, err
+		}
+		defer releaseWorktree()
+		releaseRepo, err := leases.Acquire(ctx, wt.Repo, lease.Exclusive, holder)
+		if err != nil {
+			return nil, WorktreeOutput{}, err
+		}
+		defer releaseRepo()
+
+		wt, err = sessionMgr.MergeWorktree(input.SessionID)
+		if err != nil {
+			return nil, WorktreeOutput{}, err
+		}
+		log.Printf("Merged branch %s into %s", wt.Branch, wt.Repo)
+		return nil, newWorktreeOutput(wt, "merged"), nil
+	})
+
+	removeTool := &mcp.Tool{
+		Name:        toolPrefix + "remove-worktree",
+		Description: "Remove a session's worktree without merging, discarding uncommitted changes. The branch is deleted unless keepBranch is set.",
+	}
+	mcp.AddTool(server, removeTool, func(ctx context.Context, req *mcp.CallToolRequest, input RemoveWorktreeInput) (*mcp.CallToolResult, WorktreeOutput, error) {
+		wt, ok := sessionMgr.Worktree(input.SessionID)
+		if !ok {
+			return nil, WorktreeOutput{}, fmt.Errorf("session %s has no worktree", input.SessionID)
+		}
+		// Don't pull the worktree out from under a running turn
+		release, err := leases.Acquire(ctx, wt.Path, lease.Exclusive, lease.Holder{Agent: "remove-worktree", SessionID: input.SessionID})
+		if err != nil {
+			return nil, WorktreeOutput{}, err
+		}
+		defer release()
+
+		if err := sessionMgr.RemoveWorktree(input.SessionID, input.KeepBranch); err != nil {
+			return nil, WorktreeOutput{}, err
+		}
+		log.Printf("Removed worktree %s (branch %s kept: %v)", wt.Path, wt.Branch, input.KeepBranch)
+		return nil, newWorktreeOutput(wt, "removed"), nil
+	})
+
+	log.Printf("Registered worktree tools")
+}
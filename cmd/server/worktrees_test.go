@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"budgie/internal/config"
+	"budgie/internal/container"
+	"budgie/internal/kiro"
+	"budgie/internal/sessions"
+)
+
+func TestIsolateWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	repo := t.TempDir()
+	os.WriteFile(filepath.Join(repo, "README"), []byte("readme\n"), 0644)
+	for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}, {"commit", "-q", "-m", "init"}} {
+		if output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+
+	runner := &fakeRunner{response: "done", edit: "agent edit\n"}
+	executor := kiro.NewExecutorWithRunner(runner, time.Minute, nil, false)
+	sessionMgr := sessions.NewManager(t.TempDir(), false)
+	defer sessionMgr.Cleanup()
+
+	handler := isolateWorktree(createHandler("developer", "", sessionMgr, executor, newCalls(t), &config.Config{}), sessionMgr)
+	_, output, err := handler(context.Background(), nil, ToolInput{Prompt: "edit", Directory: repo})
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+
+	if output.Branch == "" || output.Worktree == "" {
+		t.Fatalf("Expected a branch and worktree, got %+v", output)
+	}
+	if runner.invocations[0].WorkDir != output.Worktree {
+		t.Errorf("Expected the agent to run in %s, got %s", output.Worktree, runner.invocations[0].WorkDir)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "edit.txt")); err == nil {
+		t.Fatal("Expected the repository checkout to be untouched")
+	}
+
+	_, next, err := handler(context.Background(), nil, ToolInput{Prompt: "again", Directory: repo, SessionID: output.SessionID})
+	if err != nil {
+		t.Fatalf("Second turn failed: %v", err)
+	}
+	if next.Worktree != output.Worktree {
+		t.Errorf("Expected the session to keep its worktree, got %s", next.Worktree)
+	}
+
+	if _, err := sessionMgr.MergeWorktree(output.SessionID); err != nil {
+		t.Fatalf("MergeWorktree failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo, "edit.txt")); string(data) != "agent edit\n" {
+		t.Errorf("Expected the merged edit in the repository, got %q", data)
+	}
+}
+
+func TestCheckWorktreeRunner(t *testing.T) {
+	if err := checkWorktreeRunner(kiro.NewDirectRunner("kiro-cli")); err != nil {
+		t.Errorf("Expected direct mode to support worktrees, got %v", err)
+	}
+	for _, runner := range []kiro.Runner{kiro.NewDockerRunner(container.Docker, "image"), kiro.NewBwrapRunner("kiro-cli")} {
+		if err := checkWorktreeRunner(runner); err == nil {
+			t.Errorf("Expected %T to be refused", runner)
+		}
+	}
+}
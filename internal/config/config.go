@@ -11,7 +11,40 @@ type Config struct {
 	KiroBinary         string
 	ToolPrefix         string
 	AgentTimeout       time.Duration
+	KillGrace          time.Duration
 	SandboxEnabled     bool
 	SandboxImage       string
+	ContainerRuntime   string
+	SandboxBackend     string
+	SandboxWarm        bool
+	SandboxIdleTimeout time.Duration
+	SandboxCPUs        float64
+	SandboxMemory      string
+	SandboxPIDs        int
+	SandboxTmpfsSize   string
+	SandboxNetwork     string
+	EgressAllow        string
 	Verbose            bool
+	ListenAddr         string
+	HTTPToken          string
+	FanOutConcurrency  int
+	PromptDelivery     string
+	PromptArgMax       int
+	RecordDir          string
+	ReplayDir          string
+
+	MaxConcurrent         int
+	MaxConcurrentPerAgent int
+	LeaseWait             time.Duration
+	Snapshots             bool
+	SnapshotMaxBackup     int64
+	TaskTTL               time.Duration
+	MaxFinishedTasks      int
+
+	RetryMaxAttempts    int
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	RetryJitter         float64
+	RetryMaxElapsed     time.Duration
+	RetryOn             string
 }
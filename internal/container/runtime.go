@@ -0,0 +1,109 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Runtime is a Docker-compatible container CLI used by sandbox mode.
+type Runtime string
+
+const (
+	Docker Runtime = "docker"
+	Podman Runtime = "podman"
+)
+
+// Parse returns the runtime with the given name.
+func Parse(name string) (Runtime, error) {
+	switch Runtime(name) {
+	case Docker, Podman:
+		return Runtime(name), nil
+	}
+	return "", fmt.Errorf("unknown container runtime %q (expected docker or podman)", name)
+}
+
+// Binary returns the CLI executable; the zero value is Docker.
+func (r Runtime) Binary() string {
+	if r == "" {
+		return string(Docker)
+	}
+	return string(r)
+}
+
+// Command returns an exec.Cmd for the runtime CLI.
+func (r Runtime) Command(args ...string) *exec.Cmd {
+	return exec.Command(r.Binary(), args...)
+}
+
+// CommandContext returns an exec.Cmd for the runtime CLI bound to ctx.
+func (r Runtime) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, r.Binary(), args...)
+}
+
+// RunArgs returns flags every `run` needs on this runtime. Rootless Podman
+// maps the container's root to the invoking user, so files written to bind
+// mounts are owned by that user and no --userns flag is needed. SELinux
+// labelling is disabled rather than relabelling shared host directories
+// such as ~/.kiro and the working directory with :z.
+func (r Runtime) RunArgs() []string {
+	if r == Podman {
+		return []string{"--security-opt", "label=disable"}
+	}
+	return nil
+}
+
+// Rootless reports whether the runtime's containers run rootless. Rootless
+// networks live in a separate network namespace, so the host cannot listen
+// on their gateway address.
+func (r Runtime) Rootless() (bool, error) {
+	format := "{{.SecurityOptions}}"
+	if r == Podman {
+		format = "{{.Host.Security.Rootless}}"
+	}
+	output, err := r.Command("info", "--format", format).Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to run %s info: %w", r.Binary(), err)
+	}
+	return r.parseRootless(string(output)), nil
+}
+
+// parseRootless interprets the output of the info command Rootless runs.
+func (r Runtime) parseRootless(output string) bool {
+	if r == Podman {
+		return strings.TrimSpace(output) == "true"
+	}
+	return strings.Contains(output, "name=rootless")
+}
+
+// EnsureInternalNetwork creates the named network without external
+// connectivity if it does not exist, and returns its gateway address. The
+// host is reachable from containers on the network at that address.
+func (r Runtime) EnsureInternalNetwork(name string) (string, error) {
+	if gateway, err := r.networkGateway(name); err == nil {
+		return gateway, nil
+	}
+
+	if output, err := r.Command("network", "create", "--internal", name).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to create network %s: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return r.networkGateway(name)
+}
+
+func (r Runtime) networkGateway(name string) (string, error) {
+	format := "{{range .IPAM.Config}}{{.Gateway}}{{end}}"
+	if r == Podman {
+		format = "{{range .Subnets}}{{.Gateway}}{{end}}"
+	}
+
+	output, err := r.Command("network", "inspect", "--format", format, name).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect network %s: %w", name, err)
+	}
+	gateway := strings.TrimSpace(string(output))
+	if gateway == "" {
+		return "", fmt.Errorf("network %s has no gateway", name)
+	}
+	return gateway, nil
+}
@@ -0,0 +1,62 @@
+package container
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, name := range []string{"docker", "podman"} {
+		rt, err := Parse(name)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", name, err)
+		}
+		if rt.Binary() != name {
+			t.Errorf("Expected binary %s, got %s", name, rt.Binary())
+		}
+	}
+
+	if _, err := Parse("lxc"); err == nil {
+		t.Error("Expected error for unknown runtime")
+	}
+}
+
+func TestZeroValueIsDocker(t *testing.T) {
+	var rt Runtime
+	if rt.Binary() != "docker" {
+		t.Errorf("Expected zero value to use docker, got %s", rt.Binary())
+	}
+	if args := rt.RunArgs(); len(args) != 0 {
+		t.Errorf("Expected no extra run args for docker, got %v", args)
+	}
+}
+
+func TestPodmanCommand(t *testing.T) {
+	cmd := Podman.CommandContext(context.Background(), "run", "--rm")
+	if cmd.Args[0] != "podman" {
+		t.Errorf("Expected podman command, got %v", cmd.Args)
+	}
+
+	args := Podman.RunArgs()
+	if len(args) != 2 || args[1] != "label=disable" {
+		t.Errorf("Expected SELinux label opt-out for podman, got %v", args)
+	}
+}
+
+func TestParseRootless(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		output  string
+		want    bool
+	}{
+		{Podman, "true\n", true},
+		{Podman, "false\n", false},
+		{Docker, "[name=seccomp,profile=builtin name=rootless name=cgroupns]\n", true},
+		{Docker, "[name=apparmor name=seccomp,profile=builtin name=cgroupns]\n", false},
+	}
+	for _, tt := range tests {
+		if got := tt.runtime.parseRootless(tt.output); got != tt.want {
+			t.Errorf("%s parseRootless(%q) = %v, want %v", tt.runtime, tt.output, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package egress
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"budgie/internal/container"
+)
+
+// NetworkName is the internal container network used for kiro-api-only runs.
+const NetworkName = "budgie-egress"
+
+// Gateway joins kiro-api-only containers to an internal network whose only
+// way out is the allowlisting proxy, which listens on the network's gateway
+// address on the host. It is started on first use.
+type Gateway struct {
+	runtime container.Runtime
+	proxy   *Proxy
+
+	once     sync.Once
+	proxyURL string
+	err      error
+}
+
+// NewGateway creates a gateway for the runtime and allowlist.
+func NewGateway(runtime container.Runtime, allow []string) *Gateway {
+	return &Gateway{runtime: runtime, proxy: NewProxy(allow)}
+}
+
+// Endpoint creates the network and starts the proxy if needed, and returns
+// the network name and proxy URL.
+func (g *Gateway) Endpoint() (string, string, error) {
+	g.once.Do(func() {
+		gateway, err := g.runtime.EnsureInternalNetwork(NetworkName)
+		if err != nil {
+			g.err = err
+			return
+		}
+
+		addr, err := g.proxy.Listen(net.JoinHostPort(gateway, "0"))
+		if err != nil {
+			g.err = fmt.Errorf("failed to listen on %s: %w", gateway, err)
+			return
+		}
+		g.proxyURL = "http://" + addr
+	})
+	return NetworkName, g.proxyURL, g.err
+}
+
+// Close stops the proxy. The network is kept for the next start.
+func (g *Gateway) Close() error {
+	return g.proxy.Close()
+}
@@ -0,0 +1,177 @@
+// Package egress restricts sandbox network access to an allowlist of hosts
+// through an HTTP CONNECT proxy.
+package egress
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAllow lists the hosts kiro-cli needs to reach the Kiro API and to
+// refresh its login. AWS endpoints are listed per region rather than as
+// q.*.amazonaws.com: anyone can own an S3 bucket named like a service, and
+// legacy S3 endpoints such as oidc.s3-us-west-2.amazonaws.com would match.
+var DefaultAllow = []string{
+	"**.kiro.dev",
+	"q.us-east-1.amazonaws.com",
+	"q.eu-central-1.amazonaws.com",
+	"codewhisperer.us-east-1.amazonaws.com",
+	"oidc.us-east-1.amazonaws.com",
+	"oidc.eu-central-1.amazonaws.com",
+	"portal.sso.us-east-1.amazonaws.com",
+	"portal.sso.eu-central-1.amazonaws.com",
+}
+
+// Proxy is an HTTP CONNECT proxy that only tunnels to allowed hosts.
+// Plain HTTP requests are refused, so all traffic through it is TLS to a
+// host checked against the allowlist.
+type Proxy struct {
+	allow    []string
+	listener net.Listener
+	server   *http.Server
+	once     sync.Once
+}
+
+// NewProxy creates a proxy for the given allowlist. Entries are host
+// patterns where * matches within a single DNS label, e.g. "*.example.com"
+// matches api.example.com but not a.b.example.com, and a leading ** label
+// matches one or more labels, e.g. "**.kiro.dev" for a domain whose every
+// subdomain is trusted. Entries allow port 443 only unless they name a port,
+// e.g. "example.com:8443".
+func NewProxy(allow []string) *Proxy {
+	return &Proxy{allow: allow}
+}
+
+// ParseAllow splits a comma-separated allowlist.
+func ParseAllow(s string) []string {
+	var allow []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			allow = append(allow, strings.ToLower(entry))
+		}
+	}
+	return allow
+}
+
+// Allowed reports whether a CONNECT to hostport is allowed.
+func (p *Proxy) Allowed(hostport string) bool {
+	host, port, err := net.SplitHostPort(strings.ToLower(hostport))
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range p.allow {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			patternHost, patternPort = pattern, "443"
+		}
+		if patternPort == port && matchHost(patternHost, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost matches host against pattern label by label, so a wildcard
+// never spans a dot.
+func matchHost(pattern, host string) bool {
+	patternLabels := strings.Split(pattern, ".")
+	labels := strings.Split(host, ".")
+
+	if patternLabels[0] == "**" {
+		patternLabels = patternLabels[1:]
+		if len(labels) <= len(patternLabels) {
+			return false
+		}
+		labels = labels[len(labels)-len(patternLabels):]
+	}
+	if len(labels) != len(patternLabels) {
+		return false
+	}
+
+	for i, label := range labels {
+		if label == "" {
+			return false
+		}
+		if ok, _ := path.Match(patternLabels[i], label); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Listen starts serving on addr in the background and returns the address
+// it listens on.
+func (p *Proxy) Listen(addr string) (string, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+
+	p.listener = listener
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	go p.server.Serve(listener)
+	return listener.Addr().String(), nil
+}
+
+// Close stops the proxy. Open tunnels are left to finish.
+func (p *Proxy) Close() error {
+	var err error
+	p.once.Do(func() {
+		if p.server != nil {
+			err = p.server.Close()
+		}
+	})
+	return err
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.Allowed(r.Host) {
+		log.Printf("Egress proxy: denied CONNECT to %s", r.Host)
+		http.Error(w, fmt.Sprintf("egress to %s is not allowed", r.Host), http.StatusForbidden)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	go func() {
+		// Bytes the client sent after the CONNECT header are already buffered
+		io.Copy(upstream, buffered)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
@@ -0,0 +1,139 @@
+package egress
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	p := NewProxy(ParseAllow("**.kiro.dev, q.*.amazonaws.com, example.com:8443, api-*.example.org"))
+
+	tests := []struct {
+		hostport string
+		want     bool
+	}{
+		{"prod.us-east-1.auth.desktop.kiro.dev:443", true},
+		{"q.us-east-1.amazonaws.com:443", true},
+		{"Q.US-EAST-1.AMAZONAWS.COM:443", true},
+		{"s3.us-east-1.amazonaws.com:443", false},
+		// * stops at a dot, so S3 buckets under a service name do not match
+		{"q.attacker-bucket.s3.amazonaws.com:443", false},
+		{"q.us-east-1.amazonaws.com.evil.com:443", false},
+		{"kiro.dev:443", false},
+		{"api-eu.example.org:443", true},
+		{"api-eu.evil.example.org:443", false},
+		{"kiro.dev.evil.com:443", false},
+		{"api.kiro.dev:80", false},
+		{"example.com:8443", true},
+		{"example.com:443", false},
+		{"no-port.kiro.dev", false},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(tt.hostport); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.hostport, got, tt.want)
+		}
+	}
+}
+
+func TestAllowed_DefaultAllow(t *testing.T) {
+	p := NewProxy(DefaultAllow)
+
+	for _, host := range []string{"prod.us-east-1.auth.desktop.kiro.dev", "q.us-east-1.amazonaws.com", "oidc.us-east-1.amazonaws.com"} {
+		if !p.Allowed(host + ":443") {
+			t.Errorf("Expected %s to be allowed", host)
+		}
+	}
+	// Bucket-style hosts on S3 endpoints, old and new
+	for _, host := range []string{"q.attacker-bucket.s3.amazonaws.com", "oidc.s3-us-west-2.amazonaws.com", "portal.sso.s3.amazonaws.com"} {
+		if p.Allowed(host + ":443") {
+			t.Errorf("Expected %s to be denied", host)
+		}
+	}
+}
+
+// connect sends a CONNECT for target through the proxy at addr and returns
+// the connection and the response status.
+func connect(t *testing.T, addr, target string) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	if _, err := io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n"); err != nil {
+		t.Fatalf("Failed to write CONNECT: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatalf("Failed to read CONNECT response: %v", err)
+	}
+	return conn, reader, resp.StatusCode
+}
+
+func TestProxyTunnelsAllowedHosts(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "echo: "+line)
+	}()
+
+	p := NewProxy([]string{upstream.Addr().String()})
+	addr, err := p.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	conn, reader, status := connect(t, addr, upstream.Addr().String())
+	defer conn.Close()
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200 for allowed host, got %d", status)
+	}
+
+	io.WriteString(conn, "hello\n")
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read through tunnel: %v", err)
+	}
+	if reply != "echo: hello\n" {
+		t.Errorf("Unexpected reply through tunnel: %q", reply)
+	}
+}
+
+func TestProxyDeniesOtherHosts(t *testing.T) {
+	p := NewProxy([]string{"*.kiro.dev"})
+	addr, err := p.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	conn, _, status := connect(t, addr, "example.com:443")
+	conn.Close()
+	if status != http.StatusForbidden {
+		t.Errorf("Expected 403 for disallowed host, got %d", status)
+	}
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || !strings.Contains(string(body), "CONNECT") {
+		t.Errorf("Expected plain HTTP to be refused, got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,345 @@
+// Package fakekiro is a scriptable stand-in for kiro-cli, so the handler
+// flow can be tested end to end without the network or a kiro login.
+//
+// A script is a text file with one step per line; blank lines and lines
+// starting with # are ignored:
+//
+//	stdout <text>         print a line to stdout
+//	stderr <text>         print a line to stderr
+//	respond <text>        append a line to the response file named in the prompt
+//	write <path> <text>   append a line to a file in the working directory
+//	sleep <duration>      sleep, e.g. to run past the agent timeout
+//	exit <code>           exit with the given code
+//	crash                 kill the process, as a signal would
+//
+// Steps are grouped in sections. "match <regexp>" starts a section for
+// prompts matching the expression and "call <n>" one for the nth invocation
+// (counting from 1, which needs a log). The first section that applies is
+// run; steps before any section header run when none applies. Text may
+// contain {{PROMPT}}, replaced with the prompt. A script without exit or
+// crash exits 0.
+//
+// Like kiro-cli, the fake takes the prompt as its last argument or, without
+// one, from stdin. An argument pointing at one of budgie's prompt files is
+// replaced with the file's content, as the agent would read it.
+package fakekiro
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ScriptEnv names the environment variable holding the script path
+	ScriptEnv = "FAKE_KIRO_SCRIPT"
+	// LogEnv names the environment variable holding the path invocations
+	// are logged to, one JSON object per line
+	LogEnv = "FAKE_KIRO_LOG"
+)
+
+// Step is one line of a script.
+type Step struct {
+	Op   string
+	Args []string
+	Line int
+}
+
+// Section is a group of steps run for matching invocations.
+type Section struct {
+	Match *regexp.Regexp
+	Call  int
+	Steps []Step
+}
+
+// Script is a parsed script.
+type Script struct {
+	Default  []Step
+	Sections []Section
+}
+
+// Invocation is one logged run of the fake.
+type Invocation struct {
+	Args   []string `json:"args"`
+	Dir    string   `json:"dir"`
+	Prompt string   `json:"prompt"`
+	// Delivery is how the prompt arrived: argv, stdin or file
+	Delivery string `json:"delivery"`
+	Resume   bool   `json:"resume"`
+}
+
+var (
+	responseFilePattern = regexp.MustCompile("[^\\s`'\"]*response-[0-9a-f]+\\.txt")
+	promptFilePattern   = regexp.MustCompile("^Read the file (\\S*prompt-[0-9a-f]+\\.txt) ")
+)
+
+// kiro-cli chat options that take a value
+var valueOptions = map[string]bool{"--agent": true, "--model": true}
+
+// ParseFile parses the script at path.
+func ParseFile(path string) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses a script.
+func Parse(r io.Reader) (*Script, error) {
+	script := &Script{}
+	steps := &script.Default
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		op, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+
+		switch op {
+		case "match":
+			re, err := regexp.Compile(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			script.Sections = append(script.Sections, Section{Match: re})
+			steps = &script.Sections[len(script.Sections)-1].Steps
+		case "call":
+			call, err := strconv.Atoi(rest)
+			if err != nil || call < 1 {
+				return nil, fmt.Errorf("line %d: invalid call number %q", n, rest)
+			}
+			script.Sections = append(script.Sections, Section{Call: call})
+			steps = &script.Sections[len(script.Sections)-1].Steps
+		case "stdout", "stderr", "respond":
+			*steps = append(*steps, Step{Op: op, Args: []string{rest}, Line: n})
+		case "write":
+			path, text, _ := strings.Cut(rest, " ")
+			if path == "" {
+				return nil, fmt.Errorf("line %d: write needs a path", n)
+			}
+			*steps = append(*steps, Step{Op: op, Args: []string{path, text}, Line: n})
+		case "sleep":
+			if _, err := time.ParseDuration(rest); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			*steps = append(*steps, Step{Op: op, Args: []string{rest}, Line: n})
+		case "exit":
+			if _, err := strconv.Atoi(rest); err != nil {
+				return nil, fmt.Errorf("line %d: invalid exit code %q", n, rest)
+			}
+			*steps = append(*steps, Step{Op: op, Args: []string{rest}, Line: n})
+		case "crash":
+			*steps = append(*steps, Step{Op: op, Line: n})
+		default:
+			return nil, fmt.Errorf("line %d: unknown step %q", n, op)
+		}
+	}
+	return script, scanner.Err()
+}
+
+// Steps returns the steps to run for the call-th invocation with prompt.
+func (s *Script) Steps(prompt string, call int) []Step {
+	for _, section := range s.Sections {
+		if section.Match != nil && section.Match.MatchString(prompt) {
+			return section.Steps
+		}
+		if section.Call > 0 && section.Call == call {
+			return section.Steps
+		}
+	}
+	return s.Default
+}
+
+// Main runs the fake with kiro-cli's arguments (without the binary name)
+// and returns its exit code. The script and log are taken from ScriptEnv and
+// LogEnv.
+func Main(args []string) int {
+	script, err := ParseFile(os.Getenv(ScriptEnv))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakekiro: %v\n", err)
+		return 2
+	}
+
+	inv, err := invocation(args, os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakekiro: %v\n", err)
+		return 2
+	}
+
+	call := 0
+	if logPath := os.Getenv(LogEnv); logPath != "" {
+		if call, err = appendLog(logPath, inv); err != nil {
+			fmt.Fprintf(os.Stderr, "fakekiro: %v\n", err)
+			return 2
+		}
+	}
+
+	code, err := run(script.Steps(inv.Prompt, call), inv.Prompt, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakekiro: %v\n", err)
+		return 2
+	}
+	return code
+}
+
+// invocation describes a run from kiro-cli's arguments, reading the prompt
+// from stdin or a prompt file where it was delivered that way.
+func invocation(args []string, stdin io.Reader) (Invocation, error) {
+	inv := Invocation{Args: args, Delivery: "stdin"}
+	inv.Dir, _ = os.Getwd()
+
+	var prompt []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--resume":
+			inv.Resume = true
+		case valueOptions[args[i]]:
+			i++
+		case i > 0 && !strings.HasPrefix(args[i], "--"):
+			prompt = append(prompt, args[i])
+		}
+	}
+
+	if len(prompt) == 0 {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return inv, fmt.Errorf("failed to read prompt from stdin: %w", err)
+		}
+		inv.Prompt = string(data)
+		return inv, nil
+	}
+
+	inv.Prompt = prompt[len(prompt)-1]
+	inv.Delivery = "argv"
+	if m := promptFilePattern.FindStringSubmatch(inv.Prompt); m != nil {
+		data, err := os.ReadFile(m[1])
+		if err != nil {
+			return inv, fmt.Errorf("failed to read prompt file: %w", err)
+		}
+		inv.Prompt = string(data)
+		inv.Delivery = "file"
+	}
+	return inv, nil
+}
+
+// run executes steps and returns the exit code.
+func run(steps []Step, prompt string, stdout, stderr io.Writer) (int, error) {
+	expand := func(s string) string {
+		return strings.ReplaceAll(s, "{{PROMPT}}", prompt)
+	}
+
+	for _, step := range steps {
+		switch step.Op {
+		case "stdout":
+			fmt.Fprintln(stdout, expand(step.Args[0]))
+		case "stderr":
+			fmt.Fprintln(stderr, expand(step.Args[0]))
+		case "respond":
+			path := responseFilePattern.FindString(prompt)
+			if path == "" {
+				return 0, fmt.Errorf("line %d: prompt names no response file", step.Line)
+			}
+			if err := appendLine(path, expand(step.Args[0])); err != nil {
+				return 0, err
+			}
+		case "write":
+			workDir := WorkDir(prompt)
+			if workDir == "" {
+				return 0, fmt.Errorf("line %d: prompt names no working directory", step.Line)
+			}
+			path := filepath.Join(workDir, filepath.FromSlash(step.Args[0]))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return 0, err
+			}
+			if err := appendLine(path, expand(step.Args[1])); err != nil {
+				return 0, err
+			}
+		case "sleep":
+			d, _ := time.ParseDuration(step.Args[0])
+			time.Sleep(d)
+		case "exit":
+			code, _ := strconv.Atoi(step.Args[0])
+			return code, nil
+		case "crash":
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Kill()
+			}
+			select {}
+		}
+	}
+	return 0, nil
+}
+
+// WorkDir returns the working directory named by a prompt from budgie's
+// handler ("In directory <dir>, ...").
+func WorkDir(prompt string) string {
+	rest, ok := strings.CutPrefix(prompt, "In directory ")
+	if !ok {
+		return ""
+	}
+	if i := strings.IndexAny(rest, ",("); i > 0 {
+		return strings.TrimSpace(rest[:i])
+	}
+	return ""
+}
+
+// ReadLog returns the invocations logged to path.
+func ReadLog(path string) ([]Invocation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var invocations []Invocation
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var inv Invocation
+		if err := json.Unmarshal([]byte(line), &inv); err != nil {
+			return nil, err
+		}
+		invocations = append(invocations, inv)
+	}
+	return invocations, nil
+}
+
+// appendLog logs inv and returns its number. Invocations running at the
+// same time may be numbered in either order.
+func appendLog(path string, inv Invocation) (int, error) {
+	previous, err := ReadLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return 0, err
+	}
+	if err := appendLine(path, string(data)); err != nil {
+		return 0, err
+	}
+	return len(previous) + 1, nil
+}
+
+func appendLine(path, line string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
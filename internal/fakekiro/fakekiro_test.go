@@ -0,0 +1,141 @@
+package fakekiro
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	script, err := Parse(strings.NewReader(`
+# default
+stdout working
+respond done: {{PROMPT}}
+
+match summary
+respond summarised
+
+call 2
+exit 3
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(script.Default) != 2 || len(script.Sections) != 2 {
+		t.Fatalf("Expected 2 default steps and 2 sections, got %+v", script)
+	}
+	if steps := script.Steps("please summarise this summary", 1); steps[0].Args[0] != "summarised" {
+		t.Errorf("Expected the match section, got %+v", steps)
+	}
+	if steps := script.Steps("edit", 2); steps[0].Op != "exit" {
+		t.Errorf("Expected the call section, got %+v", steps)
+	}
+	if steps := script.Steps("edit", 1); steps[0].Op != "stdout" {
+		t.Errorf("Expected the default steps, got %+v", steps)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, script := range []string{"explode", "sleep forever", "exit now", "call 0", "match (", "write"} {
+		if _, err := Parse(strings.NewReader(script)); err == nil {
+			t.Errorf("Expected %q to fail", script)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	sessionDir := t.TempDir()
+	workDir := t.TempDir()
+	responsePath := filepath.Join(sessionDir, "response-1a2b3c4d.txt")
+	prompt := "In directory " + workDir + ", fix it\n\nWrite your final answer to: `" + responsePath + "`"
+
+	script, err := Parse(strings.NewReader("stdout out\nstderr err\nrespond fixed\nwrite pkg/fix.go package pkg\nexit 4\nstdout unreachable"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code, err := run(script.Default, prompt, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if code != 4 {
+		t.Errorf("Expected exit code 4, got %d", code)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("Unexpected output %q, %q", stdout.String(), stderr.String())
+	}
+	if content, _ := os.ReadFile(responsePath); string(content) != "fixed\n" {
+		t.Errorf("Expected the response file to be written, got %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(workDir, "pkg", "fix.go")); string(content) != "package pkg\n" {
+		t.Errorf("Expected the working directory file to be written, got %q", content)
+	}
+}
+
+func TestWorkDir(t *testing.T) {
+	tests := map[string]string{
+		"In directory /src/app, fix it":                             "/src/app",
+		"In directory /src/app (read-only: do not edit), review it": "/src/app",
+		"fix it": "",
+	}
+	for prompt, want := range tests {
+		if got := WorkDir(prompt); got != want {
+			t.Errorf("WorkDir(%q) = %q, want %q", prompt, got, want)
+		}
+	}
+}
+
+func TestAppendLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "calls.log")
+
+	for want := 1; want <= 2; want++ {
+		call, err := appendLog(logPath, Invocation{Args: []string{"chat", "--resume", "hi"}, Prompt: "hi", Resume: true})
+		if err != nil {
+			t.Fatalf("appendLog failed: %v", err)
+		}
+		if call != want {
+			t.Errorf("Expected call %d, got %d", want, call)
+		}
+	}
+
+	invocations, err := ReadLog(logPath)
+	if err != nil {
+		t.Fatalf("ReadLog failed: %v", err)
+	}
+	if len(invocations) != 2 || !invocations[1].Resume || invocations[1].Prompt != "hi" {
+		t.Errorf("Unexpected log %+v", invocations)
+	}
+}
+
+func TestInvocation(t *testing.T) {
+	inv, err := invocation([]string{"chat", "--agent", "developer", "--no-interactive", "--model", "m", "--resume", "fix it"}, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("invocation failed: %v", err)
+	}
+	if inv.Prompt != "fix it" || inv.Delivery != "argv" || !inv.Resume {
+		t.Errorf("Unexpected argv invocation %+v", inv)
+	}
+
+	inv, err = invocation([]string{"chat", "--agent", "developer", "--no-interactive"}, strings.NewReader("fix it from stdin"))
+	if err != nil {
+		t.Fatalf("invocation failed: %v", err)
+	}
+	if inv.Prompt != "fix it from stdin" || inv.Delivery != "stdin" || inv.Resume {
+		t.Errorf("Unexpected stdin invocation %+v", inv)
+	}
+
+	promptFile := filepath.Join(t.TempDir(), "prompt-1a2b3c4d.txt")
+	os.WriteFile(promptFile, []byte("fix it from a file"), 0600)
+	inv, err = invocation([]string{"chat", "--agent", "developer", "--no-interactive", "Read the file " + promptFile + " and follow the instructions in it."}, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("invocation failed: %v", err)
+	}
+	if inv.Prompt != "fix it from a file" || inv.Delivery != "file" {
+		t.Errorf("Unexpected file invocation %+v", inv)
+	}
+}
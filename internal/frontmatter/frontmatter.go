@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -17,6 +18,57 @@ type AgentMetadata struct {
 	Tools        []string `yaml:"tools"`
 	Model        string   `yaml:"model"`
 	Tags         []string `yaml:"tags"`
+
+	// ReadOnly mounts the working directory read-only
+	ReadOnly bool `yaml:"readonly"`
+	// Writer set to false takes a shared lease on the working directory
+	// per call instead of an exclusive one
+	Writer *bool `yaml:"writer"`
+	// Snapshot overrides --snapshots: whether calls record the files they
+	// change so they can be undone
+	Snapshot *bool `yaml:"snapshot"`
+	// Isolation runs the agent away from the real working directory
+	Isolation string `yaml:"isolation"`
+
+	// Execution settings
+	MaxConcurrent int              `yaml:"max_concurrent"`
+	Priority      int              `yaml:"priority"`
+	Retry         *RetryConfig     `yaml:"retry"`
+	Resources     *ResourcesConfig `yaml:"resources"`
+	Network       string           `yaml:"network"`
+	Sandbox       *SandboxConfig   `yaml:"sandbox"`
+}
+
+// RetryConfig overrides the global retry policy for one agent. Fields left
+// unset keep the global value.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	Jitter         *float64      `yaml:"jitter"`
+	MaxElapsed     time.Duration `yaml:"max_elapsed"`
+	RetryOn        []string      `yaml:"retry_on"`
+}
+
+// ResourcesConfig overrides the default sandbox resource limits for one
+// agent. Fields left unset keep the default.
+type ResourcesConfig struct {
+	CPUs      float64 `yaml:"cpus"`
+	Memory    string  `yaml:"memory"`
+	PIDs      int     `yaml:"pids"`
+	TmpfsSize string  `yaml:"tmpfs_size"`
+}
+
+// SandboxConfig is the agent's sandbox profile. Fields left unset keep the
+// global sandbox settings.
+type SandboxConfig struct {
+	Enabled *bool    `yaml:"enabled"`
+	Image   string   `yaml:"image"`
+	Mounts  []string `yaml:"mounts"`
+	WorkDir string   `yaml:"workdir"`
+	Network string   `yaml:"network"`
+	Env     []string `yaml:"env"`
 }
 
 func LoadFromPrompt(promptsDir, agentName string) (*AgentMetadata, error) {
@@ -53,11 +105,14 @@ func (m *AgentMetadata) EnhancedDescription() string {
 	
 	parts = append(parts, m.Description)
 	
-	if len(m.Capabilities) > 0 {
+	if len(m.Capabilities) > 0 || m.ReadOnly {
 		parts = append(parts, "\n\nCapabilities:")
 		for _, cap := range m.Capabilities {
 			parts = append(parts, "- "+cap)
 		}
+		if m.ReadOnly {
+			parts = append(parts, "- Read-only: never modifies files in the working directory")
+		}
 	}
 	
 	if len(m.UseWhen) > 0 {
@@ -14,6 +14,15 @@ type AgentMetrics struct {
 	LastSuccess   time.Time
 	LastFailure   time.Time
 	LastError     string
+	// ErrorClasses counts failures by error class
+	ErrorClasses map[string]int
+	// ResourceLimitCalls counts runs killed or failed by sandbox resource limits
+	ResourceLimitCalls int
+
+	// Queue wait is tracked separately from execution time
+	QueuedCalls    int
+	TotalQueueWait time.Duration
+	MaxQueueWait   time.Duration
 }
 
 type Monitor struct {
@@ -42,7 +51,9 @@ func (m *Monitor) RecordSuccess(agent string, duration time.Duration) {
 	metrics.LastSuccess = time.Now()
 }
 
-func (m *Monitor) RecordFailure(agent string, duration time.Duration, err string, isTimeout bool) {
+// RecordFailure records a failed run. errorClass is the execution error
+// class (e.g. "timeout"), or empty if unknown.
+func (m *Monitor) RecordFailure(agent string, duration time.Duration, err string, errorClass string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -57,8 +68,34 @@ func (m *Monitor) RecordFailure(agent string, duration time.Duration, err string
 	metrics.LastFailure = time.Now()
 	metrics.LastError = err
 
-	if isTimeout {
+	switch errorClass {
+	case "timeout":
 		metrics.TimeoutCalls++
+	case "resource_limit":
+		metrics.ResourceLimitCalls++
+	}
+	if errorClass != "" {
+		if metrics.ErrorClasses == nil {
+			metrics.ErrorClasses = make(map[string]int)
+		}
+		metrics.ErrorClasses[errorClass]++
+	}
+}
+
+// RecordQueueWait records how long a run waited for an execution slot.
+func (m *Monitor) RecordQueueWait(agent string, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.metrics[agent] == nil {
+		m.metrics[agent] = &AgentMetrics{}
+	}
+
+	metrics := m.metrics[agent]
+	metrics.QueuedCalls++
+	metrics.TotalQueueWait += wait
+	if wait > metrics.MaxQueueWait {
+		metrics.MaxQueueWait = wait
 	}
 }
 
@@ -70,8 +107,7 @@ func (m *Monitor) GetMetrics(agent string) *AgentMetrics {
 		return &AgentMetrics{}
 	}
 
-	metrics := *m.metrics[agent]
-	return &metrics
+	return m.metrics[agent].clone()
 }
 
 func (m *Monitor) GetAllMetrics() map[string]*AgentMetrics {
@@ -80,12 +116,22 @@ func (m *Monitor) GetAllMetrics() map[string]*AgentMetrics {
 
 	result := make(map[string]*AgentMetrics)
 	for agent, metrics := range m.metrics {
-		copy := *metrics
-		result[agent] = &copy
+		result[agent] = metrics.clone()
 	}
 	return result
 }
 
+func (m *AgentMetrics) clone() *AgentMetrics {
+	copy := *m
+	if m.ErrorClasses != nil {
+		copy.ErrorClasses = make(map[string]int, len(m.ErrorClasses))
+		for class, count := range m.ErrorClasses {
+			copy.ErrorClasses[class] = count
+		}
+	}
+	return &copy
+}
+
 func (m *AgentMetrics) SuccessRate() float64 {
 	if m.TotalCalls == 0 {
 		return 0.0
@@ -99,3 +145,10 @@ func (m *AgentMetrics) AvgDuration() time.Duration {
 	}
 	return m.TotalDuration / time.Duration(m.TotalCalls)
 }
+
+func (m *AgentMetrics) AvgQueueWait() time.Duration {
+	if m.QueuedCalls == 0 {
+		return 0
+	}
+	return m.TotalQueueWait / time.Duration(m.QueuedCalls)
+}
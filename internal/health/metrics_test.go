@@ -20,7 +20,7 @@ func TestMonitor(t *testing.T) {
 	}
 
 	// Record failure
-	m.RecordFailure("test-agent", 50*time.Millisecond, "test error", false)
+	m.RecordFailure("test-agent", 50*time.Millisecond, "test error", "")
 
 	metrics = m.GetMetrics("test-agent")
 	if metrics.TotalCalls != 2 {
@@ -37,7 +37,7 @@ func TestMonitor(t *testing.T) {
 func TestMonitorTimeout(t *testing.T) {
 	m := NewMonitor()
 
-	m.RecordFailure("test-agent", 100*time.Millisecond, "timeout", true)
+	m.RecordFailure("test-agent", 100*time.Millisecond, "timeout", "timeout")
 
 	metrics := m.GetMetrics("test-agent")
 	if metrics.TimeoutCalls != 1 {
@@ -45,6 +45,45 @@ func TestMonitorTimeout(t *testing.T) {
 	}
 }
 
+func TestMonitorResourceLimit(t *testing.T) {
+	m := NewMonitor()
+
+	m.RecordFailure("test-agent", 100*time.Millisecond, "oom", "resource_limit")
+
+	metrics := m.GetMetrics("test-agent")
+	if metrics.ResourceLimitCalls != 1 {
+		t.Errorf("Expected 1 resource limit failure, got %d", metrics.ResourceLimitCalls)
+	}
+	if metrics.ErrorClasses["resource_limit"] != 1 {
+		t.Errorf("Expected resource_limit in error classes, got %v", metrics.ErrorClasses)
+	}
+}
+
+func TestMonitorErrorClasses(t *testing.T) {
+	m := NewMonitor()
+
+	m.RecordFailure("test-agent", 100*time.Millisecond, "rate limited", "rate_limited")
+	m.RecordFailure("test-agent", 100*time.Millisecond, "rate limited", "rate_limited")
+	m.RecordFailure("test-agent", 100*time.Millisecond, "auth", "auth_expired")
+
+	metrics := m.GetMetrics("test-agent")
+	if metrics.ErrorClasses["rate_limited"] != 2 {
+		t.Errorf("Expected 2 rate_limited failures, got %d", metrics.ErrorClasses["rate_limited"])
+	}
+	if metrics.ErrorClasses["auth_expired"] != 1 {
+		t.Errorf("Expected 1 auth_expired failure, got %d", metrics.ErrorClasses["auth_expired"])
+	}
+	if metrics.TimeoutCalls != 0 {
+		t.Errorf("Expected 0 timeouts, got %d", metrics.TimeoutCalls)
+	}
+
+	// Returned metrics must not alias the monitor's state
+	metrics.ErrorClasses["rate_limited"] = 100
+	if m.GetMetrics("test-agent").ErrorClasses["rate_limited"] != 2 {
+		t.Error("GetMetrics should return a copy of the error class counts")
+	}
+}
+
 func TestMonitorAvgDuration(t *testing.T) {
 	m := NewMonitor()
 
@@ -88,3 +127,25 @@ func TestMonitorEmptyAgent(t *testing.T) {
 		t.Errorf("Expected 0%% success for nonexistent agent, got %.2f", metrics.SuccessRate())
 	}
 }
+
+func TestMonitorQueueWait(t *testing.T) {
+	m := NewMonitor()
+
+	m.RecordQueueWait("test-agent", 100*time.Millisecond)
+	m.RecordQueueWait("test-agent", 300*time.Millisecond)
+	m.RecordSuccess("test-agent", 1*time.Second)
+
+	metrics := m.GetMetrics("test-agent")
+	if metrics.QueuedCalls != 2 {
+		t.Errorf("Expected 2 queued calls, got %d", metrics.QueuedCalls)
+	}
+	if metrics.AvgQueueWait() != 200*time.Millisecond {
+		t.Errorf("Expected 200ms avg queue wait, got %v", metrics.AvgQueueWait())
+	}
+	if metrics.MaxQueueWait != 300*time.Millisecond {
+		t.Errorf("Expected 300ms max queue wait, got %v", metrics.MaxQueueWait)
+	}
+	if metrics.AvgDuration() != 1*time.Second {
+		t.Errorf("Queue wait should not affect avg duration, got %v", metrics.AvgDuration())
+	}
+}
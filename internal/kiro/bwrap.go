@@ -0,0 +1,262 @@
+package kiro
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BwrapRunner runs the host kiro-cli inside user and mount namespaces with
+// bubblewrap. It mirrors the Docker mounts without a container image: only
+// the system directories and the kiro-cli binary are mounted from the host,
+// read-only, so /run, /var and the sockets in them stay hidden; the home
+// directory is an empty tmpfs; ~/.kiro is mounted read-only; kiro-cli's data
+// directory is a read-only overlay whose writes land in the session
+// directory, like the container's copy of the auth database; and the session
+// and working directories are mounted read-write at their host paths. The
+// environment is cleared except for PATH, HOME and the agent's allowlist.
+// Linux only.
+type BwrapRunner struct {
+	Binary        string
+	Bwrap         string
+	HomeDir       string
+	KiroConfigDir string
+	DataDir       string
+}
+
+// bwrapMinVersion is the first bubblewrap release with --overlay-src and
+// --overlay; older ones fail every run with "Unknown option".
+var bwrapMinVersion = []int{0, 10, 0}
+
+// CheckBwrap checks that the bubblewrap binary is recent enough for
+// BwrapRunner.
+func CheckBwrap(bwrap string) error {
+	out, err := exec.Command(bwrap, "--version").Output()
+	if err != nil {
+		return fmt.Errorf("failed to run %s --version: %w", bwrap, err)
+	}
+	version, err := parseBwrapVersion(string(out))
+	if err != nil {
+		return err
+	}
+	if slices.Compare(version, bwrapMinVersion) < 0 {
+		return fmt.Errorf("bubblewrap %s is too old: the bwrap backend needs 0.10.0 or later for --overlay", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// parseBwrapVersion parses the output of bwrap --version, e.g.
+// "bubblewrap 0.9.0".
+func parseBwrapVersion(out string) ([]int, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 || fields[0] != "bubblewrap" {
+		return nil, fmt.Errorf("unexpected bwrap --version output %q", strings.TrimSpace(out))
+	}
+	var version []int
+	for _, part := range strings.Split(fields[1], ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected bwrap version %q", fields[1])
+		}
+		version = append(version, n)
+	}
+	return version, nil
+}
+
+func NewBwrapRunner(binary string) *BwrapRunner {
+	homeDir, _ := os.UserHomeDir()
+	return &BwrapRunner{
+		Binary:        binary,
+		Bwrap:         "bwrap",
+		HomeDir:       homeDir,
+		KiroConfigDir: filepath.Join(homeDir, ".kiro"),
+		DataDir:       defaultAuthSourceDir(),
+	}
+}
+
+func (r *BwrapRunner) Command(ctx context.Context, inv Invocation) (*exec.Cmd, error) {
+	binary, err := r.check(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	// The overlay directories must exist before bwrap mounts them
+	for _, dir := range []string{bwrapDataUpper(inv), bwrapDataWork(inv)} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, &Error{Class: ErrorOther, ExitCode: -1, Err: fmt.Errorf("failed to create kiro-cli data overlay: %w", err)}
+		}
+	}
+
+	args := r.Args(binary, inv)
+	return exec.CommandContext(ctx, r.Bwrap, args...), nil
+}
+
+// Preview describes the bwrap process for inv.
+func (r *BwrapRunner) Preview(inv Invocation) (Preview, error) {
+	binary, err := r.check(inv)
+	if err != nil {
+		return Preview{}, err
+	}
+	return bwrapPreview(append([]string{r.Bwrap}, r.Args(binary, inv)...)), nil
+}
+
+// check returns the kiro-cli binary to mount, or an error if inv cannot run
+// under bwrap.
+func (r *BwrapRunner) check(inv Invocation) (string, error) {
+	binary, err := r.resolveBinary()
+	if err != nil {
+		return "", &Error{Class: ErrorBinaryMissing, ExitCode: -1, Err: err}
+	}
+
+	if inv.Network == NetworkKiroAPIOnly {
+		return "", &Error{Class: ErrorOther, ExitCode: -1, Err: fmt.Errorf("network policy %s is not supported by the bwrap backend", inv.Network)}
+	}
+	return binary, nil
+}
+
+// Args returns the bwrap arguments that run binary for inv.
+func (r *BwrapRunner) Args(binary string, inv Invocation) []string {
+	args := []string{
+		"--die-with-parent",
+		"--new-session",
+		"--unshare-all",
+	}
+
+	if inv.Network != NetworkNone {
+		args = append(args, "--share-net")
+	}
+
+	for _, dir := range bwrapSystemDirs {
+		args = append(args, "--ro-bind-try", dir, dir)
+	}
+	if inv.Network != NetworkNone {
+		// With systemd-resolved, /etc/resolv.conf points into /run; mount
+		// just that file rather than /run
+		if resolv, err := filepath.EvalSymlinks("/etc/resolv.conf"); err == nil && !isSystemPath(resolv) {
+			args = append(args, "--ro-bind", resolv, resolv)
+		}
+	}
+
+	args = append(args,
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		// Hide everything under the home directory, then mount back only
+		// what kiro-cli needs
+		"--tmpfs", r.HomeDir,
+		"--ro-bind-try", r.KiroConfigDir, r.KiroConfigDir,
+		// kiro-cli writes conversation history and refreshed tokens next to
+		// its auth; keep those writes in the session, not on the host
+		"--overlay-src", r.DataDir,
+		"--overlay", bwrapDataUpper(inv), bwrapDataWork(inv), r.DataDir,
+	)
+
+	// kiro-cli starts helper binaries installed next to it
+	if binDir := filepath.Dir(binary); !isSystemPath(binDir) {
+		args = append(args, "--ro-bind", binDir, binDir)
+	}
+
+	args = append(args, "--bind", inv.SessionDir, inv.SessionDir)
+
+	if inv.WorkDir != "" {
+		bind := "--bind"
+		if inv.Sandbox.ReadOnlyWorkDir {
+			bind = "--ro-bind"
+		}
+		args = append(args, bind, inv.WorkDir, inv.WorkDir)
+	}
+
+	for _, m := range inv.Sandbox.Mounts {
+		args = append(args, "--ro-bind", m.Source, m.target())
+	}
+
+	args = append(args, "--clearenv")
+	for _, name := range slices.Concat(bwrapBaseEnv, inv.Sandbox.Env) {
+		if value, ok := os.LookupEnv(name); ok {
+			args = append(args, "--setenv", name, value)
+		}
+	}
+
+	args = append(args, "--chdir", inv.SessionDir, "--", binary)
+	return append(args, kiroArgs(inv)...)
+}
+
+// bwrapBaseEnv is kept from budgie's environment along with an agent's env
+// allowlist; kiro-cli needs it to find its home and tools.
+var bwrapBaseEnv = []string{"HOME", "PATH"}
+
+// bwrapSystemDirs are the host directories mounted read-only, where they
+// exist. /run and /var, with the Docker, D-Bus and SSH agent sockets, are
+// deliberately left out.
+var bwrapSystemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc"}
+
+// isSystemPath reports whether path is under one of bwrapSystemDirs.
+func isSystemPath(path string) bool {
+	return slices.ContainsFunc(bwrapSystemDirs, func(dir string) bool {
+		return isUnder(path, dir)
+	})
+}
+
+// bwrapDataUpper and bwrapDataWork hold the session's writes to kiro-cli's
+// data directory.
+func bwrapDataUpper(inv Invocation) string {
+	return filepath.Join(inv.SessionDir, "kiro-data")
+}
+
+func bwrapDataWork(inv Invocation) string {
+	return filepath.Join(inv.SessionDir, "kiro-data-work")
+}
+
+// resolveBinary returns the absolute, symlink-free path of the kiro-cli
+// binary so it can be mounted into the sandbox.
+func (r *BwrapRunner) resolveBinary() (string, error) {
+	path, err := exec.LookPath(r.Binary)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", path, err)
+	}
+	return resolved, nil
+}
+
+// Stop is a no-op: --die-with-parent tears the sandbox down with bwrap.
+func (r *BwrapRunner) Stop(inv Invocation, grace time.Duration) {}
+
+func (r *BwrapRunner) Cleanup(inv Invocation, aborted bool) {}
+
+func (r *BwrapRunner) WorkDirPath(workDir string) string {
+	return workDir
+}
+
+func (r *BwrapRunner) ResponsePath(sessionDir, responseFile string) string {
+	return filepath.Join(sessionDir, responseFile)
+}
+
+func (r *BwrapRunner) ReadResponse(sessionDir, responseFile string) (string, error) {
+	return readResponseFile(sessionDir, responseFile)
+}
+
+func (r *BwrapRunner) AppendSessionFile(sessionDir, name, content string) error {
+	return appendFile(filepath.Join(sessionDir, name), content)
+}
+
+func (r *BwrapRunner) Containerized() bool {
+	return false
+}
+
+func isUnder(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
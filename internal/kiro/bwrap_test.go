@@ -0,0 +1,117 @@
+package kiro
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testBwrapRunner() *BwrapRunner {
+	return &BwrapRunner{
+		Binary:        "kiro-cli",
+		Bwrap:         "bwrap",
+		HomeDir:       "/home/user",
+		KiroConfigDir: "/home/user/.kiro",
+		DataDir:       "/home/user/.local/share/kiro-cli",
+	}
+}
+
+func TestBwrapArgs(t *testing.T) {
+	r := testBwrapRunner()
+	args := r.Args("/home/user/.local/bin/kiro-cli", Invocation{
+		Agent:      "test-agent",
+		Prompt:     "test prompt",
+		SessionDir: "/home/user/.kiro/sub-agents/sessions/abc",
+		WorkDir:    "/home/user/project",
+	})
+	argStr := strings.Join(args, " ")
+
+	expected := []string{
+		"--tmpfs /home/user",
+		"--ro-bind-try /home/user/.kiro /home/user/.kiro",
+		"--overlay-src /home/user/.local/share/kiro-cli --overlay /home/user/.kiro/sub-agents/sessions/abc/kiro-data /home/user/.kiro/sub-agents/sessions/abc/kiro-data-work /home/user/.local/share/kiro-cli",
+		"--ro-bind /home/user/.local/bin /home/user/.local/bin",
+		"--bind /home/user/.kiro/sub-agents/sessions/abc /home/user/.kiro/sub-agents/sessions/abc",
+		"--bind /home/user/project /home/user/project",
+		"--chdir /home/user/.kiro/sub-agents/sessions/abc",
+		"-- /home/user/.local/bin/kiro-cli chat --agent test-agent --no-interactive test prompt",
+	}
+	for _, want := range expected {
+		if !strings.Contains(argStr, want) {
+			t.Errorf("Expected %q in args: %s", want, argStr)
+		}
+	}
+
+	// The home tmpfs must come before the mounts placed inside it
+	if strings.Index(argStr, "--tmpfs /home/user") > strings.Index(argStr, "--ro-bind-try /home/user/.kiro") {
+		t.Error("Home tmpfs must be mounted before ~/.kiro")
+	}
+}
+
+func TestBwrapArgs_BinaryOutsideHome(t *testing.T) {
+	r := testBwrapRunner()
+	args := r.Args("/usr/bin/kiro-cli", Invocation{Agent: "a", Prompt: "p", SessionDir: "/tmp/s"})
+	argStr := strings.Join(args, " ")
+
+	if strings.Contains(argStr, "--ro-bind /usr/bin") {
+		t.Error("Binaries outside home are already visible through the root bind")
+	}
+	if strings.Contains(argStr, "--bind  ") {
+		t.Error("Empty working directory should not be mounted")
+	}
+}
+
+func TestBwrapCommand_MissingBinary(t *testing.T) {
+	r := testBwrapRunner()
+	r.Binary = "nonexistent-binary-12345"
+
+	_, err := r.Command(context.Background(), Invocation{})
+	var kerr *Error
+	if !errors.As(err, &kerr) || kerr.Class != ErrorBinaryMissing {
+		t.Errorf("Expected binary_missing error, got %v", err)
+	}
+}
+
+func TestBwrapArgs_MinimalHost(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("USER", "someone")
+	t.Setenv("SSH_AUTH_SOCK", "/run/user/1000/ssh-agent")
+
+	// Without a network /etc/resolv.conf is not followed into /run
+	r := testBwrapRunner()
+	args := r.Args("/usr/bin/kiro-cli", Invocation{Agent: "a", Prompt: "p", SessionDir: "/tmp/s", Network: NetworkNone})
+	argStr := strings.Join(args, " ")
+
+	if strings.Contains(argStr, "--ro-bind / /") || strings.Contains(argStr, " /run") || strings.Contains(argStr, " /var") {
+		t.Errorf("Expected only the system directories from the host: %s", argStr)
+	}
+	for _, want := range []string{"--ro-bind-try /usr /usr", "--ro-bind-try /etc /etc", "--clearenv", "--setenv PATH /usr/bin"} {
+		if !strings.Contains(argStr, want) {
+			t.Errorf("Expected %q in args: %s", want, argStr)
+		}
+	}
+	if strings.Contains(argStr, "USER") || strings.Contains(argStr, "SSH_AUTH_SOCK") {
+		t.Errorf("Expected variables outside the allowlist to be cleared: %s", argStr)
+	}
+	if strings.Contains(argStr, "--bind-try /home/user/.local/share/kiro-cli") {
+		t.Error("Expected the kiro-cli data directory not to be writable")
+	}
+}
+
+func TestCheckBwrap(t *testing.T) {
+	dir := t.TempDir()
+	for version, ok := range map[string]bool{"0.8.0": false, "0.9.0": false, "0.10.0": true, "0.11.1": true, "1.0": true} {
+		bwrap := filepath.Join(dir, "bwrap-"+version)
+		os.WriteFile(bwrap, []byte("#!/bin/sh\necho bubblewrap "+version+"\n"), 0755)
+		if err := CheckBwrap(bwrap); (err == nil) != ok {
+			t.Errorf("CheckBwrap with %s = %v", version, err)
+		}
+	}
+
+	if _, err := parseBwrapVersion("bwrap: unknown option\n"); err == nil {
+		t.Error("Expected unexpected output to be rejected")
+	}
+}
@@ -0,0 +1,230 @@
+package kiro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cassette is one recorded kiro-cli run: what it was asked and everything
+// it returned, so the run can be replayed without kiro-cli.
+type Cassette struct {
+	RunID     string `json:"runId"`
+	Agent     string `json:"agent"`
+	Model     string `json:"model,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	// Prompt is the rendered prompt as passed to kiro-cli
+	Prompt string `json:"prompt"`
+	// WorkDir and ResponsePath are the paths in the prompt, as the agent
+	// saw them; they differ between runs and are ignored when matching
+	WorkDir      string `json:"workDir,omitempty"`
+	ResponsePath string `json:"responsePath,omitempty"`
+	Stdout       string `json:"stdout"`
+	Stderr       string `json:"stderr"`
+	// Response is the response file content, nil if none was written
+	Response   *string        `json:"response,omitempty"`
+	ExitCode   int            `json:"exitCode"`
+	Error      *CassetteError `json:"error,omitempty"`
+	StartedAt  time.Time      `json:"startedAt"`
+	DurationMs int64          `json:"durationMs"`
+}
+
+// CassetteError is the classified failure of a recorded run.
+type CassetteError struct {
+	Class   ErrorClass `json:"class"`
+	Signal  string     `json:"signal,omitempty"`
+	Stderr  string     `json:"stderr,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+// key is what a run must share with a cassette to be answered by it.
+func (c Cassette) key() string {
+	prompt := c.Prompt
+	if c.ResponsePath != "" {
+		prompt = strings.ReplaceAll(prompt, c.ResponsePath, "{{RESPONSE_FILE}}")
+	}
+	if c.WorkDir != "" {
+		prompt = strings.ReplaceAll(prompt, c.WorkDir, "{{WORKING_DIRECTORY}}")
+	}
+	return strings.Join([]string{c.Agent, c.Model, fmt.Sprint(c.SessionID != ""), prompt}, "\x00")
+}
+
+// result rebuilds the executor result of the recorded run.
+func (c Cassette) result() Result {
+	if c.Error == nil {
+		return Result{Output: strings.TrimSpace(c.Stdout)}
+	}
+	var err error
+	if c.Error.Message != "" {
+		err = errors.New(c.Error.Message)
+	}
+	return Result{Error: &Error{
+		Class:    c.Error.Class,
+		ExitCode: c.ExitCode,
+		Signal:   c.Error.Signal,
+		Stderr:   c.Error.Stderr,
+		Err:      err,
+	}}
+}
+
+// Recorder writes a cassette for every kiro-cli run to a directory.
+type Recorder struct {
+	dir string
+}
+
+// NewRecorder creates a recorder writing cassettes to dir. Cassettes hold
+// prompts and responses, so only the current user can read them.
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// Record writes a cassette named after its start time, agent and run.
+func (r *Recorder) Record(c Cassette) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-%s.json", c.StartedAt.UTC().Format("20060102T150405.000000000"), c.Agent, c.RunID)
+	return os.WriteFile(filepath.Join(r.dir, name), data, 0600)
+}
+
+// Replayer answers kiro-cli runs from recorded cassettes. Cassettes for the
+// same agent, model and prompt are replayed in the order they were
+// recorded; once they run out the last one is repeated.
+type Replayer struct {
+	mu     sync.Mutex
+	queues map[string][]Cassette
+	last   map[string]Cassette
+}
+
+// NewReplayer loads the cassettes in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no cassettes in %s", dir)
+	}
+
+	cassettes := make([]Cassette, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var c Cassette
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+		}
+		cassettes = append(cassettes, c)
+	}
+	sort.SliceStable(cassettes, func(i, j int) bool { return cassettes[i].StartedAt.Before(cassettes[j].StartedAt) })
+
+	r := &Replayer{queues: make(map[string][]Cassette), last: make(map[string]Cassette)}
+	for _, c := range cassettes {
+		r.queues[c.key()] = append(r.queues[c.key()], c)
+	}
+	return r, nil
+}
+
+// Len returns the number of cassettes not replayed yet.
+func (r *Replayer) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, queue := range r.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// next returns the cassette answering a run described by c.
+func (r *Replayer) next(c Cassette) (Cassette, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := c.key()
+	if queue := r.queues[key]; len(queue) > 0 {
+		r.queues[key] = queue[1:]
+		r.last[key] = queue[0]
+		return queue[0], true
+	}
+	last, ok := r.last[key]
+	return last, ok
+}
+
+// cassette describes inv as the agent saw it, for recording or replay.
+func cassette(runner Runner, inv Invocation) Cassette {
+	c := Cassette{
+		RunID:     inv.RunID,
+		Agent:     inv.Agent,
+		Model:     inv.Model,
+		SessionID: inv.SessionID,
+		Prompt:    inv.Prompt,
+	}
+	if inv.WorkDir != "" {
+		c.WorkDir = runner.WorkDirPath(inv.WorkDir)
+	}
+	if inv.ResponseFile != "" {
+		c.ResponsePath = runner.ResponsePath(inv.SessionDir, inv.ResponseFile)
+	}
+	return c
+}
+
+// record writes the cassette of a finished run. Failing to record is
+// logged by the caller, not returned to the agent's caller.
+func (e *Executor) record(runner Runner, inv Invocation, start time.Time, stdout, stderr string, result Result) error {
+	c := cassette(runner, inv)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	c.StartedAt = start
+	c.DurationMs = time.Since(start).Milliseconds()
+
+	if inv.ResponseFile != "" {
+		if content, err := runner.ReadResponse(inv.SessionDir, inv.ResponseFile); err == nil {
+			c.Response = &content
+		}
+	}
+
+	if result.Error != nil {
+		var kerr *Error
+		if !errors.As(result.Error, &kerr) {
+			kerr = &Error{Class: ErrorOther, ExitCode: -1, Err: result.Error}
+		}
+		c.ExitCode = kerr.ExitCode
+		c.Error = &CassetteError{Class: kerr.Class, Signal: kerr.Signal, Stderr: kerr.Stderr}
+		if kerr.Err != nil {
+			c.Error.Message = kerr.Err.Error()
+		}
+	}
+	return e.recorder.Record(c)
+}
+
+// replay answers a run from its cassette instead of starting kiro-cli,
+// writing the recorded response file into the session directory.
+func (e *Executor) replay(callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile string) Result {
+	runner := e.RunnerFor(agentName)
+	inv := e.invocation(callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
+
+	c, ok := e.replayer.next(cassette(runner, inv))
+	if !ok {
+		return Result{Error: &Error{Class: ErrorOther, ExitCode: -1, Err: fmt.Errorf("no recorded run of %s matches this prompt", agentName)}}
+	}
+	if c.Response != nil && responseFile != "" {
+		if err := os.WriteFile(filepath.Join(sessionDir, responseFile), []byte(*c.Response), 0644); err != nil {
+			return Result{Error: &Error{Class: ErrorOther, ExitCode: -1, Err: fmt.Errorf("failed to replay response file: %w", err)}}
+		}
+	}
+	return c.result()
+}
@@ -0,0 +1,105 @@
+package kiro
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	cassettes := t.TempDir()
+	recorder, err := NewRecorder(cassettes)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+
+	sessionDir := t.TempDir()
+	runner := &scriptRunner{script: `echo thinking; echo warning >&2; printf 'first answer' > ` + filepath.Join(sessionDir, "response-1.txt")}
+	executor := NewExecutorWithRunner(runner, time.Minute, nil, false)
+	executor.SetRecorder(recorder)
+
+	prompt := "In directory /src/app, fix it. Write to " + filepath.Join(sessionDir, "response-1.txt")
+	recorded := executor.ExecuteWithWorkDir(context.Background(), "developer", prompt, sessionDir, "", "test-model", "/src/app", "response-1.txt")
+	if recorded.Error != nil {
+		t.Fatalf("Run failed: %v", recorded.Error)
+	}
+
+	runner.script = `echo "Token has expired" >&2; exit 1`
+	executor.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	failed := executor.ExecuteWithWorkDir(context.Background(), "developer", "try again", sessionDir, "session-1", "test-model", "/src/app", "response-2.txt")
+
+	// Replay in another session directory and checkout, without kiro-cli
+	replayer, err := NewReplayer(cassettes)
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+	if replayer.Len() != 2 {
+		t.Fatalf("Expected 2 cassettes, got %d", replayer.Len())
+	}
+	replaying := NewExecutorWithRunner(NewDirectRunner("/nonexistent/kiro-cli"), time.Minute, nil, false)
+	replaying.SetReplayer(replayer)
+
+	replayDir := t.TempDir()
+	prompt = "In directory /other/app, fix it. Write to " + filepath.Join(replayDir, "response-9.txt")
+	result := replaying.ExecuteWithWorkDir(context.Background(), "developer", prompt, replayDir, "", "test-model", "/other/app", "response-9.txt")
+	if result.Error != nil || result.Output != recorded.Output {
+		t.Errorf("Expected the recorded output %q, got %q (%v)", recorded.Output, result.Output, result.Error)
+	}
+	if content, _ := os.ReadFile(filepath.Join(replayDir, "response-9.txt")); string(content) != "first answer" {
+		t.Errorf("Expected the recorded response file, got %q", content)
+	}
+
+	result = replaying.ExecuteWithWorkDir(context.Background(), "developer", "try again", replayDir, "session-2", "test-model", "/other/app", "response-10.txt")
+	if ClassOf(result.Error) != ErrorAuthExpired || result.Error.Error() != failed.Error.Error() {
+		t.Errorf("Expected the recorded failure %v, got %v", failed.Error, result.Error)
+	}
+
+	result = replaying.ExecuteWithWorkDir(context.Background(), "developer", "something new", replayDir, "", "test-model", "/other/app", "response-11.txt")
+	if result.Error == nil {
+		t.Error("Expected a prompt without a cassette to fail")
+	}
+}
+
+func TestReplayer_Order(t *testing.T) {
+	dir := t.TempDir()
+	recorder, _ := NewRecorder(dir)
+	start := time.Now()
+	for i, output := range []string{"first", "second"} {
+		recorder.Record(Cassette{RunID: output, Agent: "developer", Prompt: "retry", Stdout: output, StartedAt: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	replayer, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+	for _, want := range []string{"first", "second", "second"} {
+		c, ok := replayer.next(Cassette{Agent: "developer", Prompt: "retry"})
+		if !ok || c.Stdout != want {
+			t.Errorf("Expected %q, got %q", want, c.Stdout)
+		}
+	}
+}
+
+func TestRecorder_Permissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cassettes")
+	recorder, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	if err := recorder.Record(Cassette{RunID: "1a2b3c4d", Agent: "developer", Prompt: "secret"}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the cassette directory to be private: %v", err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("Expected 1 cassette, got %d", len(paths))
+	}
+	if info, err := os.Stat(paths[0]); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the cassette to be private: %v", err)
+	}
+}
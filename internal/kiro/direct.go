@@ -0,0 +1,72 @@
+package kiro
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// DirectRunner runs kiro-cli on the host, in the session directory. A
+// read-only working directory is enforced with a read-only bind mount
+// (bubblewrap) on Linux and a sandbox-exec profile on macOS.
+type DirectRunner struct {
+	Binary string
+}
+
+func NewDirectRunner(binary string) *DirectRunner {
+	return &DirectRunner{Binary: binary}
+}
+
+func (r *DirectRunner) Command(ctx context.Context, inv Invocation) (*exec.Cmd, error) {
+	args := append([]string{r.Binary}, kiroArgs(inv)...)
+	if inv.Sandbox.ReadOnlyWorkDir && inv.WorkDir != "" {
+		var err error
+		if args, err = readOnlyArgs(inv.WorkDir, args); err != nil {
+			return nil, &Error{Class: ErrorOther, ExitCode: -1, Err: err}
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = inv.SessionDir
+	return cmd, nil
+}
+
+// Preview describes the kiro-cli process for inv, including the sandbox
+// wrapping a read-only working directory.
+func (r *DirectRunner) Preview(inv Invocation) (Preview, error) {
+	cmd, err := r.Command(context.Background(), inv)
+	if err != nil {
+		return Preview{}, err
+	}
+	if isBwrap(cmd.Args) {
+		p := bwrapPreview(cmd.Args)
+		p.Dir = cmd.Dir
+		return p, nil
+	}
+	return Preview{Command: cmd.Args, Dir: cmd.Dir, InheritEnv: true}, nil
+}
+
+func (r *DirectRunner) Stop(inv Invocation, grace time.Duration) {}
+
+func (r *DirectRunner) Cleanup(inv Invocation, aborted bool) {}
+
+func (r *DirectRunner) WorkDirPath(workDir string) string {
+	return workDir
+}
+
+func (r *DirectRunner) ResponsePath(sessionDir, responseFile string) string {
+	return filepath.Join(sessionDir, responseFile)
+}
+
+func (r *DirectRunner) ReadResponse(sessionDir, responseFile string) (string, error) {
+	return readResponseFile(sessionDir, responseFile)
+}
+
+func (r *DirectRunner) AppendSessionFile(sessionDir, name, content string) error {
+	return appendFile(filepath.Join(sessionDir, name), content)
+}
+
+func (r *DirectRunner) Containerized() bool {
+	return false
+}
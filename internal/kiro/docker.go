@@ -0,0 +1,165 @@
+package kiro
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"budgie/internal/container"
+)
+
+const containerDataDir = "/root/.local/share/kiro-cli"
+
+// DockerRunner runs kiro-cli in a throwaway container per run, using Docker
+// or a compatible runtime such as Podman. The host session directory is
+// bind-mounted at kiro-cli's data directory, so response files and debug
+// logs are read and written on the host without extra containers.
+type DockerRunner struct {
+	Runtime container.Runtime
+	Image   string
+	// Egress provides the proxy for kiro-api-only runs
+	Egress        EgressProvider
+	KiroConfigDir string
+	AuthSourceDir string
+}
+
+func NewDockerRunner(runtime container.Runtime, image string) *DockerRunner {
+	homeDir, _ := os.UserHomeDir()
+	return &DockerRunner{
+		Runtime:       runtime,
+		Image:         image,
+		KiroConfigDir: filepath.Join(homeDir, ".kiro"),
+		AuthSourceDir: defaultAuthSourceDir(),
+	}
+}
+
+func (r *DockerRunner) Command(ctx context.Context, inv Invocation) (*exec.Cmd, error) {
+	args, err := r.runArgs(inv, r.Egress)
+	if err != nil {
+		return nil, err
+	}
+	return r.Runtime.CommandContext(ctx, args...), nil
+}
+
+// Preview describes the container run for inv without starting it.
+func (r *DockerRunner) Preview(inv Invocation) (Preview, error) {
+	args, err := r.runArgs(inv, previewEgress(r.Egress))
+	if err != nil {
+		return Preview{}, err
+	}
+	return dockerPreview(r.Runtime.Command(args...).Args), nil
+}
+
+// runArgs returns the runtime arguments that run kiro-cli for inv.
+func (r *DockerRunner) runArgs(inv Invocation, egress EgressProvider) ([]string, error) {
+	args := []string{"run"}
+	if !keepContainer(inv) {
+		args = append(args, "--rm")
+	}
+	args = append(args, stdinArgs(inv)...)
+	args = append(args, r.Runtime.RunArgs()...)
+
+	if inv.RunID != "" {
+		args = append(args, "--name", r.containerName(inv))
+	}
+
+	netArgs, err := networkArgs(inv.Network, egress)
+	if err != nil {
+		return nil, &Error{Class: ErrorDockerUnavailable, ExitCode: -1, Err: err}
+	}
+
+	args = append(args, inv.Resources.runArgs()...)
+	args = append(args, netArgs...)
+	args = append(args, r.mountArgs(inv)...)
+	args = append(args, inv.Sandbox.image(r.Image), "kiro-cli")
+	return append(args, kiroArgs(inv)...), nil
+}
+
+// Stop stops the run's container; killing the runtime client alone leaves it
+// running.
+func (r *DockerRunner) Stop(inv Invocation, grace time.Duration) {
+	if inv.RunID == "" {
+		return
+	}
+	r.Runtime.Command("stop", "--time", strconv.Itoa(int(grace.Seconds())), r.containerName(inv)).Run()
+}
+
+// Cleanup makes sure an aborted run's container is gone before returning so
+// it cannot keep writing to the working directory, and removes a container
+// kept for OOMKilled.
+func (r *DockerRunner) Cleanup(inv Invocation, aborted bool) {
+	if keepContainer(inv) {
+		r.Runtime.Command("rm", "-f", r.containerName(inv)).Run()
+		return
+	}
+	if aborted {
+		r.Stop(inv, 0)
+	}
+}
+
+// OOMKilled reports whether the runtime recorded an OOM kill in the run's
+// container.
+func (r *DockerRunner) OOMKilled(inv Invocation) bool {
+	if !keepContainer(inv) {
+		return false
+	}
+	output, err := r.Runtime.Command("inspect", "--format", "{{.State.OOMKilled}}", r.containerName(inv)).Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
+// keepContainer reports whether a run's container is kept after it exits
+// rather than started with --rm: with resource limits, so OOMKilled can
+// inspect it before Cleanup removes it.
+func keepContainer(inv Invocation) bool {
+	return inv.RunID != "" && !inv.Resources.IsZero()
+}
+
+func (r *DockerRunner) WorkDirPath(workDir string) string {
+	return "/workspace"
+}
+
+func (r *DockerRunner) ResponsePath(sessionDir, responseFile string) string {
+	return containerDataDir + "/" + responseFile
+}
+
+func (r *DockerRunner) ReadResponse(sessionDir, responseFile string) (string, error) {
+	return readResponseFile(sessionDir, responseFile)
+}
+
+func (r *DockerRunner) AppendSessionFile(sessionDir, name, content string) error {
+	return appendFile(filepath.Join(sessionDir, name), content)
+}
+
+func (r *DockerRunner) Containerized() bool {
+	return true
+}
+
+// mountArgs returns the volume and environment flags shared by every
+// container for inv.
+func (r *DockerRunner) mountArgs(inv Invocation) []string {
+	args := []string{
+		"-v", inv.SessionDir + ":" + containerDataDir + ":rw",
+		"-v", r.AuthSourceDir + ":/auth:ro",
+		"-v", r.KiroConfigDir + ":/root/.kiro:ro",
+	}
+
+	if inv.WorkDir != "" {
+		args = append(args, "-v", inv.WorkDir+":/workspace:"+inv.Sandbox.workDirMode())
+	}
+	for _, m := range inv.Sandbox.Mounts {
+		args = append(args, "-v", m.Source+":"+m.target()+":ro")
+	}
+	// -e NAME copies the variable from budgie's environment, if set
+	for _, name := range inv.Sandbox.Env {
+		args = append(args, "-e", name)
+	}
+	return args
+}
+
+func (r *DockerRunner) containerName(inv Invocation) string {
+	return "budgie-run-" + inv.RunID
+}
@@ -0,0 +1,245 @@
+package kiro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrorClass names a category of execution failure.
+type ErrorClass string
+
+const (
+	ErrorTimeout           ErrorClass = "timeout"
+	ErrorCancelled         ErrorClass = "cancelled"
+	ErrorCrash             ErrorClass = "crash"
+	ErrorExit              ErrorClass = "exit"
+	ErrorAuthExpired       ErrorClass = "auth_expired"
+	ErrorRateLimited       ErrorClass = "rate_limited"
+	ErrorBinaryMissing     ErrorClass = "binary_missing"
+	ErrorDockerUnavailable ErrorClass = "docker_unavailable"
+	ErrorResourceLimit     ErrorClass = "resource_limit"
+	ErrorReadOnly          ErrorClass = "read_only"
+	ErrorOther             ErrorClass = "other"
+)
+
+var errorClasses = []ErrorClass{
+	ErrorTimeout,
+	ErrorCancelled,
+	ErrorCrash,
+	ErrorExit,
+	ErrorAuthExpired,
+	ErrorRateLimited,
+	ErrorBinaryMissing,
+	ErrorDockerUnavailable,
+	ErrorResourceLimit,
+	ErrorReadOnly,
+	ErrorOther,
+}
+
+// Known stderr fragments, matched case-insensitively
+var (
+	authExpiredPatterns = []string{
+		"not logged in",
+		"please log in",
+		"login required",
+		"kiro-cli login",
+		"token expired",
+		"expired token",
+		"token has expired",
+		"unauthorized",
+		"authentication failed",
+	}
+	rateLimitedPatterns = []string{
+		"rate limit",
+		"rate-limit",
+		"too many requests",
+		"throttl",
+	}
+	dockerUnavailablePatterns = []string{
+		"cannot connect to the docker daemon",
+		"is the docker daemon running",
+		"error during connect",
+		"docker daemon is not running",
+		"cannot connect to podman",
+		"unable to connect to podman socket",
+	}
+	resourceLimitPatterns = []string{
+		"out of memory",
+		"cannot allocate memory",
+		"resource temporarily unavailable",
+		"no space left on device",
+	}
+)
+
+// Error is a classified agent execution failure.
+type Error struct {
+	Class ErrorClass
+	// ExitCode is the process exit code, or -1 if the process did not exit normally
+	ExitCode int
+	// Signal describes the signal that killed the process, if any
+	Signal string
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	detail := e.Stderr
+	if detail == "" && e.Err != nil {
+		detail = e.Err.Error()
+	}
+
+	switch e.Class {
+	case ErrorTimeout:
+		return e.Err.Error()
+	case ErrorCancelled:
+		return "agent cancelled"
+	case ErrorReadOnly:
+		return e.Err.Error()
+	case ErrorBinaryMissing:
+		return fmt.Sprintf("kiro-cli binary not found: %s", detail)
+	case ErrorExit:
+		return fmt.Sprintf("kiro-cli failed (exit code %d): %s", e.ExitCode, detail)
+	}
+	return fmt.Sprintf("kiro-cli failed: %s", detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ClassOf returns the class of err, or ErrorOther if err is not a
+// classified execution error.
+func ClassOf(err error) ErrorClass {
+	var execErr *Error
+	if errors.As(err, &execErr) {
+		return execErr.Class
+	}
+	return ErrorOther
+}
+
+// ParseErrorClasses parses a comma-separated list of error classes.
+func ParseErrorClasses(s string) ([]ErrorClass, error) {
+	var classes []ErrorClass
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		class := ErrorClass(part)
+		if !class.valid() {
+			return nil, fmt.Errorf("unknown error class: %s", part)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+func (c ErrorClass) valid() bool {
+	for _, class := range errorClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyRun turns the outcome of a kiro-cli run into an *Error using the
+// contexts, the exit status and known stderr patterns. sandboxed reports
+// whether the command was a container runtime wrapping kiro-cli, limited
+// whether that container had resource limits, and oomKilled whether the
+// runtime reported an OOM kill in it.
+func classifyRun(ctx, timeoutCtx context.Context, timeout time.Duration, runErr error, stderr string, sandboxed, limited, oomKilled bool) *Error {
+	stderr = strings.TrimSpace(stderr)
+	result := &Error{
+		Class:    ErrorOther,
+		ExitCode: -1,
+		Stderr:   stderr,
+		Err:      runErr,
+	}
+
+	if ctx.Err() == context.Canceled {
+		result.Class = ErrorCancelled
+		return result
+	}
+	if timeoutCtx.Err() == context.DeadlineExceeded {
+		result.Class = ErrorTimeout
+		result.Err = fmt.Errorf("agent timeout after %v", timeout)
+		return result
+	}
+
+	if errors.Is(runErr, exec.ErrNotFound) || errors.Is(runErr, os.ErrNotExist) {
+		result.Class = ErrorBinaryMissing
+		if sandboxed {
+			result.Class = ErrorDockerUnavailable
+		}
+		return result
+	}
+
+	lowerStderr := strings.ToLower(stderr)
+	if sandboxed && containsAny(lowerStderr, dockerUnavailablePatterns) {
+		result.Class = ErrorDockerUnavailable
+		return result
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	}
+
+	switch {
+	case containsAny(lowerStderr, authExpiredPatterns):
+		result.Class = ErrorAuthExpired
+	case containsAny(lowerStderr, rateLimitedPatterns):
+		result.Class = ErrorRateLimited
+	case oomKilled && result.ExitCode == 137:
+		// SIGKILL from the OOM killer, as the runtime confirmed; 137 alone
+		// may be any SIGKILL
+		result.Class = ErrorResourceLimit
+		result.Signal = "signal 9"
+	case limited && containsAny(lowerStderr, resourceLimitPatterns):
+		// Failed fork under the pids limit, full tmpfs, failed allocation
+		result.Class = ErrorResourceLimit
+	case exitErr != nil && result.ExitCode == -1:
+		// Killed by a signal
+		result.Class = ErrorCrash
+		result.Signal = exitErr.String()
+	case sandboxed && result.ExitCode == 125:
+		// docker run itself failed (daemon, image or flag errors)
+		result.Class = ErrorDockerUnavailable
+	case sandboxed && (result.ExitCode == 126 || result.ExitCode == 127):
+		// kiro-cli missing or not executable inside the container
+		result.Class = ErrorBinaryMissing
+	case result.ExitCode > 128:
+		// Shells and container runtimes report death by signal N as 128+N
+		result.Class = ErrorCrash
+		result.Signal = fmt.Sprintf("signal %d", result.ExitCode-128)
+	case result.ExitCode > 0:
+		result.Class = ErrorExit
+	}
+
+	return result
+}
+
+// exitCode returns the exit code of a finished run, or -1 if it did not
+// exit normally.
+func exitCode(runErr error) int {
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func containsAny(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(s, pattern) {
+			return true
+		}
+	}
+	return false
+}
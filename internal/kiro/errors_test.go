@@ -0,0 +1,158 @@
+package kiro
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"budgie/internal/container"
+)
+
+func exitError(t *testing.T, script string) error {
+	err := exec.Command("sh", "-c", script).Run()
+	if err == nil {
+		t.Fatalf("Expected %q to fail", script)
+	}
+	return err
+}
+
+func TestClassifyRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		stderr    string
+		sandboxed bool
+		limited   bool
+		oomKilled bool
+		expected  ErrorClass
+	}{
+		{"non-zero exit", "exit 3", "something went wrong", false, false, false, ErrorExit},
+		{"signal", "kill -9 $$", "", false, false, false, ErrorCrash},
+		{"signal exit code", "exit 137", "", false, false, false, ErrorCrash},
+		{"auth expired", "exit 1", "Error: Token has expired, run kiro-cli login", false, false, false, ErrorAuthExpired},
+		{"not logged in", "exit 1", "You are not logged in", false, false, false, ErrorAuthExpired},
+		{"rate limited", "exit 1", "ThrottlingException: Too many requests", false, false, false, ErrorRateLimited},
+		{"docker daemon down", "exit 1", "Cannot connect to the Docker daemon at unix:///var/run/docker.sock", true, false, false, ErrorDockerUnavailable},
+		{"docker run failure", "exit 125", "Unable to find image", true, false, false, ErrorDockerUnavailable},
+		{"kiro-cli missing in image", "exit 127", "exec: kiro-cli: not found", true, false, false, ErrorBinaryMissing},
+		{"exit 125 outside sandbox", "exit 125", "", false, false, false, ErrorExit},
+		{"oom killed", "exit 137", "", true, true, true, ErrorResourceLimit},
+		{"sigkill under limits", "exit 137", "", true, true, false, ErrorCrash},
+		{"sigkill without limits", "exit 137", "", true, false, false, ErrorCrash},
+		{"pids limit", "exit 1", "bash: fork: retry: Resource temporarily unavailable", true, true, false, ErrorResourceLimit},
+		{"tmpfs full", "exit 1", "write /tmp/build.o: no space left on device", true, true, false, ErrorResourceLimit},
+	}
+
+	for _, tt := range tests {
+		err := classifyRun(context.Background(), context.Background(), time.Minute, exitError(t, tt.script), tt.stderr, tt.sandboxed, tt.limited, tt.oomKilled)
+		if err.Class != tt.expected {
+			t.Errorf("%s: class = %s, want %s", tt.name, err.Class, tt.expected)
+		}
+	}
+}
+
+func TestClassifyRun_ExitCode(t *testing.T) {
+	err := classifyRun(context.Background(), context.Background(), time.Minute, exitError(t, "exit 3"), "", false, false, false)
+
+	if err.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", err.ExitCode)
+	}
+}
+
+func TestClassOf(t *testing.T) {
+	err := &Error{Class: ErrorRateLimited, ExitCode: 1}
+	wrapped := errors.Join(errors.New("context"), err)
+
+	if ClassOf(wrapped) != ErrorRateLimited {
+		t.Errorf("Expected rate_limited, got %s", ClassOf(wrapped))
+	}
+	if ClassOf(errors.New("plain")) != ErrorOther {
+		t.Errorf("Expected other for unclassified error, got %s", ClassOf(errors.New("plain")))
+	}
+}
+
+func TestExecute_BinaryMissing(t *testing.T) {
+	executor := NewExecutor("nonexistent-binary-12345", 1*time.Minute, nil, false, "", false)
+
+	result := executor.Execute(context.Background(), "test-agent", "test prompt", t.TempDir(), "", "")
+
+	if ClassOf(result.Error) != ErrorBinaryMissing {
+		t.Errorf("Expected binary_missing, got %s (%v)", ClassOf(result.Error), result.Error)
+	}
+	if result.Retried {
+		t.Error("Missing binary should not be retried")
+	}
+}
+
+func TestExecute_TimeoutAndCancel(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "slow-kiro")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 10\n"), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	executor := NewExecutor(script, 100*time.Millisecond, nil, false, "", false)
+	executor.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	result := executor.Execute(context.Background(), "test-agent", "test", t.TempDir(), "", "")
+	if ClassOf(result.Error) != ErrorTimeout {
+		t.Errorf("Expected timeout, got %s (%v)", ClassOf(result.Error), result.Error)
+	}
+
+	executor = NewExecutor(script, 1*time.Minute, nil, false, "", false)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	result = executor.Execute(ctx, "test-agent", "test", t.TempDir(), "", "")
+	if ClassOf(result.Error) != ErrorCancelled {
+		t.Errorf("Expected cancelled, got %s (%v)", ClassOf(result.Error), result.Error)
+	}
+}
+
+func TestExecute_OOMKilledNeedsRuntimeConfirmation(t *testing.T) {
+	for _, oomKilled := range []string{"true", "false"} {
+		dir := t.TempDir()
+		logFile := filepath.Join(dir, "calls.log")
+		script := filepath.Join(dir, "fake-docker")
+		// The container exits with 137; inspect reports whether it was OOM
+		content := "#!/bin/sh\necho \"$1\" >> " + logFile + "\ncase \"$1\" in\n  run) exit 137 ;;\n  inspect) echo " + oomKilled + " ;;\nesac\n"
+		if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		executor := NewExecutorWithRunner(NewDockerRunner(container.Runtime(script), "image"), time.Minute, nil, false)
+		executor.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		executor.SetResources(Resources{Memory: "1g"})
+
+		result := executor.Execute(context.Background(), "test-agent", "test", t.TempDir(), "", "")
+		want := ErrorCrash
+		if oomKilled == "true" {
+			want = ErrorResourceLimit
+		}
+		if ClassOf(result.Error) != want {
+			t.Errorf("OOMKilled %s: class = %s, want %s", oomKilled, ClassOf(result.Error), want)
+		}
+
+		calls, _ := os.ReadFile(logFile)
+		if string(calls) != "run\ninspect\nrm\n" {
+			t.Errorf("Expected the container to be inspected before removal, got %q", calls)
+		}
+	}
+}
+
+func TestOOMKillCount(t *testing.T) {
+	v2 := "low 0\nhigh 0\nmax 12\noom 2\noom_kill 2\noom_group_kill 0\n"
+	v1 := "oom_kill_disable 0\nunder_oom 0\noom_kill 1\n"
+	if n, ok := oomKillCount(v2); !ok || n != 2 {
+		t.Errorf("Expected 2 from memory.events, got %d, %v", n, ok)
+	}
+	if n, ok := oomKillCount(v1); !ok || n != 1 {
+		t.Errorf("Expected 1 from memory.oom_control, got %d, %v", n, ok)
+	}
+	if _, ok := oomKillCount("cat: no such file"); ok {
+		t.Error("Expected no count without a cgroup file")
+	}
+}
@@ -3,28 +3,59 @@ package kiro
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"log"
 	"os/exec"
-	"path/filepath"
-	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"budgie/internal/container"
 	"budgie/internal/health"
+	"budgie/internal/limiter"
 
 	"github.com/google/uuid"
 )
 
 type Executor struct {
-	binary         string
-	timeout        time.Duration
-	monitor        *health.Monitor
-	sandboxEnabled bool
-	sandboxImage   string
-	kiroConfigDir  string
-	authSourceDir  string
-	verbose        bool
+	runner      Runner
+	timeout     time.Duration
+	monitor     *health.Monitor
+	verbose     bool
+	limiter     *limiter.Limiter
+	retryPolicy RetryPolicy
+	killGrace   time.Duration
+	resources   Resources
+	network     NetworkPolicy
+	recorder    *Recorder
+	replayer    *Replayer
+
+	promptDelivery PromptDelivery
+	promptArgMax   int
+
+	optionsMu sync.RWMutex
+	options   map[string]AgentOptions
+}
+
+// AgentOptions holds per-agent execution settings, usually taken from the
+// agent's prompt frontmatter.
+type AgentOptions struct {
+	// MaxConcurrent overrides the per-agent concurrency limit (0 keeps the default)
+	MaxConcurrent int
+	// Priority orders runs waiting for a slot; higher runs first
+	Priority int
+	// Retry overrides the executor's retry policy
+	Retry *RetryPolicy
+	// Resources overrides the default resource limits field by field
+	Resources *Resources
+	// Network overrides the default network policy
+	Network NetworkPolicy
+	// Sandbox is applied to the agent's sandboxed runs
+	Sandbox SandboxProfile
+	// Runner overrides the executor's runner, e.g. to sandbox one agent
+	// while others run directly
+	Runner Runner
 }
 
 type Result struct {
@@ -32,31 +63,118 @@ type Result struct {
 	SessionID string
 	Error     error
 	Duration  time.Duration
+	QueueWait time.Duration
 	Retried   bool
 }
 
 func NewExecutor(binary string, timeout time.Duration, monitor *health.Monitor, sandboxEnabled bool, sandboxImage string, verbose bool) *Executor {
-	homeDir, _ := os.UserHomeDir()
-
-	var authSourceDir string
-	if runtime.GOOS == "darwin" {
-		authSourceDir = filepath.Join(homeDir, "Library", "Application Support", "kiro-cli")
+	var runner Runner
+	if sandboxEnabled {
+		runner = NewDockerRunner(container.Docker, sandboxImage)
 	} else {
-		authSourceDir = filepath.Join(homeDir, ".local", "share", "kiro-cli")
+		runner = NewDirectRunner(binary)
 	}
+	return NewExecutorWithRunner(runner, timeout, monitor, verbose)
+}
 
+// NewExecutorWithRunner creates an executor that runs kiro-cli through the
+// given backend.
+func NewExecutorWithRunner(runner Runner, timeout time.Duration, monitor *health.Monitor, verbose bool) *Executor {
 	return &Executor{
-		binary:         binary,
-		timeout:        timeout,
-		monitor:        monitor,
-		sandboxEnabled: sandboxEnabled,
-		sandboxImage:   sandboxImage,
-		kiroConfigDir:  filepath.Join(homeDir, ".kiro"),
-		authSourceDir:  authSourceDir,
-		verbose:        verbose,
+		runner:      runner,
+		timeout:     timeout,
+		monitor:     monitor,
+		verbose:     verbose,
+		retryPolicy: DefaultRetryPolicy(),
+		killGrace:   5 * time.Second,
+		options:     make(map[string]AgentOptions),
+
+		promptDelivery: PromptAuto,
+		promptArgMax:   DefaultPromptArgMax,
+	}
+}
+
+// Runner returns the default execution backend.
+func (e *Executor) Runner() Runner {
+	return e.runner
+}
+
+// RunnerFor returns the execution backend used for an agent.
+func (e *Executor) RunnerFor(agentName string) Runner {
+	if runner := e.agentOptions(agentName).Runner; runner != nil {
+		return runner
+	}
+	return e.runner
+}
+
+// SetLimiter bounds concurrent runs. Runs wait for a slot before starting,
+// and the wait is recorded separately from execution time.
+func (e *Executor) SetLimiter(l *limiter.Limiter) {
+	e.limiter = l
+}
+
+// SetRetryPolicy sets the retry policy for agents without their own policy.
+func (e *Executor) SetRetryPolicy(policy RetryPolicy) {
+	e.retryPolicy = policy
+}
+
+// SetKillGrace sets how long a cancelled run gets after SIGTERM before its
+// process group is killed.
+func (e *Executor) SetKillGrace(grace time.Duration) {
+	e.killGrace = grace
+}
+
+// SetResources sets the default resource limits for sandboxed runs.
+func (e *Executor) SetResources(r Resources) {
+	e.resources = r
+}
+
+// SetNetworkPolicy sets the network policy for agents without their own.
+func (e *Executor) SetNetworkPolicy(policy NetworkPolicy) {
+	e.network = policy
+}
+
+// SetPromptDelivery sets how prompts reach kiro-cli. argMax is the largest
+// prompt, in bytes, auto delivery passes as an argument.
+func (e *Executor) SetPromptDelivery(d PromptDelivery, argMax int) {
+	e.promptDelivery = d
+	e.promptArgMax = argMax
+}
+
+// SetRecorder records every run as a cassette.
+func (e *Executor) SetRecorder(r *Recorder) {
+	e.recorder = r
+}
+
+// SetReplayer answers runs from recorded cassettes instead of starting
+// kiro-cli.
+func (e *Executor) SetReplayer(r *Replayer) {
+	e.replayer = r
+}
+
+// SetAgentOptions sets per-agent execution settings.
+func (e *Executor) SetAgentOptions(agentName string, opts AgentOptions) {
+	e.optionsMu.Lock()
+	e.options[agentName] = opts
+	e.optionsMu.Unlock()
+
+	if e.limiter != nil && opts.MaxConcurrent > 0 {
+		e.limiter.SetAgentLimit(agentName, opts.MaxConcurrent)
 	}
 }
 
+func (e *Executor) agentOptions(agentName string) AgentOptions {
+	e.optionsMu.RLock()
+	defer e.optionsMu.RUnlock()
+	return e.options[agentName]
+}
+
+// ReadOnlyWorkDir reports whether an agent's working directory is mounted
+// read-only.
+func (e *Executor) ReadOnlyWorkDir(agentName string) bool {
+	return e.agentOptions(agentName).Sandbox.ReadOnlyWorkDir
+}
+
 // GetUniqueResponseFile generates a unique response filename for a workspace
 func GetUniqueResponseFile(sessionDir string) string {
 	return fmt.Sprintf("response-%s.txt", uuid.New().String()[:8])
@@ -64,17 +182,22 @@ func GetUniqueResponseFile(sessionDir string) string {
 
 // GetAuthSourceDir returns the auth source directory
 func (e *Executor) GetAuthSourceDir() string {
-	return e.authSourceDir
+	if r, ok := e.runner.(*DockerRunner); ok {
+		return r.AuthSourceDir
+	}
+	return defaultAuthSourceDir()
 }
 
 // BuildDirectCommand builds a direct kiro-cli command (for testing)
 func (e *Executor) BuildDirectCommand(ctx context.Context, agentName, prompt, sessionDir, sessionID, model string) *exec.Cmd {
-	return e.buildDirectCommand(ctx, agentName, prompt, sessionDir, sessionID, model)
+	cmd, _ := e.runner.Command(ctx, Invocation{Agent: agentName, Prompt: prompt, SessionDir: sessionDir, SessionID: sessionID, Model: model})
+	return cmd
 }
 
 // BuildDockerCommand builds a Docker command (for testing)
 func (e *Executor) BuildDockerCommand(ctx context.Context, agentName, prompt, sessionDir, sessionID, model, workDir string) *exec.Cmd {
-	return e.buildDockerCommand(ctx, agentName, prompt, sessionDir, sessionID, model, workDir)
+	cmd, _ := e.runner.Command(ctx, Invocation{Agent: agentName, Prompt: prompt, SessionDir: sessionDir, SessionID: sessionID, Model: model, WorkDir: workDir})
+	return cmd
 }
 
 func (e *Executor) Execute(ctx context.Context, agentName, prompt, sessionDir, sessionID, model string) Result {
@@ -83,30 +206,39 @@ func (e *Executor) Execute(ctx context.Context, agentName, prompt, sessionDir, s
 
 func (e *Executor) ExecuteWithWorkDir(ctx context.Context, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile string) Result {
 	start := time.Now()
+	policy := e.retryPolicyFor(agentName)
 
-	result := e.executeOnce(ctx, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
+	// Every attempt reads the same prompt file
+	callID := uuid.New().String()[:8]
 
-	if result.Error != nil && shouldRetry(result.Error) {
-		time.Sleep(2 * time.Second)
-		retryResult := e.executeOnce(ctx, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
-		retryResult.Retried = true
+	var result Result
+	var queueWait time.Duration
+	for attempt := 1; ; attempt++ {
+		result = e.executeOnce(ctx, callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
+		result.Retried = attempt > 1
+		queueWait += result.QueueWait
 
-		if retryResult.Error == nil {
-			if e.monitor != nil {
-				e.monitor.RecordSuccess(agentName, time.Since(start))
-			}
-			return retryResult
+		if result.Error == nil || ctx.Err() != nil {
+			break
+		}
+		if attempt >= policy.MaxAttempts || !policy.ShouldRetry(ClassOf(result.Error)) {
+			break
 		}
 
-		result = retryResult
+		backoff := policy.Backoff(attempt)
+		if policy.MaxElapsed > 0 && time.Since(start)+backoff > policy.MaxElapsed {
+			break
+		}
+		if err := sleepContext(ctx, backoff); err != nil {
+			break
+		}
 	}
+	result.QueueWait = queueWait
 
 	if e.monitor != nil {
-		duration := time.Since(start)
+		duration := time.Since(start) - queueWait
 		if result.Error != nil {
-			isTimeout := strings.Contains(result.Error.Error(), "timeout") ||
-				strings.Contains(result.Error.Error(), "deadline exceeded")
-			e.monitor.RecordFailure(agentName, duration, result.Error.Error(), isTimeout)
+			e.monitor.RecordFailure(agentName, duration, result.Error.Error(), string(ClassOf(result.Error)))
 		} else {
 			e.monitor.RecordSuccess(agentName, duration)
 		}
@@ -115,153 +247,205 @@ func (e *Executor) ExecuteWithWorkDir(ctx context.Context, agentName, prompt, se
 	return result
 }
 
-func (e *Executor) executeOnce(ctx context.Context, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile string) Result {
-	timeoutCtx, cancel := context.WithTimeout(ctx, e.timeout)
-	defer cancel()
-
-	var cmd *exec.Cmd
-
-	if e.sandboxEnabled {
-		cmd = e.buildDockerCommand(timeoutCtx, agentName, prompt, sessionDir, sessionID, model, workDir)
-	} else {
-		cmd = e.buildDirectCommand(timeoutCtx, agentName, prompt, sessionDir, sessionID, model)
+func (e *Executor) retryPolicyFor(agentName string) RetryPolicy {
+	if policy := e.agentOptions(agentName).Retry; policy != nil {
+		return *policy
 	}
+	return e.retryPolicy
+}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+func (e *Executor) resourcesFor(agentName string) Resources {
+	if r := e.agentOptions(agentName).Resources; r != nil {
+		return e.resources.Merge(*r)
+	}
+	return e.resources
+}
 
-	err := cmd.Run()
+func (e *Executor) networkFor(agentName string) NetworkPolicy {
+	if policy := e.agentOptions(agentName).Network; policy != "" {
+		return policy
+	}
+	return e.network
+}
 
-	// Save chat output for debugging
-	if e.verbose {
-		e.saveChatDebug(sessionDir, stdout.String(), stderr.String(), prompt, agentName, workDir, responseFile, err)
+func (e *Executor) executeOnce(ctx context.Context, callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile string) Result {
+	if e.replayer != nil {
+		return e.replay(callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
 	}
 
+	release, queueWait, err := e.acquireSlot(ctx, agentName)
 	if err != nil {
-		if timeoutCtx.Err() == context.DeadlineExceeded {
-			return Result{Error: fmt.Errorf("agent timeout after %v", e.timeout)}
+		class := ErrorCancelled
+		if errors.Is(err, context.DeadlineExceeded) {
+			class = ErrorTimeout
 		}
-
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg == "" {
-			errMsg = err.Error()
+		return Result{
+			Error:     &Error{Class: class, ExitCode: -1, Err: fmt.Errorf("gave up waiting for execution slot: %w", err)},
+			QueueWait: queueWait,
 		}
-		return Result{Error: fmt.Errorf("kiro-cli failed: %s", errMsg)}
 	}
+	defer release()
 
-	return Result{
-		Output: strings.TrimSpace(stdout.String()),
-		Error:  nil,
-	}
+	result := e.run(ctx, callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
+	result.QueueWait = queueWait
+	return result
 }
 
-func (e *Executor) saveChatDebug(sessionDir, stdout, stderr, prompt, agentName, workDir, responseFile string, execErr error) {
-	// Extract ID from responseFile (format: response-XXXXXXXX.txt) to match chat with response
-	chatID := strings.TrimSuffix(strings.TrimPrefix(responseFile, "response-"), ".txt")
-	chatFileName := fmt.Sprintf("chat-%s.txt", chatID)
-
-	var content strings.Builder
-	content.WriteString(fmt.Sprintf("=== Chat Debug: %s ===\n", time.Now().Format(time.RFC3339)))
-	content.WriteString(fmt.Sprintf("Agent: %s\n", agentName))
-	content.WriteString(fmt.Sprintf("Working Directory: %s\n", workDir))
-	content.WriteString(fmt.Sprintf("Response File: %s\n", responseFile))
-	content.WriteString(fmt.Sprintf("Prompt: %s\n", prompt))
-	content.WriteString("\n=== STDOUT ===\n")
-	content.WriteString(stdout)
-	content.WriteString("\n=== STDERR ===\n")
-	content.WriteString(stderr)
-	if execErr != nil {
-		content.WriteString(fmt.Sprintf("\n=== ERROR ===\n%v\n", execErr))
+// acquireSlot waits for the limiter, if any, and records the queue wait.
+func (e *Executor) acquireSlot(ctx context.Context, agentName string) (func(), time.Duration, error) {
+	if e.limiter == nil {
+		return func() {}, 0, nil
 	}
 
-	if e.sandboxEnabled {
-		// Append to volume using docker
-		volumeName := "budgie-session-" + sessionDir
-		cmd := exec.Command("docker", "run", "--rm", "-i",
-			"-v", volumeName+":/data:rw",
-			"alpine:latest",
-			"sh", "-c", fmt.Sprintf("cat >> /data/%s", chatFileName))
-		cmd.Stdin = strings.NewReader(content.String())
-		cmd.Run()
-	} else {
-		f, err := os.OpenFile(filepath.Join(sessionDir, chatFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err == nil {
-			f.WriteString(content.String())
-			f.Close()
-		}
+	start := time.Now()
+	release, err := e.limiter.Acquire(ctx, agentName, e.agentOptions(agentName).Priority)
+	wait := time.Since(start)
+
+	if e.monitor != nil {
+		e.monitor.RecordQueueWait(agentName, wait)
 	}
+	return release, wait, err
 }
 
-func (e *Executor) buildDirectCommand(ctx context.Context, agentName, prompt, sessionDir, sessionID, model string) *exec.Cmd {
-	args := []string{"chat", "--agent", agentName, "--no-interactive"}
-
-	if model != "" {
-		args = append(args, "--model", model)
+// Preview returns the process ExecuteWithWorkDir would start for its first
+// attempt, without starting it or anything it needs.
+func (e *Executor) Preview(agentName, prompt, sessionDir, sessionID, model, workDir, responseFile string) (Preview, error) {
+	inv := e.invocation(uuid.New().String()[:8], agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
+	p, err := e.RunnerFor(agentName).Preview(inv)
+	if err != nil {
+		return Preview{}, err
 	}
+	p.PromptDelivery = inv.Delivery
+	return p, nil
+}
 
-	if sessionID != "" {
-		args = append(args, "--resume")
+// invocation describes one run of an agent with its execution settings.
+// callID is shared by the attempts of one call.
+func (e *Executor) invocation(callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile string) Invocation {
+	inv := Invocation{
+		RunID:        uuid.New().String()[:8],
+		Agent:        agentName,
+		Prompt:       prompt,
+		SessionDir:   sessionDir,
+		SessionID:    sessionID,
+		Model:        model,
+		WorkDir:      workDir,
+		ResponseFile: responseFile,
+		Resources:    e.resourcesFor(agentName),
+		Network:      e.networkFor(agentName),
+		Sandbox:      e.agentOptions(agentName).Sandbox,
+		Delivery:     e.promptDelivery.resolve(prompt, e.promptArgMax),
+	}
+	if inv.Delivery == PromptFile {
+		inv.PromptPath = e.RunnerFor(agentName).ResponsePath(sessionDir, promptFileName(callID))
 	}
+	return inv
+}
 
-	args = append(args, prompt)
+func (e *Executor) run(ctx context.Context, callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile string) Result {
+	timeoutCtx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
 
-	cmd := exec.CommandContext(ctx, e.binary, args...)
-	cmd.Dir = sessionDir
-	return cmd
-}
+	runner := e.RunnerFor(agentName)
+	inv := e.invocation(callID, agentName, prompt, sessionDir, sessionID, model, workDir, responseFile)
+
+	if inv.Delivery == PromptFile {
+		if err := writePromptFile(sessionDir, callID, prompt); err != nil {
+			return Result{Error: &Error{Class: ErrorOther, ExitCode: -1, Err: fmt.Errorf("failed to write prompt file: %w", err)}}
+		}
+	}
 
-func (e *Executor) buildDockerCommand(ctx context.Context, agentName, prompt, sessionDir, sessionID, model, workDir string) *exec.Cmd {
-	volumeName := "budgie-session-" + sessionDir
+	cmd, err := runner.Command(timeoutCtx, inv)
+	if err != nil {
+		var kerr *Error
+		if !errors.As(err, &kerr) {
+			kerr = &Error{Class: ErrorOther, ExitCode: -1, Err: err}
+		}
+		return Result{Error: kerr}
+	}
 
-	args := []string{
-		"run", "--rm",
-		"-v", volumeName + ":/root/.local/share/kiro-cli:rw",
-		"-v", e.authSourceDir + ":/auth:ro",
-		"-v", e.kiroConfigDir + ":/root/.kiro:ro",
+	stopKill := configureProcessGroup(cmd, e.killGrace)
+	cancelGroup := cmd.Cancel
+	cmd.Cancel = func() error {
+		go runner.Stop(inv, e.killGrace)
+		return cancelGroup()
 	}
 
-	if workDir != "" {
-		args = append(args, "-v", workDir+":/workspace:rw")
+	if inv.Delivery == PromptStdin {
+		cmd.Stdin = strings.NewReader(prompt)
 	}
 
-	args = append(args, e.sandboxImage)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
-	kiroArgs := []string{"kiro-cli", "chat", "--agent", agentName, "--no-interactive"}
+	start := time.Now()
+	err = cmd.Run()
+	stopKill()
 
-	if model != "" {
-		kiroArgs = append(kiroArgs, "--model", model)
+	// The runner's container may be gone after Cleanup
+	oomKilled := false
+	if reporter, ok := runner.(oomReporter); ok && exitCode(err) == 137 && !inv.Resources.IsZero() {
+		oomKilled = reporter.OOMKilled(inv)
 	}
+	runner.Cleanup(inv, timeoutCtx.Err() != nil)
 
-	if sessionID != "" {
-		kiroArgs = append(kiroArgs, "--resume")
+	// Save chat output for debugging
+	if e.verbose {
+		e.saveChatDebug(runner, sessionDir, stdout.String(), stderr.String(), prompt, agentName, workDir, responseFile, err)
 	}
 
-	kiroArgs = append(kiroArgs, prompt)
-
-	args = append(args, kiroArgs...)
-
-	return exec.CommandContext(ctx, "docker", args...)
+	result := e.runResult(ctx, timeoutCtx, runner, inv, err, stdout.String(), stderr.String(), oomKilled)
+	if e.recorder != nil {
+		if err := e.record(runner, inv, start, stdout.String(), stderr.String(), result); err != nil {
+			log.Printf("Failed to record run of %s: %v", agentName, err)
+		}
+	}
+	return result
 }
 
-func shouldRetry(err error) bool {
-	if err == nil {
-		return false
+// runResult classifies a finished run.
+func (e *Executor) runResult(ctx, timeoutCtx context.Context, runner Runner, inv Invocation, err error, stdout, stderr string, oomKilled bool) Result {
+	violation := readOnlyViolation(inv, stderr)
+	if violation != nil && err == nil {
+		// kiro-cli carries on after a failed tool call, so a run that got
+		// past the failed write keeps its result
+		log.Printf("Warning: %s succeeded after a failed write: %v", inv.Agent, violation.Err)
 	}
 
-	errStr := err.Error()
-
-	if strings.Contains(errStr, "timeout") || strings.Contains(errStr, "deadline exceeded") {
-		return true
+	if err != nil {
+		if violation != nil && timeoutCtx.Err() == nil {
+			return Result{Error: violation}
+		}
+		sandboxed := runner.Containerized()
+		limited := sandboxed && !inv.Resources.IsZero()
+		return Result{Error: classifyRun(ctx, timeoutCtx, e.timeout, err, stderr, sandboxed, limited, oomKilled)}
 	}
 
-	if strings.Contains(errStr, "signal") || strings.Contains(errStr, "killed") {
-		return true
+	return Result{
+		Output: strings.TrimSpace(stdout),
+		Error:  nil,
 	}
+}
 
-	if strings.Contains(errStr, "exit status") && !strings.Contains(errStr, "required") {
-		return true
+func (e *Executor) saveChatDebug(runner Runner, sessionDir, stdout, stderr, prompt, agentName, workDir, responseFile string, execErr error) {
+	// Extract ID from responseFile (format: response-XXXXXXXX.txt) to match chat with response
+	chatID := strings.TrimSuffix(strings.TrimPrefix(responseFile, "response-"), ".txt")
+	chatFileName := fmt.Sprintf("chat-%s.txt", chatID)
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("=== Chat Debug: %s ===\n", time.Now().Format(time.RFC3339)))
+	content.WriteString(fmt.Sprintf("Agent: %s\n", agentName))
+	content.WriteString(fmt.Sprintf("Working Directory: %s\n", workDir))
+	content.WriteString(fmt.Sprintf("Response File: %s\n", responseFile))
+	content.WriteString(fmt.Sprintf("Prompt: %s\n", prompt))
+	content.WriteString("\n=== STDOUT ===\n")
+	content.WriteString(stdout)
+	content.WriteString("\n=== STDERR ===\n")
+	content.WriteString(stderr)
+	if execErr != nil {
+		content.WriteString(fmt.Sprintf("\n=== ERROR ===\n%v\n", execErr))
 	}
 
-	return false
+	runner.AppendSessionFile(sessionDir, chatFileName, content.String())
 }
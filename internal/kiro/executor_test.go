@@ -9,8 +9,9 @@ import (
 func TestNewExecutor(t *testing.T) {
 	executor := NewExecutor("/custom/path/kiro", 5*time.Minute, nil, false, "", false)
 
-	if executor.binary != "/custom/path/kiro" {
-		t.Errorf("Expected binary /custom/path/kiro, got %s", executor.binary)
+	runner, ok := executor.Runner().(*DirectRunner)
+	if !ok || runner.Binary != "/custom/path/kiro" {
+		t.Errorf("Expected direct runner for /custom/path/kiro, got %#v", executor.Runner())
 	}
 
 	if executor.timeout != 5*time.Minute {
@@ -0,0 +1,56 @@
+package kiro
+
+import "fmt"
+
+// NetworkPolicy controls a sandboxed run's network access.
+type NetworkPolicy string
+
+const (
+	// NetworkFull leaves the container on the default network
+	NetworkFull NetworkPolicy = "full"
+	// NetworkKiroAPIOnly allows egress only through budgie's allowlisting proxy
+	NetworkKiroAPIOnly NetworkPolicy = "kiro-api-only"
+	// NetworkNone gives the container no network at all
+	NetworkNone NetworkPolicy = "none"
+)
+
+// ParseNetworkPolicy returns the policy with the given name; empty means full.
+func ParseNetworkPolicy(s string) (NetworkPolicy, error) {
+	switch NetworkPolicy(s) {
+	case "", NetworkFull:
+		return NetworkFull, nil
+	case NetworkKiroAPIOnly, NetworkNone:
+		return NetworkPolicy(s), nil
+	}
+	return "", fmt.Errorf("unknown network policy %q (expected none, kiro-api-only or full)", s)
+}
+
+// EgressProvider supplies the network and proxy for kiro-api-only runs. It
+// is set up on first use.
+type EgressProvider interface {
+	// Endpoint returns the container network to join and the proxy URL to
+	// send all traffic through
+	Endpoint() (network, proxyURL string, err error)
+}
+
+// networkArgs returns the container `run` flags for policy.
+func networkArgs(policy NetworkPolicy, egress EgressProvider) ([]string, error) {
+	switch policy {
+	case NetworkNone:
+		return []string{"--network", "none"}, nil
+	case NetworkKiroAPIOnly:
+		if egress == nil {
+			return nil, fmt.Errorf("network policy %s needs an egress proxy", policy)
+		}
+		network, proxyURL, err := egress.Endpoint()
+		if err != nil {
+			return nil, fmt.Errorf("starting egress proxy: %w", err)
+		}
+		args := []string{"--network", network}
+		for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "https_proxy", "http_proxy"} {
+			args = append(args, "-e", name+"="+proxyURL)
+		}
+		return append(args, "-e", "NO_PROXY=localhost,127.0.0.1", "-e", "no_proxy=localhost,127.0.0.1"), nil
+	}
+	return nil, nil
+}
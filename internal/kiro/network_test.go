@@ -0,0 +1,84 @@
+package kiro
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeEgress struct {
+	err error
+}
+
+func (f fakeEgress) Endpoint() (string, string, error) {
+	return "budgie-egress", "http://172.18.0.1:3128", f.err
+}
+
+func TestParseNetworkPolicy(t *testing.T) {
+	for _, name := range []string{"", "full", "none", "kiro-api-only"} {
+		if _, err := ParseNetworkPolicy(name); err != nil {
+			t.Errorf("ParseNetworkPolicy(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := ParseNetworkPolicy("offline"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
+
+func TestDockerCommand_Network(t *testing.T) {
+	runner := NewDockerRunner("", "budgie-sandbox:latest")
+	runner.Egress = fakeEgress{}
+
+	tests := []struct {
+		policy  NetworkPolicy
+		want    []string
+		notWant string
+	}{
+		{NetworkFull, nil, "--network"},
+		{NetworkNone, []string{"--network none"}, "HTTPS_PROXY"},
+		{NetworkKiroAPIOnly, []string{"--network budgie-egress", "-e HTTPS_PROXY=http://172.18.0.1:3128"}, "--network none"},
+	}
+	for _, tt := range tests {
+		cmd, err := runner.Command(context.Background(), Invocation{Agent: "a", Prompt: "p", SessionDir: "/sessions/s1", Network: tt.policy})
+		if err != nil {
+			t.Fatalf("%s: Command failed: %v", tt.policy, err)
+		}
+		cmdStr := strings.Join(cmd.Args, " ")
+		for _, want := range tt.want {
+			if !strings.Contains(cmdStr, want) {
+				t.Errorf("%s: expected %q in command: %s", tt.policy, want, cmdStr)
+			}
+		}
+		if strings.Contains(cmdStr, tt.notWant) {
+			t.Errorf("%s: unexpected %q in command: %s", tt.policy, tt.notWant, cmdStr)
+		}
+		if strings.Index(cmdStr, "budgie-sandbox:latest") < strings.Index(cmdStr, "--network") {
+			t.Errorf("%s: network flags must come before the image", tt.policy)
+		}
+	}
+}
+
+func TestDockerCommand_EgressUnavailable(t *testing.T) {
+	runner := NewDockerRunner("", "budgie-sandbox:latest")
+	runner.Egress = fakeEgress{err: errors.New("network create failed")}
+
+	_, err := runner.Command(context.Background(), Invocation{Agent: "a", Prompt: "p", SessionDir: "/s", Network: NetworkKiroAPIOnly})
+	if ClassOf(err) != ErrorDockerUnavailable {
+		t.Errorf("Expected docker_unavailable, got %v", err)
+	}
+}
+
+func TestBwrapArgs_Network(t *testing.T) {
+	r := testBwrapRunner()
+	inv := Invocation{Agent: "a", Prompt: "p", SessionDir: "/tmp/s"}
+
+	if args := strings.Join(r.Args("/usr/bin/kiro-cli", inv), " "); !strings.Contains(args, "--share-net") {
+		t.Errorf("Expected network to be shared by default: %s", args)
+	}
+
+	inv.Network = NetworkNone
+	if args := strings.Join(r.Args("/usr/bin/kiro-cli", inv), " "); strings.Contains(args, "--share-net") {
+		t.Errorf("Expected no network for policy none: %s", args)
+	}
+}
@@ -0,0 +1,115 @@
+package kiro
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Preview is the process a run would start, as reported by dry runs.
+// Building it has no side effects: no container, network or proxy is
+// created.
+type Preview struct {
+	// Command is the full argv, binary first
+	Command []string
+	// Dir is the working directory of the process, if it sets one
+	Dir string
+	// Env holds NAME=value pairs set for the run, or NAME alone for values
+	// copied from budgie's environment, which are not shown
+	Env []string
+	// InheritEnv is set when the run also inherits budgie's environment
+	InheritEnv bool
+	// Mounts lists source:target:mode binds; mode overlay is a read-only
+	// source whose writes are kept in the session
+	Mounts []string
+	// PromptDelivery is how the prompt reaches kiro-cli
+	PromptDelivery PromptDelivery
+}
+
+// pendingEgress stands in for the egress gateway in previews, which must
+// not start it.
+type pendingEgress struct{}
+
+func (pendingEgress) Endpoint() (string, string, error) {
+	return "<egress-network>", "http://<egress-proxy>", nil
+}
+
+// previewEgress returns the egress provider to preview runs with.
+func previewEgress(egress EgressProvider) EgressProvider {
+	if egress == nil {
+		return nil
+	}
+	return pendingEgress{}
+}
+
+// dockerPreview describes a container run from its runtime arguments.
+func dockerPreview(argv []string) Preview {
+	p := Preview{Command: argv}
+	for i := 0; i+1 < len(argv); i++ {
+		switch argv[i] {
+		case "-v":
+			p.Mounts = append(p.Mounts, argv[i+1])
+			i++
+		case "-e":
+			p.Env = append(p.Env, argv[i+1])
+			i++
+		}
+	}
+	return p
+}
+
+// bwrapPreview describes a bubblewrap run from its argv. Values copied from
+// budgie's environment into --setenv are replaced with ${NAME} in the
+// command, as they may be secrets.
+func bwrapPreview(argv []string) Preview {
+	p := Preview{Command: slices.Clone(argv), InheritEnv: true}
+	for i := 1; i < len(argv) && argv[i] != "--"; i++ {
+		switch arg := argv[i]; arg {
+		case "--bind", "--bind-try", "--dev-bind", "--ro-bind", "--ro-bind-try":
+			if i+2 >= len(argv) {
+				return p
+			}
+			mode := "rw"
+			if strings.HasPrefix(arg, "--ro-") {
+				mode = "ro"
+			}
+			p.Mounts = append(p.Mounts, argv[i+1]+":"+argv[i+2]+":"+mode)
+			i += 2
+		case "--overlay-src":
+			if i+1 >= len(argv) {
+				return p
+			}
+			p.Mounts = append(p.Mounts, argv[i+1]+":"+argv[i+1]+":overlay")
+			i++
+		case "--overlay":
+			i += 3
+		case "--setenv":
+			if i+2 >= len(argv) {
+				return p
+			}
+			name := argv[i+1]
+			if slices.Contains(bwrapBaseEnv, name) {
+				p.Env = append(p.Env, name+"="+argv[i+2])
+			} else {
+				p.Env = append(p.Env, name)
+				p.Command[i+2] = "${" + name + "}"
+			}
+			i += 2
+		case "--clearenv":
+			p.InheritEnv = false
+		case "--chdir":
+			if i+1 < len(argv) {
+				p.Dir = argv[i+1]
+				i++
+			}
+		case "--tmpfs", "--dev", "--proc":
+			i++
+		}
+	}
+	return p
+}
+
+// isBwrap reports whether argv runs bubblewrap.
+func isBwrap(argv []string) bool {
+	return len(argv) > 0 && filepath.Base(argv[0]) == "bwrap"
+}
@@ -0,0 +1,94 @@
+package kiro
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDockerPreview(t *testing.T) {
+	runner := NewDockerRunner("", "budgie-sandbox:latest")
+	runner.KiroConfigDir = "/home/user/.kiro"
+	runner.AuthSourceDir = "/home/user/.local/share/kiro-cli"
+	// Previews must not start the egress gateway
+	runner.Egress = fakeEgress{err: errors.New("gateway started")}
+
+	p, err := runner.Preview(Invocation{
+		Agent:      "test-agent",
+		Prompt:     "test prompt",
+		SessionDir: "/sessions/abc",
+		WorkDir:    "/home/user/project",
+		Network:    NetworkKiroAPIOnly,
+		Sandbox:    SandboxProfile{Env: []string{"GITHUB_TOKEN"}},
+	})
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+
+	if p.Command[0] != "docker" || p.Command[len(p.Command)-1] != "test prompt" {
+		t.Errorf("Expected the full docker argv, got %v", p.Command)
+	}
+	if !slices.Contains(p.Mounts, "/home/user/project:/workspace:rw") || !slices.Contains(p.Mounts, "/sessions/abc:"+containerDataDir+":rw") {
+		t.Errorf("Expected the working and session directory mounts, got %v", p.Mounts)
+	}
+	if !slices.Contains(p.Env, "GITHUB_TOKEN") || !slices.Contains(p.Env, "HTTPS_PROXY=http://<egress-proxy>") {
+		t.Errorf("Expected the profile and proxy environment, got %v", p.Env)
+	}
+	if p.InheritEnv {
+		t.Error("Containers do not inherit budgie's environment")
+	}
+}
+
+func TestBwrapPreview(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "secret")
+	t.Setenv("PATH", "/usr/bin")
+
+	r := testBwrapRunner()
+	args := r.Args("/usr/bin/kiro-cli", Invocation{
+		Agent:      "test-agent",
+		Prompt:     "test prompt",
+		SessionDir: "/sessions/abc",
+		WorkDir:    "/home/user/project",
+		Sandbox:    SandboxProfile{ReadOnlyWorkDir: true, Env: []string{"GITHUB_TOKEN"}},
+	})
+	p := bwrapPreview(append([]string{"bwrap"}, args...))
+
+	if strings.Contains(strings.Join(p.Command, " "), "secret") {
+		t.Errorf("Expected environment values hidden, got %v", p.Command)
+	}
+	if !slices.Contains(p.Command, "${GITHUB_TOKEN}") || !slices.Contains(p.Env, "GITHUB_TOKEN") || !slices.Contains(p.Env, "PATH=/usr/bin") {
+		t.Errorf("Expected GITHUB_TOKEN by name and PATH by value, got %v", p.Env)
+	}
+	if p.InheritEnv {
+		t.Error("Expected --clearenv to stop environment inheritance")
+	}
+	if !slices.Contains(p.Mounts, "/home/user/project:/home/user/project:ro") || !slices.Contains(p.Mounts, "/sessions/abc:/sessions/abc:rw") {
+		t.Errorf("Expected the working and session directory mounts, got %v", p.Mounts)
+	}
+	if p.Dir != "/sessions/abc" {
+		t.Errorf("Expected --chdir as the directory, got %q", p.Dir)
+	}
+}
+
+// unpreviewableRunner fails every preview.
+type unpreviewableRunner struct {
+	DirectRunner
+}
+
+func (r *unpreviewableRunner) Preview(inv Invocation) (Preview, error) {
+	return Preview{Command: []string{"kiro-cli"}}, errors.New("bwrap not found")
+}
+
+func TestExecutorPreview_Error(t *testing.T) {
+	executor := NewExecutorWithRunner(&unpreviewableRunner{}, time.Minute, nil, false)
+
+	p, err := executor.Preview("developer", "fix it", t.TempDir(), "", "", "/src/app", "response-1.txt")
+	if err == nil {
+		t.Fatal("Expected the runner's error")
+	}
+	if p.PromptDelivery != "" || p.Command != nil {
+		t.Errorf("Expected an empty preview, got %+v", p)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package kiro
+
+import (
+	"os/exec"
+	"time"
+)
+
+// configureProcessGroup only kills the direct child on platforms without
+// process groups.
+func configureProcessGroup(cmd *exec.Cmd, grace time.Duration) (stop func()) {
+	cmd.WaitDelay = grace
+	return func() {}
+}
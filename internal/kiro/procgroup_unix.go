@@ -0,0 +1,57 @@
+//go:build unix
+
+package kiro
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configureProcessGroup runs cmd in its own process group so that
+// cancellation reaches everything kiro-cli spawned, not just kiro-cli. On
+// cancel the group gets SIGTERM, then SIGKILL once grace has passed. The
+// returned function must be called as soon as Wait returns: it stops a
+// pending SIGKILL, which could otherwise hit a new group reusing the pgid
+// later, and kills what is left of a cancelled group straight away instead.
+func configureProcessGroup(cmd *exec.Cmd, grace time.Duration) (stop func()) {
+	var (
+		mu     sync.Mutex
+		kill   *time.Timer
+		exited bool
+	)
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		err := syscall.Kill(-pgid, syscall.SIGTERM)
+		mu.Lock()
+		if !exited {
+			kill = time.AfterFunc(grace, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if !exited {
+					syscall.Kill(-pgid, syscall.SIGKILL)
+				}
+			})
+		}
+		mu.Unlock()
+		if err == syscall.ESRCH {
+			return nil
+		}
+		return err
+	}
+	// Children that inherited stdout/stderr can keep Wait blocked after
+	// kiro-cli exits; give up on the pipes shortly after the SIGKILL
+	cmd.WaitDelay = grace + time.Second
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		exited = true
+		if kill != nil && kill.Stop() {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+//go:build unix
+
+package kiro
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExecute_CancelKillsProcessTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	marker := filepath.Join(tmpDir, "marker")
+	script := filepath.Join(tmpDir, "spawning-kiro")
+
+	// A child that outlives a direct-child kill would create the marker
+	content := fmt.Sprintf("#!/bin/sh\n(sleep 1; touch %s) &\nsleep 10\n", marker)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	executor := NewExecutor(script, 1*time.Minute, nil, false, "", false)
+	executor.SetKillGrace(500 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	result := executor.Execute(ctx, "test-agent", "test", tmpDir, "", "")
+
+	if ClassOf(result.Error) != ErrorCancelled {
+		t.Errorf("Expected cancelled, got %s (%v)", ClassOf(result.Error), result.Error)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Cancelled run should return within the grace period, took %v", elapsed)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Child process survived cancellation")
+	}
+}
+
+func TestExecute_TimeoutKillsProcessIgnoringSIGTERM(t *testing.T) {
+	tmpDir := t.TempDir()
+	script := filepath.Join(tmpDir, "stubborn-kiro")
+
+	content := "#!/bin/sh\ntrap '' TERM\nsleep 10 &\nwait\nwait\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	executor := NewExecutor(script, 100*time.Millisecond, nil, false, "", false)
+	executor.SetKillGrace(300 * time.Millisecond)
+	executor.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	start := time.Now()
+	result := executor.Execute(context.Background(), "test-agent", "test", tmpDir, "", "")
+
+	if ClassOf(result.Error) != ErrorTimeout {
+		t.Errorf("Expected timeout, got %s (%v)", ClassOf(result.Error), result.Error)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Run ignoring SIGTERM should be killed after the grace period, took %v", elapsed)
+	}
+}
+
+func TestConfigureProcessGroup_StopKillsStragglers(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	// The shell exits on SIGTERM; its child ignores it and holds no pipes,
+	// so Wait returns well before the grace period is over
+	ctx, cancel := context.WithCancel(context.Background())
+	script := fmt.Sprintf("(trap '' TERM; sleep 1; touch %s) >/dev/null 2>&1 &\nsleep 10\n", marker)
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	stop := configureProcessGroup(cmd, time.Minute)
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	cmd.Run()
+	stop()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Expected Wait to return after SIGTERM, took %v", elapsed)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Child process survived its group being stopped")
+	}
+}
@@ -0,0 +1,76 @@
+package kiro
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SandboxProfile holds per-agent sandbox settings applied to every run of
+// the agent. The zero value keeps the runner's defaults.
+type SandboxProfile struct {
+	// Image replaces the sandbox image (container backend only)
+	Image string
+	// Mounts are extra read-only mounts
+	Mounts []Mount
+	// ReadOnlyWorkDir mounts the working directory read-only
+	ReadOnlyWorkDir bool
+	// Env names host environment variables passed into the sandbox. Nil
+	// keeps the backend's default environment.
+	Env []string
+}
+
+// Mount is a host path mounted read-only into the sandbox.
+type Mount struct {
+	Source string
+	// Target is the path inside the sandbox; empty means the host path
+	Target string
+}
+
+// ParseMount parses "source" or "source:target". A leading ~ in source is
+// the home directory, and both paths must be absolute.
+func ParseMount(s string) (Mount, error) {
+	source, target, _ := strings.Cut(s, ":")
+	if strings.HasPrefix(source, "~/") {
+		homeDir, _ := os.UserHomeDir()
+		source = filepath.Join(homeDir, source[2:])
+	}
+
+	if !filepath.IsAbs(source) {
+		return Mount{}, fmt.Errorf("mount source must be an absolute path: %s", s)
+	}
+	if target != "" && !filepath.IsAbs(target) {
+		return Mount{}, fmt.Errorf("mount target must be an absolute path: %s", s)
+	}
+	return Mount{Source: filepath.Clean(source), Target: target}, nil
+}
+
+func (m Mount) target() string {
+	if m.Target == "" {
+		return m.Source
+	}
+	return m.Target
+}
+
+// image returns the profile's image, or fallback if it has none.
+func (p SandboxProfile) image(fallback string) string {
+	if p.Image != "" {
+		return p.Image
+	}
+	return fallback
+}
+
+// workDirMode returns the mount mode of the working directory.
+func (p SandboxProfile) workDirMode() string {
+	if p.ReadOnlyWorkDir {
+		return "ro"
+	}
+	return "rw"
+}
+
+// SandboxOnly reports whether the profile has settings that only sandboxed
+// runs apply. A read-only working directory is enforced in direct mode too.
+func (p SandboxProfile) SandboxOnly() bool {
+	return p.Image != "" || len(p.Mounts) > 0 || p.Env != nil
+}
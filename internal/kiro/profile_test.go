@@ -0,0 +1,116 @@
+package kiro
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMount(t *testing.T) {
+	homeDir, _ := os.UserHomeDir()
+
+	tests := []struct {
+		in      string
+		want    Mount
+		wantErr bool
+	}{
+		{"/opt/docs", Mount{Source: "/opt/docs"}, false},
+		{"/opt/docs:/docs", Mount{Source: "/opt/docs", Target: "/docs"}, false},
+		{"~/notes", Mount{Source: filepath.Join(homeDir, "notes")}, false},
+		{"docs", Mount{}, true},
+		{"/opt/docs:docs", Mount{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMount(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMount(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMount(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDockerCommand_SandboxProfile(t *testing.T) {
+	runner := NewDockerRunner("", "budgie-sandbox:latest")
+	cmd, err := runner.Command(context.Background(), Invocation{
+		Agent:      "security",
+		Prompt:     "audit",
+		SessionDir: "/sessions/s1",
+		WorkDir:    "/project",
+		Sandbox: SandboxProfile{
+			Image:           "budgie-sandbox:audit",
+			Mounts:          []Mount{{Source: "/opt/rules"}, {Source: "/opt/docs", Target: "/docs"}},
+			ReadOnlyWorkDir: true,
+			Env:             []string{"GITHUB_TOKEN"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdStr := strings.Join(cmd.Args, " ")
+
+	for _, want := range []string{
+		"-v /project:/workspace:ro",
+		"-v /opt/rules:/opt/rules:ro",
+		"-v /opt/docs:/docs:ro",
+		"-e GITHUB_TOKEN",
+		"budgie-sandbox:audit kiro-cli",
+	} {
+		if !strings.Contains(cmdStr, want) {
+			t.Errorf("Expected %q in command: %s", want, cmdStr)
+		}
+	}
+	if strings.Contains(cmdStr, "budgie-sandbox:latest") {
+		t.Errorf("Expected the profile image to replace the default: %s", cmdStr)
+	}
+}
+
+func TestBwrapArgs_SandboxProfile(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "secret")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "hidden")
+
+	r := testBwrapRunner()
+	args := strings.Join(r.Args("/usr/bin/kiro-cli", Invocation{
+		Agent:      "security",
+		Prompt:     "audit",
+		SessionDir: "/tmp/s",
+		WorkDir:    "/home/user/project",
+		Sandbox: SandboxProfile{
+			Mounts:          []Mount{{Source: "/opt/docs", Target: "/docs"}},
+			ReadOnlyWorkDir: true,
+			Env:             []string{"GITHUB_TOKEN"},
+		},
+	}), " ")
+
+	for _, want := range []string{
+		"--ro-bind /home/user/project /home/user/project",
+		"--ro-bind /opt/docs /docs",
+		"--clearenv",
+		"--setenv GITHUB_TOKEN secret",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("Expected %q in args: %s", want, args)
+		}
+	}
+	if strings.Contains(args, "AWS_SECRET_ACCESS_KEY") {
+		t.Errorf("Expected variables outside the allowlist to be cleared: %s", args)
+	}
+}
+
+func TestExecutor_RunnerFor(t *testing.T) {
+	executor := NewExecutor("kiro-cli", time.Minute, nil, false, "", false)
+	sandboxed := NewDockerRunner("", "budgie-sandbox:latest")
+	executor.SetAgentOptions("security", AgentOptions{Runner: sandboxed})
+
+	if executor.RunnerFor("security") != Runner(sandboxed) {
+		t.Error("Expected the agent's runner override")
+	}
+	if executor.RunnerFor("developer") != executor.Runner() {
+		t.Error("Expected the default runner for agents without an override")
+	}
+}
@@ -0,0 +1,86 @@
+package kiro
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// PromptDelivery is how a prompt reaches kiro-cli.
+type PromptDelivery string
+
+const (
+	// PromptAuto is the default, which keeps prompts out of the process
+	// list: currently stdin, as every runner feeds it to kiro-cli
+	PromptAuto PromptDelivery = "auto"
+	// PromptArg passes prompts up to the size limit as kiro-cli's last
+	// argument, visible in the process list, and larger ones in a file
+	PromptArg PromptDelivery = "argv"
+	// PromptStdin writes the prompt to kiro-cli's standard input
+	PromptStdin PromptDelivery = "stdin"
+	// PromptFile writes the prompt to a file in the session directory and
+	// passes an argument telling the agent to read it
+	PromptFile PromptDelivery = "file"
+)
+
+// DefaultPromptArgMax is the largest prompt, in bytes, PromptArg passes as
+// an argument. Linux rejects single arguments over 128 KiB.
+const DefaultPromptArgMax = 64 * 1024
+
+// ParsePromptDelivery returns the prompt delivery with the given name; empty
+// means auto.
+func ParsePromptDelivery(s string) (PromptDelivery, error) {
+	switch d := PromptDelivery(s); d {
+	case "":
+		return PromptAuto, nil
+	case PromptAuto, PromptArg, PromptStdin, PromptFile:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown prompt delivery %q (expected auto, argv, stdin or file)", s)
+}
+
+// resolve returns how a prompt is delivered: auto becomes stdin, and argv
+// becomes file for prompts over argMax bytes.
+func (d PromptDelivery) resolve(prompt string, argMax int) PromptDelivery {
+	switch d {
+	case PromptAuto, "":
+		return PromptStdin
+	case PromptArg:
+		if len(prompt) > argMax {
+			return PromptFile
+		}
+	}
+	return d
+}
+
+// promptFileName returns the session file a call's prompt is written to for
+// file delivery. Retries of the call read the same file.
+func promptFileName(callID string) string {
+	return fmt.Sprintf("prompt-%s.txt", callID)
+}
+
+// promptFileArg is the argument that points the agent at its prompt file.
+func promptFileArg(path string) string {
+	return fmt.Sprintf("Read the file %s and follow the instructions in it as if they were this message.", path)
+}
+
+// writePromptFile writes the prompt of a file-delivered call to the session
+// directory, unless an earlier attempt of the call already did. It is kept
+// with the session, as the conversation refers to it, and removed with it.
+func writePromptFile(sessionDir, callID, prompt string) error {
+	f, err := os.OpenFile(filepath.Join(sessionDir, promptFileName(callID)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(prompt); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,123 @@
+package kiro
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"budgie/internal/container"
+)
+
+func TestParsePromptDelivery(t *testing.T) {
+	for _, name := range []string{"", "auto", "argv", "stdin", "file"} {
+		if _, err := ParsePromptDelivery(name); err != nil {
+			t.Errorf("ParsePromptDelivery(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := ParsePromptDelivery("pipe"); err == nil {
+		t.Error("Expected an unknown delivery to fail")
+	}
+}
+
+func TestPromptDelivery_Resolve(t *testing.T) {
+	tests := []struct {
+		delivery PromptDelivery
+		prompt   string
+		want     PromptDelivery
+	}{
+		{PromptAuto, "short", PromptStdin},
+		{PromptAuto, strings.Repeat("x", 11), PromptStdin},
+		{PromptArg, "short", PromptArg},
+		{PromptArg, strings.Repeat("x", 11), PromptFile},
+		{PromptStdin, "short", PromptStdin},
+		{PromptFile, "short", PromptFile},
+	}
+	for _, tt := range tests {
+		if got := tt.delivery.resolve(tt.prompt, 10); got != tt.want {
+			t.Errorf("%s.resolve(%d bytes) = %s, want %s", tt.delivery, len(tt.prompt), got, tt.want)
+		}
+	}
+}
+
+func TestKiroArgs_PromptDelivery(t *testing.T) {
+	inv := Invocation{Agent: "developer", Prompt: "secret plan"}
+
+	if args := kiroArgs(inv); args[len(args)-1] != "secret plan" {
+		t.Errorf("Expected the prompt as the last argument, got %v", args)
+	}
+
+	inv.Delivery = PromptStdin
+	if args := kiroArgs(inv); slices.Contains(args, "secret plan") {
+		t.Errorf("Expected no prompt argument, got %v", args)
+	}
+
+	inv.Delivery = PromptFile
+	inv.PromptPath = "/session/prompt-1.txt"
+	args := kiroArgs(inv)
+	if slices.Contains(args, "secret plan") || !strings.Contains(args[len(args)-1], "/session/prompt-1.txt") {
+		t.Errorf("Expected an argument pointing at the prompt file, got %v", args)
+	}
+}
+
+func TestDockerRunner_StdinDelivery(t *testing.T) {
+	runner := NewDockerRunner(container.Docker, "budgie-sandbox:latest")
+	inv := Invocation{Agent: "developer", Prompt: "secret plan", SessionDir: "/sessions/abc", Delivery: PromptStdin}
+
+	args, err := runner.runArgs(inv, nil)
+	if err != nil {
+		t.Fatalf("runArgs failed: %v", err)
+	}
+	if !slices.Contains(args, "-i") || slices.Contains(args, "secret plan") {
+		t.Errorf("Expected an interactive run without the prompt argument, got %v", args)
+	}
+}
+
+func TestExecute_PromptDelivery(t *testing.T) {
+	for _, delivery := range []PromptDelivery{PromptStdin, PromptFile} {
+		t.Run(string(delivery), func(t *testing.T) {
+			// Echo the prompt back from stdin or the prompt file
+			runner := &scriptRunner{script: `cat`}
+			if delivery == PromptFile {
+				runner.script = `cat prompt-*.txt`
+			}
+			executor := NewExecutorWithRunner(runner, time.Minute, nil, false)
+			executor.SetPromptDelivery(delivery, DefaultPromptArgMax)
+
+			sessionDir := t.TempDir()
+			result := executor.Execute(context.Background(), "developer", "secret plan", sessionDir, "", "")
+			if result.Error != nil || result.Output != "secret plan" {
+				t.Errorf("Expected the prompt to reach the run, got %q (%v)", result.Output, result.Error)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(sessionDir, "prompt-*.txt"))
+			if (delivery == PromptFile) != (len(files) == 1) {
+				t.Errorf("Unexpected prompt files %v", files)
+			}
+			if delivery == PromptFile {
+				if info, err := os.Stat(files[0]); err != nil || info.Mode().Perm() != 0600 {
+					t.Errorf("Expected a private prompt file, got %v (%v)", info, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExecute_PromptFileOncePerCall(t *testing.T) {
+	runner := &scriptRunner{script: `cat prompt-*.txt; exit 1`}
+	executor := NewExecutorWithRunner(runner, time.Minute, nil, false)
+	executor.SetPromptDelivery(PromptFile, DefaultPromptArgMax)
+	executor.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 1, RetryOn: []ErrorClass{ErrorExit}})
+
+	sessionDir := t.TempDir()
+	result := executor.Execute(context.Background(), "developer", "secret plan", sessionDir, "", "")
+	if !result.Retried {
+		t.Fatalf("Expected the run to be retried, got %v", result.Error)
+	}
+	if files, _ := filepath.Glob(filepath.Join(sessionDir, "prompt-*.txt")); len(files) != 1 {
+		t.Errorf("Expected one prompt file for all attempts, got %v", files)
+	}
+}
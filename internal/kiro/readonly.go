@@ -0,0 +1,83 @@
+package kiro
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// readOnlyViolationPatterns are the errors a write to a read-only mount or
+// a sandbox-exec deny rule produces, matched case-insensitively.
+var readOnlyViolationPatterns = []string{
+	"read-only file system",
+	"operation not permitted",
+}
+
+// seatbeltReadOnlyProfile is the macOS sandbox-exec profile that allows
+// everything except writes under WORKDIR.
+const seatbeltReadOnlyProfile = `(version 1)(allow default)(deny file-write* (subpath (param "WORKDIR")))`
+
+// ReadOnlySupported reports whether direct mode can enforce a read-only
+// working directory on this host: it needs bubblewrap on Linux and
+// sandbox-exec on macOS.
+func ReadOnlySupported() error {
+	_, err := readOnlyArgs("/", nil)
+	return err
+}
+
+// readOnlyArgs returns a command line that runs args on the host with
+// workDir mounted read-only and everything else unchanged.
+func readOnlyArgs(workDir string, args []string) ([]string, error) {
+	// Writes through symlinked paths must hit the rule too
+	if resolved, err := filepath.EvalSymlinks(workDir); err == nil {
+		workDir = resolved
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		bwrap, err := exec.LookPath("bwrap")
+		if err != nil {
+			return nil, fmt.Errorf("a read-only working directory needs bubblewrap (bwrap) in direct mode: %w", err)
+		}
+		return bwrapReadOnlyArgs(bwrap, workDir, args), nil
+	case "darwin":
+		sandboxExec, err := exec.LookPath("sandbox-exec")
+		if err != nil {
+			return nil, fmt.Errorf("a read-only working directory needs sandbox-exec in direct mode: %w", err)
+		}
+		return seatbeltReadOnlyArgs(sandboxExec, workDir, args), nil
+	}
+	return nil, fmt.Errorf("a read-only working directory is not supported in direct mode on %s", runtime.GOOS)
+}
+
+func bwrapReadOnlyArgs(bwrap, workDir string, args []string) []string {
+	return append([]string{bwrap, "--die-with-parent", "--dev-bind", "/", "/", "--ro-bind", workDir, workDir, "--"}, args...)
+}
+
+func seatbeltReadOnlyArgs(sandboxExec, workDir string, args []string) []string {
+	return append([]string{sandboxExec, "-D", "WORKDIR=" + workDir, "-p", seatbeltReadOnlyProfile}, args...)
+}
+
+// readOnlyViolation returns an error if a run with a read-only working
+// directory reported a failed write on stderr. Stdout is not checked: it
+// carries the agent's own text, which may quote such errors from files it
+// read.
+func readOnlyViolation(inv Invocation, stderr string) *Error {
+	if !inv.Sandbox.ReadOnlyWorkDir || inv.WorkDir == "" {
+		return nil
+	}
+
+	for _, line := range strings.Split(stderr, "\n") {
+		if containsAny(strings.ToLower(line), readOnlyViolationPatterns) {
+			return &Error{
+				Class:    ErrorReadOnly,
+				ExitCode: -1,
+				Stderr:   strings.TrimSpace(stderr),
+				Err:      fmt.Errorf("agent tried to modify the read-only working directory %s: %s", inv.WorkDir, strings.TrimSpace(line)),
+			}
+		}
+	}
+	return nil
+}
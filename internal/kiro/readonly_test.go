@@ -0,0 +1,114 @@
+package kiro
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBwrapReadOnlyArgs(t *testing.T) {
+	args := strings.Join(bwrapReadOnlyArgs("/usr/bin/bwrap", "/project", []string{"kiro-cli", "chat"}), " ")
+	want := "/usr/bin/bwrap --die-with-parent --dev-bind / / --ro-bind /project /project -- kiro-cli chat"
+	if args != want {
+		t.Errorf("Expected %q, got %q", want, args)
+	}
+}
+
+func TestSeatbeltReadOnlyArgs(t *testing.T) {
+	args := seatbeltReadOnlyArgs("/usr/bin/sandbox-exec", "/project", []string{"kiro-cli", "chat"})
+	if args[0] != "/usr/bin/sandbox-exec" || args[2] != "WORKDIR=/project" || args[4] != seatbeltReadOnlyProfile {
+		t.Errorf("Unexpected sandbox-exec args: %v", args)
+	}
+	if strings.Join(args[5:], " ") != "kiro-cli chat" {
+		t.Errorf("Expected kiro-cli to follow the profile, got %v", args)
+	}
+}
+
+func TestReadOnlyViolation(t *testing.T) {
+	inv := Invocation{WorkDir: "/project", Sandbox: SandboxProfile{ReadOnlyWorkDir: true}}
+
+	err := readOnlyViolation(inv, "Writing src/main.go\nerror: Read-only file system (os error 30)\n")
+	if err == nil || err.Class != ErrorReadOnly {
+		t.Fatalf("Expected read_only error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "/project") || !strings.Contains(err.Error(), "os error 30") {
+		t.Errorf("Expected the directory and failing line in the message, got %q", err.Error())
+	}
+
+	if err := readOnlyViolation(inv, "all good"); err != nil {
+		t.Errorf("Expected no error for clean output, got %v", err)
+	}
+
+	inv.Sandbox.ReadOnlyWorkDir = false
+	if err := readOnlyViolation(inv, "Read-only file system"); err != nil {
+		t.Errorf("Expected no error for a read-write agent, got %v", err)
+	}
+}
+
+func TestDirectRunner_ReadOnlyWithoutSupport(t *testing.T) {
+	if ReadOnlySupported() == nil {
+		t.Skip("read-only enforcement is available on this host")
+	}
+
+	_, err := NewDirectRunner("kiro-cli").Command(context.Background(), Invocation{
+		Agent:      "codebase-locator",
+		Prompt:     "find",
+		SessionDir: t.TempDir(),
+		WorkDir:    t.TempDir(),
+		Sandbox:    SandboxProfile{ReadOnlyWorkDir: true},
+	})
+	if err == nil {
+		t.Error("Expected an error rather than an unprotected run")
+	}
+}
+
+// scriptRunner runs a shell script instead of kiro-cli, in the session
+// directory.
+type scriptRunner struct {
+	DirectRunner
+	script string
+}
+
+func (r *scriptRunner) Command(ctx context.Context, inv Invocation) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", r.script)
+	cmd.Dir = inv.SessionDir
+	return cmd, nil
+}
+
+func TestExecute_ReadOnlyViolation(t *testing.T) {
+	runner := &scriptRunner{script: `echo "done"; echo "fs_write failed: Read-only file system" >&2; exit 1`}
+	executor := NewExecutorWithRunner(runner, time.Minute, nil, false)
+	executor.SetAgentOptions("code-reviewer", AgentOptions{Sandbox: SandboxProfile{ReadOnlyWorkDir: true}})
+
+	result := executor.ExecuteWithWorkDir(context.Background(), "code-reviewer", "review", t.TempDir(), "", "", "/project", "")
+	if ClassOf(result.Error) != ErrorReadOnly {
+		t.Fatalf("Expected read_only error, got %v", result.Error)
+	}
+	if result.Retried {
+		t.Error("Read-only violations must not be retried")
+	}
+}
+
+func TestExecute_ReadOnlyViolationOnSuccess(t *testing.T) {
+	tests := map[string]string{
+		// kiro-cli carried on after the failed write
+		"stderr": `echo "reviewed"; echo "fs_write failed: Read-only file system" >&2`,
+		// The agent quoted the error from a file it read
+		"stdout": `echo "the log says: Read-only file system"; exit 1`,
+	}
+	for name, script := range tests {
+		executor := NewExecutorWithRunner(&scriptRunner{script: script}, time.Minute, nil, false)
+		executor.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		executor.SetAgentOptions("code-reviewer", AgentOptions{Sandbox: SandboxProfile{ReadOnlyWorkDir: true}})
+
+		result := executor.ExecuteWithWorkDir(context.Background(), "code-reviewer", "review", t.TempDir(), "", "", "/project", "")
+		if ClassOf(result.Error) == ErrorReadOnly {
+			t.Errorf("%s: expected no read_only error, got %v", name, result.Error)
+		}
+		if name == "stderr" && (result.Error != nil || result.Output != "reviewed") {
+			t.Errorf("Expected the successful run to keep its result, got %q (%v)", result.Output, result.Error)
+		}
+	}
+}
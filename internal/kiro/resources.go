@@ -0,0 +1,81 @@
+package kiro
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Resources limits what a sandboxed run may use. Zero values mean
+// unlimited. Limits are enforced by container runners only.
+type Resources struct {
+	// CPUs is the number of CPUs, e.g. 1.5
+	CPUs float64
+	// Memory is the memory limit in Docker's format, e.g. "4g"
+	Memory string
+	// PIDs caps the number of processes
+	PIDs int
+	// TmpfsSize is the size of the tmpfs mounted at /tmp, e.g. "1g"
+	TmpfsSize string
+}
+
+var sizePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[bkmgBKMG]?$`)
+
+// Validate checks that the limits are in a form the container runtime
+// accepts.
+func (r Resources) Validate() error {
+	if r.CPUs < 0 {
+		return fmt.Errorf("cpus must not be negative")
+	}
+	if r.PIDs < 0 {
+		return fmt.Errorf("pids must not be negative")
+	}
+	if r.Memory != "" && !sizePattern.MatchString(r.Memory) {
+		return fmt.Errorf("invalid memory size %q", r.Memory)
+	}
+	if r.TmpfsSize != "" && !sizePattern.MatchString(r.TmpfsSize) {
+		return fmt.Errorf("invalid tmpfs size %q", r.TmpfsSize)
+	}
+	return nil
+}
+
+// IsZero reports whether no limit is set.
+func (r Resources) IsZero() bool {
+	return r == Resources{}
+}
+
+// Merge returns r with the limits set in override replacing its own.
+func (r Resources) Merge(override Resources) Resources {
+	if override.CPUs > 0 {
+		r.CPUs = override.CPUs
+	}
+	if override.Memory != "" {
+		r.Memory = override.Memory
+	}
+	if override.PIDs > 0 {
+		r.PIDs = override.PIDs
+	}
+	if override.TmpfsSize != "" {
+		r.TmpfsSize = override.TmpfsSize
+	}
+	return r
+}
+
+// runArgs returns the container `run` flags enforcing the limits.
+func (r Resources) runArgs() []string {
+	var args []string
+	if r.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(r.CPUs, 'f', -1, 64))
+	}
+	if r.Memory != "" {
+		// Equal swap limit so the memory limit cannot be exceeded via swap
+		args = append(args, "--memory", r.Memory, "--memory-swap", r.Memory)
+	}
+	if r.PIDs > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(r.PIDs))
+	}
+	if r.TmpfsSize != "" {
+		args = append(args, "--tmpfs", "/tmp:rw,size="+r.TmpfsSize)
+	}
+	return args
+}
@@ -0,0 +1,69 @@
+package kiro
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestResourcesValidate(t *testing.T) {
+	valid := []Resources{
+		{},
+		{CPUs: 1.5, Memory: "4g", PIDs: 512, TmpfsSize: "512m"},
+		{Memory: "1073741824"},
+	}
+	for _, r := range valid {
+		if err := r.Validate(); err != nil {
+			t.Errorf("Validate(%+v) failed: %v", r, err)
+		}
+	}
+
+	invalid := []Resources{
+		{CPUs: -1},
+		{PIDs: -1},
+		{Memory: "4 gigabytes"},
+		{TmpfsSize: "lots"},
+	}
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Errorf("Expected Validate(%+v) to fail", r)
+		}
+	}
+}
+
+func TestResourcesMerge(t *testing.T) {
+	defaults := Resources{CPUs: 2, Memory: "4g", PIDs: 512}
+	merged := defaults.Merge(Resources{Memory: "8g", TmpfsSize: "1g"})
+
+	expected := Resources{CPUs: 2, Memory: "8g", PIDs: 512, TmpfsSize: "1g"}
+	if merged != expected {
+		t.Errorf("Expected %+v, got %+v", expected, merged)
+	}
+}
+
+func TestDockerCommand_Resources(t *testing.T) {
+	runner := NewDockerRunner("", "budgie-sandbox:latest")
+	cmd, _ := runner.Command(context.Background(), Invocation{
+		Agent:      "test-agent",
+		Prompt:     "test",
+		SessionDir: "/sessions/s1",
+		Resources:  Resources{CPUs: 1.5, Memory: "2g", PIDs: 256, TmpfsSize: "512m"},
+	})
+	cmdStr := strings.Join(cmd.Args, " ")
+
+	for _, want := range []string{
+		"--cpus 1.5",
+		"--memory 2g --memory-swap 2g",
+		"--pids-limit 256",
+		"--tmpfs /tmp:rw,size=512m",
+	} {
+		if !strings.Contains(cmdStr, want) {
+			t.Errorf("Expected %q in command: %s", want, cmdStr)
+		}
+	}
+
+	// Limits must be flags of run, not arguments to kiro-cli
+	if strings.Index(cmdStr, "--cpus") > strings.Index(cmdStr, "budgie-sandbox:latest") {
+		t.Error("Resource flags must come before the image")
+	}
+}
@@ -0,0 +1,99 @@
+package kiro
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed runs are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first; 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after each retry
+	Multiplier float64
+	// Jitter randomizes each backoff by up to this fraction (0-1)
+	Jitter float64
+	// MaxElapsed stops retrying once this much time has passed since the first attempt (0 = no limit)
+	MaxElapsed time.Duration
+	// RetryOn lists the error classes that are retried
+	RetryOn []ErrorClass
+}
+
+// DefaultRetryPolicy retries timeouts, crashes, non-zero exits and rate
+// limiting once after about two seconds.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: 2 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryOn:        []ErrorClass{ErrorTimeout, ErrorCrash, ErrorExit, ErrorRateLimited},
+	}
+}
+
+// Validate checks that the policy's settings are in range.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1, got %g", p.Jitter)
+	}
+	return nil
+}
+
+// ShouldRetry reports whether errors of the given class are retried.
+func (p RetryPolicy) ShouldRetry(class ErrorClass) bool {
+	for _, c := range p.RetryOn {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the wait before the given retry (1 for the first retry).
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < retry; i++ {
+		backoff *= multiplier
+		if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+
+	if p.Jitter > 0 {
+		backoff *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if backoff < 0 {
+		backoff = 0
+	}
+	return time.Duration(backoff)
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
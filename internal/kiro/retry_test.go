@@ -0,0 +1,140 @@
+package kiro
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}
+
+	tests := []struct {
+		retry    int
+		expected time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		result := policy.Backoff(tt.retry)
+		if result != tt.expected {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.retry, result, tt.expected)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 1 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		backoff := policy.Backoff(1)
+		if backoff < 500*time.Millisecond || backoff > 1500*time.Millisecond {
+			t.Fatalf("Backoff with 50%% jitter out of range: %v", backoff)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffNeverNegative(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, Jitter: 5}
+
+	for i := 0; i < 100; i++ {
+		if backoff := policy.Backoff(1); backoff < 0 {
+			t.Fatalf("Expected a backoff of at least 0, got %v", backoff)
+		}
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	if err := DefaultRetryPolicy().Validate(); err != nil {
+		t.Errorf("Expected the default policy to be valid: %v", err)
+	}
+	for _, policy := range []RetryPolicy{{MaxAttempts: 0}, {MaxAttempts: 1, Jitter: -0.1}, {MaxAttempts: 1, Jitter: 1.5}} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", policy)
+		}
+	}
+}
+
+func TestParseErrorClasses(t *testing.T) {
+	classes, err := ParseErrorClasses("timeout, crash")
+	if err != nil {
+		t.Fatalf("ParseErrorClasses failed: %v", err)
+	}
+	if len(classes) != 2 || classes[0] != ErrorTimeout || classes[1] != ErrorCrash {
+		t.Errorf("Unexpected classes: %v", classes)
+	}
+
+	if _, err := ParseErrorClasses("timeout,bogus"); err == nil {
+		t.Error("Expected error for unknown class")
+	}
+}
+
+func TestExecute_RetriesPerPolicy(t *testing.T) {
+	executor := NewExecutor("false", 1*time.Minute, nil, false, "", false)
+	executor.SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     1,
+		RetryOn:        []ErrorClass{ErrorExit},
+	})
+
+	result := executor.Execute(context.Background(), "test-agent", "test", t.TempDir(), "", "")
+
+	if result.Error == nil {
+		t.Fatal("Expected error from 'false' binary")
+	}
+	if !result.Retried {
+		t.Error("Expected run to be retried")
+	}
+}
+
+func TestExecute_AgentRetryOverride(t *testing.T) {
+	executor := NewExecutor("false", 1*time.Minute, nil, false, "", false)
+	executor.SetAgentOptions("test-agent", AgentOptions{
+		Retry: &RetryPolicy{MaxAttempts: 1},
+	})
+
+	result := executor.Execute(context.Background(), "test-agent", "test", t.TempDir(), "", "")
+
+	if result.Retried {
+		t.Error("Agent policy with MaxAttempts 1 should not retry")
+	}
+}
+
+func TestExecute_CancelAbortsBackoff(t *testing.T) {
+	executor := NewExecutor("false", 1*time.Minute, nil, false, "", false)
+	executor.SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 1 * time.Minute,
+		RetryOn:        []ErrorClass{ErrorExit},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	result := executor.Execute(ctx, "test-agent", "test", t.TempDir(), "", "")
+
+	if result.Error == nil {
+		t.Fatal("Expected error")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Cancellation should abort the backoff, took %v", elapsed)
+	}
+}
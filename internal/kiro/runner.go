@@ -0,0 +1,142 @@
+package kiro
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Invocation describes a single kiro-cli run.
+type Invocation struct {
+	// RunID uniquely identifies the run, e.g. for naming containers
+	RunID        string
+	Agent        string
+	Prompt       string
+	SessionDir   string
+	SessionID    string
+	Model        string
+	WorkDir      string
+	ResponseFile string
+	// Delivery is how Prompt reaches kiro-cli: argv, stdin or file
+	Delivery PromptDelivery
+	// PromptPath is the prompt file as the agent sees it, for file delivery
+	PromptPath string
+	// Resources limits the run; only container runners enforce it
+	Resources Resources
+	// Network is the run's network policy; empty means full access
+	Network NetworkPolicy
+	// Sandbox holds the agent's sandbox profile
+	Sandbox SandboxProfile
+}
+
+// Runner is an execution backend for kiro-cli. It owns command
+// construction, response-file retrieval and cleanup, so the executor and
+// tool handlers do not need to know where or how kiro-cli runs.
+type Runner interface {
+	// Command builds the process that runs kiro-cli for inv
+	Command(ctx context.Context, inv Invocation) (*exec.Cmd, error)
+	// Preview describes the process Command would build for inv, without
+	// creating anything it needs
+	Preview(inv Invocation) (Preview, error)
+	// Stop stops anything a cancelled or timed-out run has outside its
+	// process group, such as a container. It is called while the run is
+	// being cancelled.
+	Stop(inv Invocation, grace time.Duration)
+	// Cleanup is called after every run; aborted is set when the run timed
+	// out or was cancelled
+	Cleanup(inv Invocation, aborted bool)
+	// WorkDirPath returns the working directory as the agent sees it
+	WorkDirPath(workDir string) string
+	// ResponsePath returns the response file path as the agent sees it
+	ResponsePath(sessionDir, responseFile string) string
+	// ReadResponse reads a response file written by the agent
+	ReadResponse(sessionDir, responseFile string) (string, error)
+	// AppendSessionFile appends content to a file in the session workspace
+	AppendSessionFile(sessionDir, name, content string) error
+	// Containerized reports whether kiro-cli runs inside a container runtime
+	Containerized() bool
+}
+
+// oomReporter is implemented by runners that can tell whether the OOM
+// killer ended a run, which its exit code alone does not show. OOMKilled is
+// called before Cleanup.
+type oomReporter interface {
+	OOMKilled(inv Invocation) bool
+}
+
+// oomKillCount returns the oom_kill counter from the content of a cgroup's
+// memory.events (v2) or memory.oom_control (v1).
+func oomKillCount(content string) (int, bool) {
+	for _, line := range strings.Split(content, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "oom_kill "); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// kiroArgs returns the kiro-cli chat arguments for inv, without the binary.
+func kiroArgs(inv Invocation) []string {
+	args := []string{"chat", "--agent", inv.Agent, "--no-interactive"}
+
+	if inv.Model != "" {
+		args = append(args, "--model", inv.Model)
+	}
+
+	if inv.SessionID != "" {
+		args = append(args, "--resume")
+	}
+
+	switch inv.Delivery {
+	case PromptStdin:
+		return args
+	case PromptFile:
+		return append(args, promptFileArg(inv.PromptPath))
+	}
+	return append(args, inv.Prompt)
+}
+
+// stdinArgs returns the runtime flag that keeps stdin open for runs whose
+// prompt is delivered on it.
+func stdinArgs(inv Invocation) []string {
+	if inv.Delivery == PromptStdin {
+		return []string{"-i"}
+	}
+	return nil
+}
+
+// defaultAuthSourceDir returns the host directory holding kiro-cli's auth
+// database.
+func defaultAuthSourceDir() string {
+	homeDir, _ := os.UserHomeDir()
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(homeDir, "Library", "Application Support", "kiro-cli")
+	}
+	return filepath.Join(homeDir, ".local", "share", "kiro-cli")
+}
+
+// readResponseFile reads a response file from a host session directory.
+func readResponseFile(sessionDir, responseFile string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(sessionDir, responseFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func appendFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(content)
+	return err
+}
@@ -0,0 +1,95 @@
+package kiro
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"budgie/internal/sessions"
+)
+
+// WarmDockerRunner runs each turn with `exec` in a long-lived container per
+// session instead of a fresh `run --rm`, skipping container start-up and the
+// entrypoint's auth sync on every turn after the first. The session manager
+// owns the containers and removes them on Cleanup or when idle.
+type WarmDockerRunner struct {
+	*DockerRunner
+	Sessions *sessions.Manager
+}
+
+func NewWarmDockerRunner(docker *DockerRunner, sessionMgr *sessions.Manager) *WarmDockerRunner {
+	return &WarmDockerRunner{DockerRunner: docker, Sessions: sessionMgr}
+}
+
+func (r *WarmDockerRunner) Command(ctx context.Context, inv Invocation) (*exec.Cmd, error) {
+	runArgs, err := r.containerArgs(inv, r.Egress)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := r.Sessions.EnsureContainer(r.Sessions.GetSessionID(inv.SessionDir), inv.WorkDir, runArgs)
+	if err != nil {
+		return nil, &Error{Class: ErrorDockerUnavailable, ExitCode: -1, Err: err}
+	}
+
+	return r.Runtime.CommandContext(ctx, r.execArgs(name, inv)...), nil
+}
+
+// execArgs returns the runtime arguments that run kiro-cli for inv in the
+// session container.
+func (r *WarmDockerRunner) execArgs(name string, inv Invocation) []string {
+	args := append([]string{"exec"}, stdinArgs(inv)...)
+	return append(append(args, name, "kiro-cli"), kiroArgs(inv)...)
+}
+
+// Preview describes the exec for inv, with the mounts and environment of
+// the session container it runs in. Neither is created.
+func (r *WarmDockerRunner) Preview(inv Invocation) (Preview, error) {
+	runArgs, err := r.containerArgs(inv, previewEgress(r.Egress))
+	if err != nil {
+		return Preview{}, err
+	}
+
+	name := sessions.ContainerName(r.Sessions.GetSessionID(inv.SessionDir))
+	p := dockerPreview(runArgs)
+	p.Command = r.Runtime.Command(r.execArgs(name, inv)...).Args
+	return p, nil
+}
+
+// containerArgs returns the run arguments, after the runtime's run
+// command, of the session container for inv.
+func (r *WarmDockerRunner) containerArgs(inv Invocation, egress EgressProvider) ([]string, error) {
+	netArgs, err := networkArgs(inv.Network, egress)
+	if err != nil {
+		return nil, &Error{Class: ErrorDockerUnavailable, ExitCode: -1, Err: err}
+	}
+
+	runArgs := append(r.Runtime.RunArgs(), inv.Resources.runArgs()...)
+	runArgs = append(runArgs, netArgs...)
+	runArgs = append(runArgs, r.mountArgs(inv)...)
+	return append(runArgs, inv.Sandbox.image(r.Image), "sleep", "infinity"), nil
+}
+
+// Stop stops the session container; killing the exec client leaves kiro-cli
+// running inside it.
+func (r *WarmDockerRunner) Stop(inv Invocation, grace time.Duration) {
+	r.Runtime.Command("stop", "--time", strconv.Itoa(int(grace.Seconds())), sessions.ContainerName(r.Sessions.GetSessionID(inv.SessionDir))).Run()
+}
+
+// OOMKilled reports whether the OOM killer has struck in the session
+// container since the last check. The container's own state only covers its
+// main process, so this reads the oom_kill counter of its cgroup.
+func (r *WarmDockerRunner) OOMKilled(inv Invocation) bool {
+	sessionID := r.Sessions.GetSessionID(inv.SessionDir)
+	// A missing file makes cat fail, but the other one is still printed
+	output, _ := r.Runtime.Command("exec", sessions.ContainerName(sessionID), "cat", "/sys/fs/cgroup/memory.events", "/sys/fs/cgroup/memory/memory.oom_control").Output()
+	count, ok := oomKillCount(string(output))
+	return ok && r.Sessions.NoteOOMKills(sessionID, count)
+}
+
+// Cleanup releases the session container, discarding it after an aborted
+// turn. The session directory survives, so the next turn resumes normally.
+func (r *WarmDockerRunner) Cleanup(inv Invocation, aborted bool) {
+	r.Sessions.ReleaseContainer(r.Sessions.GetSessionID(inv.SessionDir), aborted)
+}
@@ -0,0 +1,242 @@
+// Package lease serializes agent calls that would edit the same working
+// directory at once.
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode is how a call holds a directory.
+type Mode string
+
+const (
+	// Shared leases are taken by readers; any number can hold a directory
+	Shared Mode = "shared"
+	// Exclusive leases are taken by writers; no one else can hold the
+	// directory meanwhile
+	Exclusive Mode = "exclusive"
+)
+
+// ErrBusy is returned when a lease cannot be had within the wait.
+var ErrBusy = errors.New("directory is in use")
+
+// Holder identifies the call behind a lease.
+type Holder struct {
+	Agent     string
+	SessionID string
+}
+
+// Lease is a held or requested lease, as reported by List.
+type Lease struct {
+	Dir    string
+	Mode   Mode
+	Holder Holder
+	// Since is when the lease was granted or, while waiting, requested
+	Since   time.Time
+	Waiting bool
+}
+
+type request struct {
+	lease Lease
+	seq   uint64
+	ready chan struct{}
+}
+
+// Manager grants leases on directories. A directory conflicts with its
+// subdirectories, so a writer in a repository excludes readers in any of
+// its packages. Conflicting requests are served in arrival order, so a
+// waiting writer is not starved by a stream of readers.
+type Manager struct {
+	wait time.Duration
+
+	mu    sync.Mutex
+	held  []*request
+	queue []*request
+	seq   uint64
+}
+
+// NewManager creates a lease manager. Requests wait up to wait for a
+// conflicting lease to be released; 0 fails them at once.
+func NewManager(wait time.Duration) *Manager {
+	return &Manager{wait: wait}
+}
+
+// Acquire takes a lease on dir, resolved to an absolute path without
+// symlinks, waiting for conflicting leases until the manager's wait runs
+// out or ctx is done. The returned release function must be called exactly
+// once when the call ends.
+func (m *Manager) Acquire(ctx context.Context, dir string, mode Mode, holder Holder) (func(), error) {
+	dir = Resolve(dir)
+
+	m.mu.Lock()
+	m.seq++
+	r := &request{
+		lease: Lease{Dir: dir, Mode: mode, Holder: holder, Since: time.Now(), Waiting: true},
+		seq:   m.seq,
+		ready: make(chan struct{}),
+	}
+	m.queue = append(m.queue, r)
+	m.dispatch()
+	waiting := r.lease.Waiting
+	if waiting && m.wait <= 0 {
+		m.remove(r)
+		err := m.busy(r)
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.mu.Unlock()
+
+	release := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		for i, held := range m.held {
+			if held == r {
+				m.held = append(m.held[:i], m.held[i+1:]...)
+				break
+			}
+		}
+		m.dispatch()
+	}
+	if !waiting {
+		return release, nil
+	}
+
+	timer := time.NewTimer(m.wait)
+	defer timer.Stop()
+	select {
+	case <-r.ready:
+		return release, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	granted := !r.lease.Waiting
+	if !granted {
+		m.remove(r)
+		m.dispatch()
+	}
+	err := ctx.Err()
+	if err == nil {
+		err = m.busy(r)
+	}
+	m.mu.Unlock()
+
+	if granted {
+		// Granted concurrently with giving up
+		release()
+	}
+	return nil, err
+}
+
+// List returns the held leases, then the waiting requests, each oldest
+// first.
+func (m *Manager) List() []Lease {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	leases := make([]Lease, 0, len(m.held)+len(m.queue))
+	for _, r := range m.held {
+		leases = append(leases, r.lease)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Since.Before(leases[j].Since) })
+	for _, r := range m.queue {
+		leases = append(leases, r.lease)
+	}
+	return leases
+}
+
+// Resolve returns the absolute, symlink-free form of dir that leases are
+// taken on. A directory that cannot be resolved is only cleaned.
+func Resolve(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	return filepath.Clean(dir)
+}
+
+// dispatch grants queued requests in order. A request waits while it
+// conflicts with a held lease or an earlier queued request.
+// Must be called with m.mu held.
+func (m *Manager) dispatch() {
+	i := 0
+	for i < len(m.queue) {
+		r := m.queue[i]
+		if m.conflicts(r, m.held) || m.conflicts(r, m.queue[:i]) {
+			i++
+			continue
+		}
+
+		r.lease.Waiting = false
+		r.lease.Since = time.Now()
+		m.held = append(m.held, r)
+		close(r.ready)
+		m.queue = append(m.queue[:i], m.queue[i+1:]...)
+	}
+}
+
+func (m *Manager) conflicts(r *request, others []*request) bool {
+	for _, other := range others {
+		if conflict(r.lease, other.lease) {
+			return true
+		}
+	}
+	return false
+}
+
+// busy returns the error for a request that could not be granted, naming
+// the leases in its way. Must be called with m.mu held.
+func (m *Manager) busy(r *request) error {
+	var holders []string
+	for _, held := range m.held {
+		if conflict(r.lease, held.lease) {
+			h := held.lease
+			holder := h.Holder.Agent
+			if h.Holder.SessionID != "" {
+				holder += " (session " + h.Holder.SessionID + ")"
+			}
+			holders = append(holders, fmt.Sprintf("%s, %s lease on %s since %s", holder, h.Mode, h.Dir, h.Since.Format(time.RFC3339)))
+		}
+	}
+	if len(holders) == 0 {
+		// Only queued requests are ahead of it
+		return fmt.Errorf("%w: %s has earlier conflicting requests waiting", ErrBusy, r.lease.Dir)
+	}
+	return fmt.Errorf("%w: %s is held by %s", ErrBusy, r.lease.Dir, strings.Join(holders, "; "))
+}
+
+func (m *Manager) remove(r *request) {
+	for i, queued := range m.queue {
+		if queued == r {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// conflict reports whether two leases cannot be held at once: one of them
+// is exclusive and their directories overlap.
+func conflict(a, b Lease) bool {
+	if a.Mode == Shared && b.Mode == Shared {
+		return false
+	}
+	return within(a.Dir, b.Dir) || within(b.Dir, a.Dir)
+}
+
+// within reports whether dir is parent or a directory under it.
+func within(dir, parent string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
@@ -0,0 +1,142 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func acquire(t *testing.T, m *Manager, dir string, mode Mode, agent string) func() {
+	t.Helper()
+	release, err := m.Acquire(context.Background(), dir, mode, Holder{Agent: agent})
+	if err != nil {
+		t.Fatalf("Acquire %s %s failed: %v", mode, dir, err)
+	}
+	return release
+}
+
+func TestAcquire_SharedLeasesCoexist(t *testing.T) {
+	m := NewManager(0)
+	dir := t.TempDir()
+
+	release1 := acquire(t, m, dir, Shared, "analyzer")
+	release2 := acquire(t, m, dir, Shared, "locator")
+	if leases := m.List(); len(leases) != 2 {
+		t.Errorf("Expected 2 leases, got %+v", leases)
+	}
+	release1()
+	release2()
+
+	release := acquire(t, m, dir, Exclusive, "developer")
+	release()
+}
+
+func TestAcquire_FailFast(t *testing.T) {
+	m := NewManager(0)
+	dir := t.TempDir()
+
+	release := acquire(t, m, dir, Exclusive, "developer")
+	_, err := m.Acquire(context.Background(), filepath.Join(dir, "."), Shared, Holder{Agent: "analyzer"})
+	if !errors.Is(err, ErrBusy) || !strings.Contains(err.Error(), "developer") {
+		t.Fatalf("Expected ErrBusy naming the holder, got %v", err)
+	}
+	if leases := m.List(); len(leases) != 1 {
+		t.Errorf("Expected the failed request to leave no trace, got %+v", leases)
+	}
+	release()
+}
+
+func TestAcquire_Overlap(t *testing.T) {
+	m := NewManager(0)
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "pkg")
+	os.Mkdir(sub, 0755)
+	sibling := dir + "-other"
+
+	release := acquire(t, m, dir, Exclusive, "developer")
+	defer release()
+
+	if _, err := m.Acquire(context.Background(), sub, Shared, Holder{}); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected a subdirectory to conflict, got %v", err)
+	}
+	other := acquire(t, m, sibling, Exclusive, "developer")
+	other()
+}
+
+func TestAcquire_ResolvesSymlinks(t *testing.T) {
+	m := NewManager(0)
+	dir := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Skip("symlinks not supported")
+	}
+
+	release := acquire(t, m, dir, Exclusive, "developer")
+	defer release()
+	if _, err := m.Acquire(context.Background(), link, Exclusive, Holder{}); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected a symlink to the directory to conflict, got %v", err)
+	}
+}
+
+func TestAcquire_WaitsInOrder(t *testing.T) {
+	m := NewManager(time.Minute)
+	dir := t.TempDir()
+
+	release := acquire(t, m, dir, Exclusive, "developer")
+
+	granted := make(chan string, 2)
+	go func() {
+		r := acquire(t, m, dir, Exclusive, "writer")
+		granted <- "writer"
+		r()
+	}()
+	waitFor(t, m, 2)
+	go func() {
+		r := acquire(t, m, dir, Shared, "reader")
+		granted <- "reader"
+		r()
+	}()
+	waitFor(t, m, 3)
+
+	release()
+	if first := <-granted; first != "writer" {
+		t.Errorf("Expected the waiting writer first, got %s", first)
+	}
+	<-granted
+}
+
+func TestAcquire_WaitTimesOut(t *testing.T) {
+	m := NewManager(20 * time.Millisecond)
+	dir := t.TempDir()
+
+	release := acquire(t, m, dir, Exclusive, "developer")
+	defer release()
+	if _, err := m.Acquire(context.Background(), dir, Exclusive, Holder{}); !errors.Is(err, ErrBusy) {
+		t.Errorf("Expected ErrBusy after the wait, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.Acquire(ctx, dir, Exclusive, Holder{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+	if leases := m.List(); len(leases) != 1 {
+		t.Errorf("Expected abandoned requests to leave the queue, got %+v", leases)
+	}
+}
+
+// waitFor waits until n leases are held or requested.
+func waitFor(t *testing.T, m *Manager, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.List()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d leases", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+package limiter
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type waiter struct {
+	agent    string
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	granted  bool
+}
+
+// Limiter bounds how many agent runs execute at once, both globally and per
+// agent. Callers that cannot run immediately wait in a queue ordered by
+// priority (highest first) and then by arrival.
+type Limiter struct {
+	mu           sync.Mutex
+	global       int
+	perAgent     int
+	agentLimits  map[string]int
+	running      int
+	agentRunning map[string]int
+	queue        []*waiter
+	seq          uint64
+}
+
+type Stats struct {
+	Running int
+	Waiting int
+}
+
+// New creates a limiter. A limit of 0 means unlimited.
+func New(global, perAgent int) *Limiter {
+	return &Limiter{
+		global:       global,
+		perAgent:     perAgent,
+		agentLimits:  make(map[string]int),
+		agentRunning: make(map[string]int),
+	}
+}
+
+// SetAgentLimit overrides the per-agent limit for a single agent.
+func (l *Limiter) SetAgentLimit(agent string, limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.agentLimits[agent] = limit
+	l.dispatch()
+}
+
+// Acquire blocks until a slot is available for agent or ctx is done. The
+// returned release function must be called exactly once when the run ends.
+func (l *Limiter) Acquire(ctx context.Context, agent string, priority int) (func(), error) {
+	l.mu.Lock()
+	l.seq++
+	w := &waiter{
+		agent:    agent,
+		priority: priority,
+		seq:      l.seq,
+		ready:    make(chan struct{}),
+	}
+	l.queue = append(l.queue, w)
+	sort.SliceStable(l.queue, func(i, j int) bool {
+		if l.queue[i].priority != l.queue[j].priority {
+			return l.queue[i].priority > l.queue[j].priority
+		}
+		return l.queue[i].seq < l.queue[j].seq
+	})
+	l.dispatch()
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.running--
+		l.agentRunning[agent]--
+		l.dispatch()
+	}
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if w.granted {
+			// Granted concurrently with cancellation; give the slot back
+			l.running--
+			l.agentRunning[agent]--
+		} else {
+			l.remove(w)
+		}
+		l.dispatch()
+		return nil, ctx.Err()
+	}
+}
+
+// Stats returns the number of running and waiting callers.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return Stats{
+		Running: l.running,
+		Waiting: len(l.queue),
+	}
+}
+
+// dispatch grants slots to queued waiters in order. A waiter blocked only by
+// its own agent's limit does not hold up waiters for other agents.
+// Must be called with l.mu held.
+func (l *Limiter) dispatch() {
+	i := 0
+	for i < len(l.queue) {
+		if l.global > 0 && l.running >= l.global {
+			return
+		}
+
+		w := l.queue[i]
+		if !l.agentHasCapacity(w.agent) {
+			i++
+			continue
+		}
+
+		l.running++
+		l.agentRunning[w.agent]++
+		w.granted = true
+		close(w.ready)
+		l.queue = append(l.queue[:i], l.queue[i+1:]...)
+	}
+}
+
+func (l *Limiter) agentHasCapacity(agent string) bool {
+	limit := l.perAgent
+	if agentLimit, ok := l.agentLimits[agent]; ok {
+		limit = agentLimit
+	}
+	return limit <= 0 || l.agentRunning[agent] < limit
+}
+
+func (l *Limiter) remove(w *waiter) {
+	for i, queued := range l.queue {
+		if queued == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquire_Unlimited(t *testing.T) {
+	l := New(0, 0)
+
+	var releases []func()
+	for i := 0; i < 10; i++ {
+		release, err := l.Acquire(context.Background(), "agent", 0)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		releases = append(releases, release)
+	}
+
+	if stats := l.Stats(); stats.Running != 10 {
+		t.Errorf("Expected 10 running, got %d", stats.Running)
+	}
+
+	for _, release := range releases {
+		release()
+	}
+
+	if stats := l.Stats(); stats.Running != 0 {
+		t.Errorf("Expected 0 running after release, got %d", stats.Running)
+	}
+}
+
+func TestAcquire_GlobalLimit(t *testing.T) {
+	l := New(1, 0)
+
+	release, err := l.Acquire(context.Background(), "agent1", 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "agent2", 0); err == nil {
+		t.Error("Expected second Acquire to wait until the context expired")
+	}
+
+	if stats := l.Stats(); stats.Waiting != 0 {
+		t.Errorf("Cancelled waiter should leave the queue, got %d waiting", stats.Waiting)
+	}
+
+	release()
+
+	release, err = l.Acquire(context.Background(), "agent2", 0)
+	if err != nil {
+		t.Fatalf("Acquire after release failed: %v", err)
+	}
+	release()
+}
+
+func TestAcquire_PerAgentLimit(t *testing.T) {
+	l := New(0, 1)
+
+	release1, err := l.Acquire(context.Background(), "agent1", 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer release1()
+
+	// A different agent is not blocked by agent1's limit
+	release2, err := l.Acquire(context.Background(), "agent2", 0)
+	if err != nil {
+		t.Fatalf("Acquire for other agent failed: %v", err)
+	}
+	defer release2()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "agent1", 0); err == nil {
+		t.Error("Expected Acquire to block on per-agent limit")
+	}
+}
+
+func TestSetAgentLimit(t *testing.T) {
+	l := New(0, 1)
+	l.SetAgentLimit("agent1", 2)
+
+	for i := 0; i < 2; i++ {
+		release, err := l.Acquire(context.Background(), "agent1", 0)
+		if err != nil {
+			t.Fatalf("Acquire %d failed: %v", i, err)
+		}
+		defer release()
+	}
+}
+
+func TestAcquire_PriorityOrder(t *testing.T) {
+	l := New(1, 0)
+
+	release, err := l.Acquire(context.Background(), "holder", 0)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queued := 0
+
+	enqueue := func(agent string, priority int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := l.Acquire(context.Background(), agent, priority)
+			if err != nil {
+				t.Errorf("Acquire for %s failed: %v", agent, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, agent)
+			mu.Unlock()
+			r()
+		}()
+		queued++
+		waitForWaiting(t, l, queued)
+	}
+
+	enqueue("low-first", 0)
+	enqueue("low-second", 0)
+	enqueue("high", 10)
+
+	release()
+	wg.Wait()
+
+	expected := []string{"high", "low-first", "low-second"}
+	for i, agent := range expected {
+		if order[i] != agent {
+			t.Errorf("Expected %v, got %v", expected, order)
+			break
+		}
+	}
+}
+
+func waitForWaiting(t *testing.T, l *Limiter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if l.Stats().Waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d waiting", n)
+}
@@ -4,24 +4,20 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"budgie/internal/config"
+	"budgie/internal/container"
 	"budgie/internal/health"
 	"budgie/internal/kiro"
 	"budgie/internal/sessions"
 )
 
-// TestSandboxVolumeCreation verifies that Docker volumes are created for sandbox sessions
-func TestSandboxVolumeCreation(t *testing.T) {
-	if !isDockerAvailable() {
-		t.Skip("Docker not available")
-	}
-
+// TestSandboxSessionDirCreation verifies sandbox sessions use host directories for bind mounts
+func TestSandboxSessionDirCreation(t *testing.T) {
 	tmpDir := t.TempDir()
 	mgr := sessions.NewManager(tmpDir, true)
 
@@ -31,19 +27,18 @@ func TestSandboxVolumeCreation(t *testing.T) {
 		t.Fatalf("GetWorkspaceDir failed: %v", err)
 	}
 
-	if dir != sessionID {
-		t.Errorf("Expected session ID as return value in sandbox mode, got %s", dir)
+	if dir != filepath.Join(tmpDir, sessionID) {
+		t.Errorf("Expected host session directory, got %s", dir)
 	}
 
-	volumeName := "budgie-session-" + sessionID
-	if !volumeExists(volumeName) {
-		t.Errorf("Docker volume %s was not created", volumeName)
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Session directory %s was not created: %v", dir, err)
 	}
 
 	mgr.Cleanup()
 
-	if volumeExists(volumeName) {
-		t.Errorf("Docker volume %s should be removed after cleanup", volumeName)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Session directory %s should be removed after cleanup", dir)
 	}
 }
 
@@ -83,12 +78,8 @@ func TestSandboxExecutorDockerCommand(t *testing.T) {
 		t.Error("Command should use docker")
 	}
 
-	if !strings.Contains(cmdStr, "budgie-session-test-session-123") {
-		t.Error("Command should include volume mount for session")
-	}
-
-	if !strings.Contains(cmdStr, "/root/.local/share/kiro-cli") {
-		t.Error("Command should mount kiro-cli data directory")
+	if !strings.Contains(cmdStr, sessionDir+":/root/.local/share/kiro-cli:rw") {
+		t.Error("Command should bind-mount the session directory at the kiro-cli data directory")
 	}
 
 	if !strings.Contains(cmdStr, "/auth") {
@@ -120,6 +111,27 @@ func TestSandboxExecutorDockerCommand(t *testing.T) {
 	}
 }
 
+// TestSandboxPodmanCommand verifies the container runtime is used for sandbox commands
+func TestSandboxPodmanCommand(t *testing.T) {
+	runner := kiro.NewDockerRunner(container.Podman, "budgie-sandbox:latest")
+	executor := kiro.NewExecutorWithRunner(runner, 5*time.Minute, health.NewMonitor(), false)
+
+	cmd := executor.BuildDockerCommand(context.Background(), "test-agent", "test prompt", "test-session-123", "", "", "/home/user/project")
+
+	if cmd.Args[0] != "podman" {
+		t.Errorf("Command should use podman, got %s", cmd.Args[0])
+	}
+
+	cmdStr := strings.Join(cmd.Args, " ")
+	if !strings.Contains(cmdStr, "--security-opt label=disable") {
+		t.Error("Podman command should disable SELinux labelling for bind mounts")
+	}
+
+	if !strings.Contains(cmdStr, "test-session-123:/root/.local/share/kiro-cli") {
+		t.Error("Command should include bind mount for session")
+	}
+}
+
 // TestSandboxExecutorNormalMode verifies normal (non-sandbox) mode still works
 func TestSandboxExecutorNormalMode(t *testing.T) {
 	monitor := health.NewMonitor()
@@ -173,10 +185,6 @@ func TestSandboxConfigPropagation(t *testing.T) {
 
 // TestSandboxMultipleSessions verifies multiple sandbox sessions can coexist
 func TestSandboxMultipleSessions(t *testing.T) {
-	if !isDockerAvailable() {
-		t.Skip("Docker not available")
-	}
-
 	tmpDir := t.TempDir()
 	mgr := sessions.NewManager(tmpDir, true)
 
@@ -191,20 +199,13 @@ func TestSandboxMultipleSessions(t *testing.T) {
 	}
 
 	if dir1 == dir2 {
-		t.Error("Different sessions should have different IDs")
-	}
-
-	volume1 := "budgie-session-" + sessionID1
-	volume2 := "budgie-session-" + sessionID2
-
-	if !volumeExists(volume1) || !volumeExists(volume2) {
-		t.Error("Both volumes should exist")
+		t.Error("Different sessions should have different directories")
 	}
 
 	mgr.Cleanup()
 
-	if volumeExists(volume1) || volumeExists(volume2) {
-		t.Error("Both volumes should be removed after cleanup")
+	if pathExists(dir1) || pathExists(dir2) {
+		t.Error("Both session directories should be removed after cleanup")
 	}
 }
 
@@ -222,6 +223,30 @@ func TestSandboxAuthPathDetection(t *testing.T) {
 	}
 }
 
+// TestSandboxResponseReadFromHost verifies response files are read without a helper container
+func TestSandboxResponseReadFromHost(t *testing.T) {
+	sessionDir := t.TempDir()
+	runner := kiro.NewDockerRunner(container.Docker, "budgie-sandbox:latest")
+
+	// A response written by the container lands in the bind-mounted session directory
+	os.WriteFile(filepath.Join(sessionDir, "response-abc.txt"), []byte("done\n"), 0644)
+
+	content, err := runner.ReadResponse(sessionDir, "response-abc.txt")
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if content != "done" {
+		t.Errorf("Expected 'done', got %q", content)
+	}
+
+	if err := runner.AppendSessionFile(sessionDir, "chat-abc.txt", "log"); err != nil {
+		t.Fatalf("AppendSessionFile failed: %v", err)
+	}
+	if !pathExists(filepath.Join(sessionDir, "chat-abc.txt")) {
+		t.Error("Chat debug log should be written to the session directory")
+	}
+}
+
 // TestSandboxResponseFileHandling verifies response file paths in sandbox mode
 func TestSandboxResponseFileHandling(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -245,12 +270,7 @@ func TestSandboxResponseFileHandling(t *testing.T) {
 
 // Helper functions
 
-func isDockerAvailable() bool {
-	cmd := exec.Command("docker", "version")
-	return cmd.Run() == nil
-}
-
-func volumeExists(volumeName string) bool {
-	cmd := exec.Command("docker", "volume", "inspect", volumeName)
-	return cmd.Run() == nil
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
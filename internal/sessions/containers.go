@@ -0,0 +1,191 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warmContainer is a long-lived sandbox container serving every turn of a
+// session in warm mode.
+type warmContainer struct {
+	start   sync.Mutex // serializes starting the container
+	name    string
+	workDir string // guarded by start
+	args    string // run args the container was started with; guarded by start
+	running bool   // guarded by start
+
+	active   int       // guarded by Manager.mutex
+	lastUsed time.Time // guarded by Manager.mutex
+	// discard marks a container to remove once its last turn is released;
+	// guarded by Manager.mutex
+	discard bool
+	// oomKills is the container's OOM kill count when last noted; guarded
+	// by Manager.mutex
+	oomKills int
+}
+
+// ContainerName returns the name of a session's warm container.
+func ContainerName(sessionID string) string {
+	return "budgie-session-" + sessionID
+}
+
+// EnsureContainer returns the session's warm container, starting it with
+// `run -d` and runArgs (mounts, image and command) if it is not running.
+// Mounts and networks cannot change on a running container, so one started
+// for another working directory or with other run args is replaced unless it
+// is in use. Every successful call
+// must be paired with ReleaseContainer.
+func (m *Manager) EnsureContainer(sessionID, workDir string, runArgs []string) (string, error) {
+	m.mutex.Lock()
+	c := m.containers[sessionID]
+	if c == nil {
+		c = &warmContainer{name: ContainerName(sessionID)}
+		m.containers[sessionID] = c
+	}
+	if c.discard {
+		m.mutex.Unlock()
+		return "", fmt.Errorf("session container %s is being discarded; retry once its other turns finish", c.name)
+	}
+	c.active++
+	busy := c.active > 1
+	m.mutex.Unlock()
+
+	c.start.Lock()
+	defer c.start.Unlock()
+
+	key := strings.Join(runArgs, "\x00")
+	if c.running && c.workDir == workDir && c.args == key {
+		return c.name, nil
+	}
+	if c.running && busy {
+		m.ReleaseContainer(sessionID, false)
+		return "", fmt.Errorf("session container %s is busy in %s with other settings", c.name, c.workDir)
+	}
+
+	// Remove a container left over from a previous turn or budgie process
+	m.runtime.Command("rm", "-f", c.name).Run()
+
+	args := append([]string{"run", "-d", "--rm", "--name", c.name}, runArgs...)
+	if output, err := m.runtime.Command(args...).CombinedOutput(); err != nil {
+		c.running = false
+		m.ReleaseContainer(sessionID, false)
+		return "", fmt.Errorf("failed to start session container: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	c.running = true
+	c.workDir = workDir
+	c.args = key
+	return c.name, nil
+}
+
+// ReleaseContainer ends a turn started with EnsureContainer. With discard
+// the container is removed, e.g. after a timed-out turn whose kiro-cli may
+// still be running inside it: straight away if no other turn is using it,
+// otherwise when the last one is released. No new turns start in it
+// meanwhile.
+func (m *Manager) ReleaseContainer(sessionID string, discard bool) {
+	m.mutex.Lock()
+	c := m.containers[sessionID]
+	if c == nil {
+		m.mutex.Unlock()
+		return
+	}
+	if c.active > 0 {
+		c.active--
+	}
+	c.lastUsed = time.Now()
+	if discard {
+		c.discard = true
+	}
+	remove := c.discard && c.active == 0
+	if remove {
+		delete(m.containers, sessionID)
+	}
+	m.mutex.Unlock()
+
+	if remove {
+		m.runtime.Command("rm", "-f", c.name).Run()
+	}
+}
+
+// NoteOOMKills records the OOM kill count read from the session's warm
+// container and reports whether it rose since the last call.
+func (m *Manager) NoteOOMKills(sessionID string, count int) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c := m.containers[sessionID]
+	if c == nil {
+		return false
+	}
+	rose := count > c.oomKills
+	c.oomKills = count
+	return rose
+}
+
+// RunningContainer returns the session's warm container if one is running.
+func (m *Manager) RunningContainer(sessionID string) (string, bool) {
+	m.mutex.Lock()
+	c := m.containers[sessionID]
+	m.mutex.Unlock()
+	if c == nil {
+		return "", false
+	}
+
+	c.start.Lock()
+	defer c.start.Unlock()
+	return c.name, c.running
+}
+
+// StartIdleReaper removes warm containers that have been idle for longer
+// than idleTimeout, until ctx is done.
+func (m *Manager) StartIdleReaper(ctx context.Context, idleTimeout time.Duration) {
+	interval := idleTimeout / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.reapIdle(idleTimeout)
+			}
+		}
+	}()
+}
+
+func (m *Manager) reapIdle(idleTimeout time.Duration) {
+	var idle []string
+
+	m.mutex.Lock()
+	for sessionID, c := range m.containers {
+		if c.active == 0 && time.Since(c.lastUsed) > idleTimeout {
+			idle = append(idle, c.name)
+			delete(m.containers, sessionID)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, name := range idle {
+		m.runtime.Command("rm", "-f", name).Run()
+	}
+}
+
+// removeContainers removes all warm containers. Callers hold m.mutex.
+func (m *Manager) removeContainers() {
+	for sessionID, c := range m.containers {
+		m.runtime.Command("rm", "-f", c.name).Run()
+		delete(m.containers, sessionID)
+	}
+}
@@ -0,0 +1,206 @@
+package sessions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"budgie/internal/container"
+)
+
+// fakeRuntime returns a container runtime that logs its arguments instead of
+// running containers.
+func fakeRuntime(t *testing.T) (container.Runtime, string) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "calls.log")
+	script := filepath.Join(dir, "fake-docker")
+	content := "#!/bin/sh\necho \"$@\" >> " + logFile + "\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return container.Runtime(script), logFile
+}
+
+func readCalls(t *testing.T, logFile string) []string {
+	content, err := os.ReadFile(logFile)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func countPrefix(calls []string, prefix string) int {
+	n := 0
+	for _, call := range calls {
+		if strings.HasPrefix(call, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEnsureContainer_ReusedAcrossTurns(t *testing.T) {
+	rt, logFile := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	for i := 0; i < 3; i++ {
+		name, err := mgr.EnsureContainer("s1", "/project", []string{"image", "sleep", "infinity"})
+		if err != nil {
+			t.Fatalf("EnsureContainer failed: %v", err)
+		}
+		if name != "budgie-session-s1" {
+			t.Errorf("Unexpected container name %s", name)
+		}
+		mgr.ReleaseContainer("s1", false)
+	}
+
+	calls := readCalls(t, logFile)
+	if n := countPrefix(calls, "run -d --rm --name budgie-session-s1 image sleep infinity"); n != 1 {
+		t.Errorf("Expected container to be started once, got %d starts: %v", n, calls)
+	}
+}
+
+func TestEnsureContainer_NewWorkDirReplacesContainer(t *testing.T) {
+	rt, logFile := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	mgr.EnsureContainer("s1", "/a", []string{"image"})
+	mgr.ReleaseContainer("s1", false)
+	mgr.EnsureContainer("s1", "/b", []string{"image"})
+	mgr.ReleaseContainer("s1", false)
+
+	if n := countPrefix(readCalls(t, logFile), "run -d"); n != 2 {
+		t.Errorf("Expected a new container for a new working directory, got %d starts", n)
+	}
+}
+
+func TestEnsureContainer_NewRunArgsReplaceContainer(t *testing.T) {
+	rt, logFile := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	mgr.EnsureContainer("s1", "/a", []string{"image"})
+	mgr.ReleaseContainer("s1", false)
+	mgr.EnsureContainer("s1", "/a", []string{"--network", "none", "image"})
+	mgr.ReleaseContainer("s1", false)
+
+	if n := countPrefix(readCalls(t, logFile), "run -d"); n != 2 {
+		t.Errorf("Expected a new container for a new network policy, got %d starts", n)
+	}
+}
+
+func TestEnsureContainer_BusyWithOtherWorkDir(t *testing.T) {
+	rt, _ := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	if _, err := mgr.EnsureContainer("s1", "/a", []string{"image"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.EnsureContainer("s1", "/b", []string{"image"}); err == nil {
+		t.Error("Expected error while the container is in use in another directory")
+	}
+}
+
+func TestReleaseContainer_Discard(t *testing.T) {
+	rt, _ := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	mgr.EnsureContainer("s1", "/a", []string{"image"})
+	mgr.ReleaseContainer("s1", true)
+
+	if _, ok := mgr.RunningContainer("s1"); ok {
+		t.Error("Discarded container should not be running")
+	}
+}
+
+func TestReleaseContainer_DiscardWhileInUse(t *testing.T) {
+	rt, logFile := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	mgr.EnsureContainer("s1", "/a", []string{"image"})
+	mgr.EnsureContainer("s1", "/a", []string{"image"})
+	mgr.ReleaseContainer("s1", true)
+
+	if n := countPrefix(readCalls(t, logFile), "rm -f budgie-session-s1"); n != 1 {
+		t.Fatalf("Expected the container to survive while another turn uses it, got %d removals", n)
+	}
+	if _, err := mgr.EnsureContainer("s1", "/a", []string{"image"}); err == nil {
+		t.Error("Expected no new turns in a container marked for discard")
+	}
+
+	mgr.ReleaseContainer("s1", false)
+	if n := countPrefix(readCalls(t, logFile), "rm -f budgie-session-s1"); n != 2 {
+		t.Errorf("Expected the container to be removed with its last turn, got %d removals", n)
+	}
+	if _, ok := mgr.RunningContainer("s1"); ok {
+		t.Error("Discarded container should not be running")
+	}
+}
+
+func TestNoteOOMKills(t *testing.T) {
+	rt, _ := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+	mgr.EnsureContainer("s1", "/a", []string{"image"})
+
+	for _, tt := range []struct {
+		count int
+		rose  bool
+	}{{0, false}, {1, true}, {1, false}, {3, true}} {
+		if rose := mgr.NoteOOMKills("s1", tt.count); rose != tt.rose {
+			t.Errorf("NoteOOMKills(%d) = %v, want %v", tt.count, rose, tt.rose)
+		}
+	}
+	if mgr.NoteOOMKills("unknown", 1) {
+		t.Error("Expected no OOM kills for a session without a container")
+	}
+}
+
+func TestReapIdle(t *testing.T) {
+	rt, logFile := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	mgr.EnsureContainer("idle", "/a", []string{"image"})
+	mgr.ReleaseContainer("idle", false)
+	mgr.EnsureContainer("busy", "/a", []string{"image"})
+
+	time.Sleep(20 * time.Millisecond)
+	mgr.reapIdle(10 * time.Millisecond)
+
+	if _, ok := mgr.RunningContainer("idle"); ok {
+		t.Error("Idle container should have been removed")
+	}
+	if _, ok := mgr.RunningContainer("busy"); !ok {
+		t.Error("Container in use should not be removed")
+	}
+	if n := countPrefix(readCalls(t, logFile), "rm -f budgie-session-idle"); n < 2 {
+		t.Errorf("Expected idle container removal, got %d rm calls", n)
+	}
+}
+
+func TestCleanup_RemovesContainersAndSessionDirs(t *testing.T) {
+	rt, logFile := fakeRuntime(t)
+	mgr := NewManager(t.TempDir(), true)
+	mgr.SetContainerRuntime(rt, "image")
+
+	dir, _ := mgr.GetWorkspaceDir("s1")
+	mgr.EnsureContainer("s1", "/a", []string{"image"})
+	mgr.ReleaseContainer("s1", false)
+	mgr.Cleanup()
+
+	calls := readCalls(t, logFile)
+	if calls[len(calls)-1] != "rm -f budgie-session-s1" {
+		t.Errorf("Expected container removal on cleanup, got %v", calls)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Session directory %s should be removed after cleanup", dir)
+	}
+}
@@ -1,30 +1,55 @@
 package sessions
 
 import (
-	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sync"
 
+	"budgie/internal/container"
+
 	"github.com/google/uuid"
 )
 
 type Manager struct {
-	baseDir     string
-	sessions    map[string]bool
-	mutex       sync.Mutex
-	sandboxMode bool
+	baseDir      string
+	sessions     map[string]bool
+	mutex        sync.Mutex
+	sandboxMode  bool
+	runtime      container.Runtime
+	sandboxImage string
+	containers   map[string]*warmContainer
+	worktrees    map[string]*Worktree
+	// sessionLocks serialize worktree creation per session
+	sessionLocks map[string]*sessionLock
 }
 
 func NewManager(baseDir string, sandboxMode bool) *Manager {
 	return &Manager{
-		baseDir:     baseDir,
-		sessions:    make(map[string]bool),
-		sandboxMode: sandboxMode,
+		baseDir:      baseDir,
+		sessions:     make(map[string]bool),
+		sandboxMode:  sandboxMode,
+		runtime:      container.Docker,
+		containers:   make(map[string]*warmContainer),
+		worktrees:    make(map[string]*Worktree),
+		sessionLocks: make(map[string]*sessionLock),
 	}
 }
 
+// SetContainerRuntime sets the container CLI and image used for session
+// containers and cleanup in sandbox mode.
+func (m *Manager) SetContainerRuntime(rt container.Runtime, sandboxImage string) {
+	m.runtime = rt
+	m.sandboxImage = sandboxImage
+}
+
+// SetSandboxMode marks that some runs write session files from a container,
+// so Cleanup may need the container runtime to remove them.
+func (m *Manager) SetSandboxMode(enabled bool) {
+	m.mutex.Lock()
+	m.sandboxMode = enabled
+	m.mutex.Unlock()
+}
+
 func (m *Manager) GetWorkspaceDir(sessionID string) (string, error) {
 	if sessionID == "" {
 		sessionID = uuid.New().String()
@@ -34,37 +59,48 @@ func (m *Manager) GetWorkspaceDir(sessionID string) (string, error) {
 	m.sessions[sessionID] = true
 	m.mutex.Unlock()
 
-	if m.sandboxMode {
-		volumeName := "budgie-session-" + sessionID
-		cmd := exec.Command("docker", "volume", "create", volumeName)
-		if err := cmd.Run(); err != nil {
-			return "", fmt.Errorf("failed to create docker volume: %w", err)
-		}
-		return sessionID, nil
+	rootDir, err := m.rootDir()
+	if err != nil {
+		return "", err
 	}
+	sessionDir := filepath.Join(rootDir, sessionID)
 
-	var sessionDir string
-	if m.baseDir != "" {
-		sessionDir = filepath.Join(m.baseDir, sessionID)
-	} else {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return "", err
-		}
-		sessionDir = filepath.Join(homeDir, ".kiro", "sub-agents", "sessions", sessionID)
+	// Sessions hold prompts, chat logs and, in sandbox mode, a copy of the
+	// kiro-cli auth database
+	if err := os.MkdirAll(sessionDir, 0700); err != nil {
+		return "", err
 	}
 
-	if err := os.MkdirAll(sessionDir, 0755); err != nil {
+	return sessionDir, nil
+}
+
+// WorkspacePath returns the session's workspace directory without creating
+// or registering it. An empty sessionID gets the path a new session would
+// have.
+func (m *Manager) WorkspacePath(sessionID string) (string, error) {
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+	rootDir, err := m.rootDir()
+	if err != nil {
 		return "", err
 	}
+	return filepath.Join(rootDir, sessionID), nil
+}
 
-	return sessionDir, nil
+// rootDir returns the directory holding the session directories.
+func (m *Manager) rootDir() (string, error) {
+	if m.baseDir != "" {
+		return m.baseDir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".kiro", "sub-agents", "sessions"), nil
 }
 
 func (m *Manager) GetSessionID(sessionDir string) string {
-	if m.sandboxMode {
-		return sessionDir
-	}
 	return filepath.Base(sessionDir)
 }
 
@@ -72,19 +108,54 @@ func (m *Manager) Cleanup() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	// Containers must go before the session directories they mount
+	m.removeContainers()
+	m.removeWorktrees()
+
 	for sessionID := range m.sessions {
-		if m.sandboxMode {
-			volumeName := "budgie-session-" + sessionID
-			exec.Command("docker", "volume", "rm", volumeName).Run()
-		} else {
-			var sessionDir string
-			if m.baseDir != "" {
-				sessionDir = filepath.Join(m.baseDir, sessionID)
-			}
-			if sessionDir != "" {
-				os.RemoveAll(sessionDir)
-			}
+		var sessionDir string
+		if m.baseDir != "" {
+			sessionDir = filepath.Join(m.baseDir, sessionID)
+		}
+		if sessionDir == "" {
+			continue
+		}
+		if err := os.RemoveAll(sessionDir); err != nil && m.sandboxMode {
+			m.removeAsContainerRoot(sessionDir)
 		}
 	}
 	m.sessions = make(map[string]bool)
 }
+
+// RemoveAll removes a directory outside the session directories that
+// sandboxed runs may have written to, such as an isolated copy of a working
+// directory.
+func (m *Manager) RemoveAll(dir string) error {
+	err := os.RemoveAll(dir)
+
+	m.mutex.Lock()
+	sandboxMode := m.sandboxMode
+	m.mutex.Unlock()
+
+	if err != nil && sandboxMode {
+		return m.removeAsContainerRoot(dir)
+	}
+	return err
+}
+
+// removeAsContainerRoot removes a session directory holding files that a
+// rootful container created as root, which the host user cannot delete. It
+// runs the sandbox image, which is always present locally in sandbox mode.
+func (m *Manager) removeAsContainerRoot(sessionDir string) error {
+	if m.sandboxImage == "" {
+		return os.RemoveAll(sessionDir)
+	}
+	args := append([]string{"run", "--rm"}, m.runtime.RunArgs()...)
+	args = append(args,
+		"-v", sessionDir+":/data:rw",
+		"--entrypoint", "find",
+		m.sandboxImage,
+		"/data", "-mindepth", "1", "-delete")
+	m.runtime.Command(args...).Run()
+	return os.RemoveAll(sessionDir)
+}
@@ -19,8 +19,10 @@ func TestGetWorkspaceDir_NewSession(t *testing.T) {
 		t.Errorf("Expected absolute path, got: %s", dir)
 	}
 
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
+	if info, err := os.Stat(dir); err != nil {
 		t.Errorf("Directory was not created: %s", dir)
+	} else if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the session directory to be private, got %v", info.Mode().Perm())
 	}
 
 	if len(mgr.sessions) != 1 {
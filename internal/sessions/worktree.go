@@ -0,0 +1,278 @@
+package sessions
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Worktree is a git worktree on its own branch that every turn of one
+// session runs in, so concurrent writing sessions on the same repository do
+// not overwrite each other's edits.
+type Worktree struct {
+	ID        string
+	SessionID string
+	// Repo is the top level of the repository the worktree belongs to
+	Repo string
+	// Path is the top level of the worktree
+	Path   string
+	Branch string
+	// Base is the commit the branch started from
+	Base      string
+	CreatedAt time.Time
+}
+
+// Dir returns the directory in the worktree that corresponds to dir in the
+// repository.
+func (w Worktree) Dir(dir string) (string, error) {
+	// git resolves symlinks in the top level
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	rel, err := filepath.Rel(w.Repo, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside repository %s", dir, w.Repo)
+	}
+	return filepath.Join(w.Path, rel), nil
+}
+
+// sessionLock is a per-session mutex, dropped once nobody waits on it.
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockSession serializes callers for one session and returns the unlock.
+// Calls without a session ID start separate sessions and are not locked.
+func (m *Manager) lockSession(sessionID string) func() {
+	if sessionID == "" {
+		return func() {}
+	}
+
+	m.mutex.Lock()
+	lock := m.sessionLocks[sessionID]
+	if lock == nil {
+		lock = &sessionLock{}
+		m.sessionLocks[sessionID] = lock
+	}
+	lock.refs++
+	m.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		m.mutex.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(m.sessionLocks, sessionID)
+		}
+		m.mutex.Unlock()
+	}
+}
+
+// EnsureWorktree returns the session's worktree, creating one on a new
+// branch from the repository's HEAD on the session's first call. dir may be
+// any directory inside the repository. created reports whether the worktree
+// is new; pair it with AttachWorktree once the call has a session ID.
+// Concurrent calls for one session get the same worktree.
+func (m *Manager) EnsureWorktree(sessionID, dir string) (wt Worktree, created bool, err error) {
+	repo, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return Worktree{}, false, fmt.Errorf("%s is not in a git repository: %w", dir, err)
+	}
+	unlock := m.lockSession(sessionID)
+	defer unlock()
+	if existing, ok := m.Worktree(sessionID); ok {
+		if existing.Repo != repo {
+			return Worktree{}, false, fmt.Errorf("session %s already has a worktree for %s", sessionID, existing.Repo)
+		}
+		return existing, false, nil
+	}
+
+	rootDir, err := m.rootDir()
+	if err != nil {
+		return Worktree{}, false, err
+	}
+	base, err := git(repo, "rev-parse", "HEAD")
+	if err != nil {
+		return Worktree{}, false, fmt.Errorf("repository %s has no commits: %w", repo, err)
+	}
+
+	id := uuid.New().String()[:8]
+	wt = Worktree{
+		ID:        id,
+		SessionID: sessionID,
+		Repo:      repo,
+		Path:      filepath.Join(rootDir, ".worktrees", id),
+		Branch:    "budgie/" + id,
+		Base:      base,
+		CreatedAt: time.Now(),
+	}
+	if _, err := git(repo, "worktree", "add", "-b", wt.Branch, wt.Path, base); err != nil {
+		return Worktree{}, false, fmt.Errorf("failed to create worktree: %w", err)
+	}
+
+	m.mutex.Lock()
+	m.worktrees[id] = &wt
+	m.mutex.Unlock()
+	return wt, true, nil
+}
+
+// AttachWorktree ties a worktree created by a session's first call to the
+// session ID the call returned.
+func (m *Manager) AttachWorktree(id, sessionID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if wt := m.worktrees[id]; wt != nil {
+		wt.SessionID = sessionID
+	}
+}
+
+// AbandonWorktree removes a worktree created by a first call that failed
+// before returning a session ID, along with its branch.
+func (m *Manager) AbandonWorktree(id string) error {
+	m.mutex.Lock()
+	wt := m.worktrees[id]
+	delete(m.worktrees, id)
+	m.mutex.Unlock()
+
+	if wt == nil {
+		return nil
+	}
+	return removeWorktree(*wt, false)
+}
+
+// Worktree returns the session's worktree.
+func (m *Manager) Worktree(sessionID string) (Worktree, bool) {
+	if sessionID == "" {
+		return Worktree{}, false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, wt := range m.worktrees {
+		if wt.SessionID == sessionID {
+			return *wt, true
+		}
+	}
+	return Worktree{}, false
+}
+
+// Worktrees returns every session worktree, oldest first.
+func (m *Manager) Worktrees() []Worktree {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	worktrees := make([]Worktree, 0, len(m.worktrees))
+	for _, wt := range m.worktrees {
+		worktrees = append(worktrees, *wt)
+	}
+	sort.Slice(worktrees, func(i, j int) bool { return worktrees[i].CreatedAt.Before(worktrees[j].CreatedAt) })
+	return worktrees
+}
+
+// MergeWorktree commits anything the agent left uncommitted in the
+// session's worktree, merges its branch into the branch checked out in the
+// repository, then removes the worktree and branch. It refuses to merge into
+// a checkout with uncommitted changes. If the merge fails it is aborted and
+// the worktree is kept.
+func (m *Manager) MergeWorktree(sessionID string) (Worktree, error) {
+	wt, ok := m.Worktree(sessionID)
+	if !ok {
+		return Worktree{}, fmt.Errorf("session %s has no worktree", sessionID)
+	}
+
+	// A failed merge is aborted, which would also throw away uncommitted
+	// work in the checkout
+	if status, err := git(wt.Repo, "status", "--porcelain", "--untracked-files=no"); err != nil {
+		return wt, err
+	} else if status != "" {
+		return wt, fmt.Errorf("the checkout in %s has uncommitted changes; commit or stash them before merging %s", wt.Repo, wt.Branch)
+	}
+
+	if err := commitWorktree(wt); err != nil {
+		return wt, err
+	}
+
+	if _, err := git(wt.Repo, "merge", "--no-edit", wt.Branch); err != nil {
+		git(wt.Repo, "merge", "--abort")
+		return wt, fmt.Errorf("failed to merge %s (worktree kept): %w", wt.Branch, err)
+	}
+
+	return wt, m.RemoveWorktree(sessionID, false)
+}
+
+// RemoveWorktree removes the session's worktree, discarding uncommitted
+// changes in it. The branch is deleted too unless keepBranch is set.
+func (m *Manager) RemoveWorktree(sessionID string, keepBranch bool) error {
+	wt, ok := m.Worktree(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s has no worktree", sessionID)
+	}
+
+	m.mutex.Lock()
+	delete(m.worktrees, wt.ID)
+	m.mutex.Unlock()
+
+	return removeWorktree(wt, keepBranch)
+}
+
+// removeWorktrees commits what each worktree has uncommitted and removes it
+// but keeps the branches, so work that was never merged survives a restart.
+// A worktree whose changes cannot be committed is left on disk. Must be
+// called with m.mutex held.
+func (m *Manager) removeWorktrees() {
+	for id, wt := range m.worktrees {
+		delete(m.worktrees, id)
+		if err := commitWorktree(*wt); err != nil {
+			log.Printf("Keeping worktree %s for session %s: %v", wt.Path, wt.SessionID, err)
+			continue
+		}
+		if err := removeWorktree(*wt, true); err != nil {
+			log.Printf("Failed to remove worktree %s: %v", wt.Path, err)
+		} else {
+			log.Printf("Removed worktree for session %s; its work is on branch %s", wt.SessionID, wt.Branch)
+		}
+	}
+}
+
+// commitWorktree commits anything the agent left uncommitted in wt.
+func commitWorktree(wt Worktree) error {
+	status, err := git(wt.Path, "status", "--porcelain")
+	if err != nil || status == "" {
+		return err
+	}
+	if _, err := git(wt.Path, "add", "-A"); err != nil {
+		return err
+	}
+	if _, err := git(wt.Path, "commit", "-m", "Changes from budgie session "+wt.SessionID); err != nil {
+		return fmt.Errorf("failed to commit worktree changes: %w", err)
+	}
+	return nil
+}
+
+func removeWorktree(wt Worktree, keepBranch bool) error {
+	if _, err := git(wt.Repo, "worktree", "remove", "--force", wt.Path); err != nil {
+		return err
+	}
+	if keepBranch {
+		return nil
+	}
+	_, err := git(wt.Repo, "branch", "-D", wt.Branch)
+	return err
+}
+
+// git runs git in dir and returns its trimmed output.
+func git(dir string, args ...string) (string, error) {
+	output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,162 @@
+package sessions
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newRepo creates a git repository with one commit.
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := git(".", "--version"); err != nil {
+		t.Skip("git not available")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	repo := t.TempDir()
+	os.MkdirAll(filepath.Join(repo, "src"), 0755)
+	os.WriteFile(filepath.Join(repo, "src", "main.go"), []byte("package main\n"), 0644)
+	for _, args := range [][]string{{"init", "-q", "-b", "main"}, {"add", "-A"}, {"commit", "-q", "-m", "init"}} {
+		if _, err := git(repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestEnsureWorktree_PerSession(t *testing.T) {
+	repo := newRepo(t)
+	mgr := NewManager(t.TempDir(), false)
+
+	wt, created, err := mgr.EnsureWorktree("", filepath.Join(repo, "src"))
+	if err != nil || !created {
+		t.Fatalf("EnsureWorktree failed: %v (created %v)", err, created)
+	}
+	mgr.AttachWorktree(wt.ID, "s1")
+
+	dir, err := wt.Dir(filepath.Join(repo, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+		t.Errorf("Expected the subdirectory in the worktree: %v", err)
+	}
+	if branch, _ := git(wt.Path, "branch", "--show-current"); branch != wt.Branch {
+		t.Errorf("Expected worktree on %s, got %s", wt.Branch, branch)
+	}
+
+	again, created, _ := mgr.EnsureWorktree("s1", repo)
+	if created || again.ID != wt.ID {
+		t.Error("Expected later turns of the session to reuse its worktree")
+	}
+	other, created, _ := mgr.EnsureWorktree("s2", repo)
+	if !created || other.Path == wt.Path {
+		t.Error("Expected another session to get its own worktree")
+	}
+}
+
+func TestMergeWorktree(t *testing.T) {
+	repo := newRepo(t)
+	mgr := NewManager(t.TempDir(), false)
+
+	wt, _, _ := mgr.EnsureWorktree("", repo)
+	mgr.AttachWorktree(wt.ID, "s1")
+	os.WriteFile(filepath.Join(wt.Path, "feature.go"), []byte("package main\n"), 0644)
+
+	if _, err := mgr.MergeWorktree("s1"); err != nil {
+		t.Fatalf("MergeWorktree failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "feature.go")); err != nil {
+		t.Error("Expected the session's work to be merged into the repository")
+	}
+	if _, err := os.Stat(wt.Path); err == nil {
+		t.Error("Expected the worktree to be removed after merge")
+	}
+	if branches, _ := git(repo, "branch", "--list", wt.Branch); branches != "" {
+		t.Errorf("Expected branch %s to be deleted, got %q", wt.Branch, branches)
+	}
+}
+
+func TestMergeWorktree_RefusesDirtyCheckout(t *testing.T) {
+	repo := newRepo(t)
+	mgr := NewManager(t.TempDir(), false)
+
+	wt, _, _ := mgr.EnsureWorktree("", repo)
+	mgr.AttachWorktree(wt.ID, "s1")
+	os.WriteFile(filepath.Join(wt.Path, "feature.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(repo, "src", "main.go"), []byte("package main // work in progress\n"), 0644)
+
+	if _, err := mgr.MergeWorktree("s1"); err == nil || !strings.Contains(err.Error(), "uncommitted changes") {
+		t.Fatalf("Expected the merge to be refused, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo, "src", "main.go")); string(data) != "package main // work in progress\n" {
+		t.Errorf("Expected the uncommitted work to survive, got %q", data)
+	}
+	if _, ok := mgr.Worktree("s1"); !ok {
+		t.Error("Expected the worktree to be kept")
+	}
+}
+
+func TestCleanup_KeepsWorktreeBranches(t *testing.T) {
+	repo := newRepo(t)
+	mgr := NewManager(t.TempDir(), false)
+
+	wt, _, _ := mgr.EnsureWorktree("", repo)
+	mgr.AttachWorktree(wt.ID, "s1")
+	mgr.Cleanup()
+
+	if _, err := os.Stat(wt.Path); err == nil {
+		t.Error("Expected the worktree to be removed on cleanup")
+	}
+	if branches, _ := git(repo, "branch", "--list", wt.Branch); !strings.Contains(branches, wt.Branch) {
+		t.Errorf("Expected branch %s to survive cleanup", wt.Branch)
+	}
+}
+
+func TestCleanup_CommitsWorktreeChanges(t *testing.T) {
+	repo := newRepo(t)
+	mgr := NewManager(t.TempDir(), false)
+
+	wt, _, _ := mgr.EnsureWorktree("", repo)
+	mgr.AttachWorktree(wt.ID, "s1")
+	os.WriteFile(filepath.Join(wt.Path, "src", "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	mgr.Cleanup()
+
+	if content, err := git(repo, "show", wt.Branch+":src/main.go"); err != nil || !strings.Contains(content, "func main") {
+		t.Errorf("Expected the uncommitted edit on branch %s, got %q: %v", wt.Branch, content, err)
+	}
+}
+
+func TestEnsureWorktree_ConcurrentTurns(t *testing.T) {
+	repo := newRepo(t)
+	mgr := NewManager(t.TempDir(), false)
+
+	results := make(chan bool, 4)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, created, err := mgr.EnsureWorktree("s1", repo)
+			results <- created && err == nil
+		}()
+	}
+	created := 0
+	for i := 0; i < cap(results); i++ {
+		if <-results {
+			created++
+		}
+	}
+	if created != 1 || len(mgr.Worktrees()) != 1 {
+		t.Errorf("Expected one worktree for the session, got %d created and %d kept", created, len(mgr.Worktrees()))
+	}
+}
+
+func TestEnsureWorktree_NotARepo(t *testing.T) {
+	mgr := NewManager(t.TempDir(), false)
+	if _, _, err := mgr.EnsureWorktree("", t.TempDir()); err == nil {
+		t.Error("Expected error outside a git repository")
+	}
+}
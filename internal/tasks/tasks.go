@@ -0,0 +1,204 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+const (
+	// DefaultTTL is how long a finished task is kept for its result to be
+	// collected
+	DefaultTTL = time.Hour
+	// DefaultMaxFinished is how many finished tasks are kept at most
+	DefaultMaxFinished = 100
+)
+
+// Func is the work performed by a task. It must return promptly once ctx is
+// cancelled. errorClass classifies err, if known.
+type Func func(ctx context.Context) (response, sessionID, errorClass string, err error)
+
+type Task struct {
+	ID         string
+	Agent      string
+	Status     Status
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Response   string
+	SessionID  string
+	Error      string
+	ErrorClass string
+}
+
+// Duration returns how long the task has been running, or how long it ran if
+// it has finished.
+func (t *Task) Duration() time.Duration {
+	if t.FinishedAt.IsZero() {
+		return time.Since(t.StartedAt)
+	}
+	return t.FinishedAt.Sub(t.StartedAt)
+}
+
+type entry struct {
+	task      Task
+	cancel    context.CancelFunc
+	cancelled bool
+	done      chan struct{}
+}
+
+// Manager runs agent calls in the background so that a tool call can return
+// a task ID immediately instead of blocking until the agent finishes.
+// Finished tasks are evicted once they are older than the TTL or exceed the
+// cap on finished tasks, oldest first.
+type Manager struct {
+	ctx         context.Context
+	ttl         time.Duration
+	maxFinished int
+
+	mu    sync.Mutex
+	tasks map[string]*entry
+}
+
+// NewManager creates a task manager. Tasks run under ctx, so cancelling it
+// cancels every running task.
+func NewManager(ctx context.Context) *Manager {
+	return &Manager{
+		ctx:         ctx,
+		ttl:         DefaultTTL,
+		maxFinished: DefaultMaxFinished,
+		tasks:       make(map[string]*entry),
+	}
+}
+
+// SetRetention sets how long finished tasks are kept and how many at most.
+func (m *Manager) SetRetention(ttl time.Duration, maxFinished int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ttl = ttl
+	m.maxFinished = maxFinished
+	m.evict(time.Now())
+}
+
+// Start runs fn in the background and returns the new task.
+func (m *Manager) Start(agent string, fn Func) Task {
+	ctx, cancel := context.WithCancel(m.ctx)
+
+	e := &entry{
+		task: Task{
+			ID:        uuid.New().String(),
+			Agent:     agent,
+			Status:    StatusRunning,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.evict(time.Now())
+	m.tasks[e.task.ID] = e
+	task := e.task
+	m.mu.Unlock()
+
+	go func() {
+		defer close(e.done)
+		defer cancel()
+
+		response, sessionID, errorClass, err := fn(ctx)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		e.task.FinishedAt = time.Now()
+		e.task.Response = response
+		e.task.SessionID = sessionID
+		switch {
+		case e.cancelled:
+			e.task.Status = StatusCancelled
+		case err != nil:
+			e.task.Status = StatusFailed
+			e.task.Error = err.Error()
+			e.task.ErrorClass = errorClass
+		default:
+			e.task.Status = StatusCompleted
+		}
+		m.evict(time.Now())
+	}()
+
+	return task
+}
+
+// Get returns a snapshot of the task with the given ID. Evicted tasks are
+// not found.
+func (m *Manager) Get(id string) (Task, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evict(time.Now())
+
+	e, ok := m.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return e.task, true
+}
+
+// Cancel cancels a running task and waits for it to stop. Cancelling a task
+// that has already finished is a no-op.
+func (m *Manager) Cancel(id string) (Task, error) {
+	m.mu.Lock()
+	e, ok := m.tasks[id]
+	if !ok {
+		m.mu.Unlock()
+		return Task{}, fmt.Errorf("unknown task: %s", id)
+	}
+	if e.task.Status == StatusRunning {
+		e.cancelled = true
+		e.cancel()
+	}
+	m.mu.Unlock()
+
+	<-e.done
+
+	// Read the entry rather than look the task up again, which would miss
+	// it if it was evicted as soon as it finished
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return e.task, nil
+}
+
+// evict drops finished tasks past the TTL, then the oldest finished tasks
+// over the cap. Must be called with m.mu held.
+func (m *Manager) evict(now time.Time) {
+	var finished []*entry
+	for id, e := range m.tasks {
+		if e.task.Status == StatusRunning {
+			continue
+		}
+		if m.ttl > 0 && now.Sub(e.task.FinishedAt) > m.ttl {
+			delete(m.tasks, id)
+			continue
+		}
+		finished = append(finished, e)
+	}
+
+	if m.maxFinished <= 0 || len(finished) <= m.maxFinished {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].task.FinishedAt.Before(finished[j].task.FinishedAt) })
+	for _, e := range finished[:len(finished)-m.maxFinished] {
+		delete(m.tasks, e.task.ID)
+	}
+}
@@ -0,0 +1,195 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitForStatus(t *testing.T, m *Manager, id string) Task {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		task, ok := m.Get(id)
+		if !ok {
+			t.Fatalf("Task %s not found", id)
+		}
+		if task.Status != StatusRunning {
+			return task
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Task %s did not finish", id)
+	return Task{}
+}
+
+func TestStart_Completed(t *testing.T) {
+	m := NewManager(context.Background())
+
+	task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		return "done", "session-1", "", nil
+	})
+
+	if task.Status != StatusRunning {
+		t.Errorf("Expected new task to be running, got %s", task.Status)
+	}
+
+	task = waitForStatus(t, m, task.ID)
+	if task.Status != StatusCompleted {
+		t.Errorf("Expected status completed, got %s", task.Status)
+	}
+	if task.Response != "done" {
+		t.Errorf("Expected response 'done', got '%s'", task.Response)
+	}
+	if task.SessionID != "session-1" {
+		t.Errorf("Expected session ID session-1, got %s", task.SessionID)
+	}
+	if task.Agent != "test-agent" {
+		t.Errorf("Expected agent test-agent, got %s", task.Agent)
+	}
+}
+
+func TestStart_Failed(t *testing.T) {
+	m := NewManager(context.Background())
+
+	task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		return "", "", "exit", errors.New("boom")
+	})
+
+	task = waitForStatus(t, m, task.ID)
+	if task.Status != StatusFailed {
+		t.Errorf("Expected status failed, got %s", task.Status)
+	}
+	if task.Error != "boom" {
+		t.Errorf("Expected error 'boom', got '%s'", task.Error)
+	}
+	if task.ErrorClass != "exit" {
+		t.Errorf("Expected error class 'exit', got '%s'", task.ErrorClass)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	m := NewManager(context.Background())
+
+	task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		<-ctx.Done()
+		return "", "session-1", "cancelled", ctx.Err()
+	})
+
+	task, err := m.Cancel(task.ID)
+	if err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if task.Status != StatusCancelled {
+		t.Errorf("Expected status cancelled, got %s", task.Status)
+	}
+	if task.FinishedAt.IsZero() {
+		t.Error("Cancelled task should have a finish time")
+	}
+}
+
+func TestCancel_Evicted(t *testing.T) {
+	m := NewManager(context.Background())
+	m.SetRetention(time.Nanosecond, 0)
+
+	task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		<-ctx.Done()
+		time.Sleep(time.Millisecond)
+		return "", "session-1", "cancelled", ctx.Err()
+	})
+
+	task, err := m.Cancel(task.ID)
+	if err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if task.Status != StatusCancelled || task.SessionID != "session-1" {
+		t.Errorf("Expected the cancelled task although it was evicted, got %+v", task)
+	}
+}
+
+func TestCancel_Finished(t *testing.T) {
+	m := NewManager(context.Background())
+
+	task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		return "done", "", "", nil
+	})
+	waitForStatus(t, m, task.ID)
+
+	task, err := m.Cancel(task.ID)
+	if err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if task.Status != StatusCompleted {
+		t.Errorf("Cancelling a finished task should keep its status, got %s", task.Status)
+	}
+}
+
+func TestCancel_Unknown(t *testing.T) {
+	m := NewManager(context.Background())
+
+	if _, err := m.Cancel("missing"); err == nil {
+		t.Error("Expected error for unknown task")
+	}
+}
+
+func TestManagerContextCancelsTasks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewManager(ctx)
+
+	task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		<-ctx.Done()
+		return "", "", "cancelled", ctx.Err()
+	})
+
+	cancel()
+
+	task = waitForStatus(t, m, task.ID)
+	if task.Status != StatusFailed {
+		t.Errorf("Expected status failed after shutdown, got %s", task.Status)
+	}
+}
+
+func TestEviction_TTL(t *testing.T) {
+	m := NewManager(context.Background())
+	m.SetRetention(50*time.Millisecond, DefaultMaxFinished)
+
+	task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		return "done", "", "", nil
+	})
+	waitForStatus(t, m, task.ID)
+
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := m.Get(task.ID); ok {
+		t.Error("Expected the finished task to be evicted after its TTL")
+	}
+}
+
+func TestEviction_MaxFinished(t *testing.T) {
+	m := NewManager(context.Background())
+	m.SetRetention(time.Hour, 2)
+
+	release := make(chan struct{})
+	running := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+		<-release
+		return "", "", "", nil
+	})
+	defer close(release)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		task := m.Start("test-agent", func(ctx context.Context) (string, string, string, error) {
+			return "done", "", "", nil
+		})
+		waitForStatus(t, m, task.ID)
+		ids = append(ids, task.ID)
+	}
+
+	if _, ok := m.Get(ids[0]); ok {
+		t.Error("Expected the oldest finished task to be evicted")
+	}
+	for _, id := range append(ids[1:], running.ID) {
+		if _, ok := m.Get(id); !ok {
+			t.Errorf("Expected task %s to be kept", id)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultMaxBackup is the largest directory, in bytes, outside a git
+	// repository that is backed up before each call so the call can be undone
+	DefaultMaxBackup = 64 << 20
+	// maxCalls is how many undoable calls are kept; older ones are dropped
+	maxCalls = 50
+)
+
+// ErrUndoConflict is returned by Undo when files the call changed were
+// changed again afterwards.
+var ErrUndoConflict = errors.New("files changed again since the call")
+
+// Call is an agent call that changed its working directory, kept so it can
+// be undone.
+type Call struct {
+	ID        string
+	Agent     string
+	SessionID string
+	Dir       string
+	Files     []FileChange
+	CreatedAt time.Time
+
+	before *Snapshot
+	after  *Snapshot
+}
+
+// Calls records the calls that changed a working directory, with
+// snapshots of the directory before and after each one.
+type Calls struct {
+	baseDir   string
+	remove    func(dir string) error
+	maxBackup int64
+
+	mu    sync.Mutex
+	calls map[string]*Call
+}
+
+// NewCalls creates a call history keeping backups under baseDir. remove
+// deletes files; sandboxed runs can leave files the host user cannot
+// delete directly.
+func NewCalls(baseDir string, remove func(dir string) error) *Calls {
+	return &Calls{
+		baseDir:   baseDir,
+		remove:    remove,
+		maxBackup: DefaultMaxBackup,
+		calls:     make(map[string]*Call),
+	}
+}
+
+// SetMaxBackup sets the largest directory, in bytes, outside a git
+// repository that is backed up before a call. Calls in larger directories
+// report their changes but cannot be undone; 0 disables backups.
+func (c *Calls) SetMaxBackup(bytes int64) {
+	c.maxBackup = bytes
+}
+
+// Begin snapshots dir before an agent call. Pass the snapshot to Finish
+// once the call is done.
+func (c *Calls) Begin(dir string) (*Snapshot, error) {
+	backup := ""
+	if c.maxBackup > 0 {
+		backup = filepath.Join(c.baseDir, uuid.New().String()[:8])
+	}
+	return takeSnapshot(dir, backup, c.maxBackup)
+}
+
+// Finish compares dir with the snapshot Begin took and returns the call
+// with the files it changed. The call is only recorded, and only given an
+// ID, if it changed something that can be restored.
+func (c *Calls) Finish(agent, sessionID string, before *Snapshot) (Call, error) {
+	after, err := takeSnapshot(before.dir, "", 0)
+	if err != nil {
+		c.release(before)
+		return Call{}, err
+	}
+	files, err := before.diff(after)
+	if err != nil || len(files) == 0 || !before.restorable() {
+		c.release(before)
+		return Call{Files: files}, err
+	}
+
+	call := &Call{
+		ID:        uuid.New().String()[:8],
+		Agent:     agent,
+		SessionID: sessionID,
+		Dir:       before.dir,
+		Files:     files,
+		CreatedAt: time.Now(),
+		before:    before,
+		after:     after,
+	}
+	if err := c.keep(call); err != nil {
+		c.release(before)
+		return Call{Files: files}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[call.ID] = call
+	if len(c.calls) > maxCalls {
+		oldest := c.list()[0]
+		delete(c.calls, oldest.ID)
+		c.drop(oldest)
+	}
+	return *call, nil
+}
+
+// keep makes the call's snapshots last until it is dropped: tree objects
+// get refs so git gc leaves them alone, and a scan keeps only the backup of
+// the files the call changed.
+func (c *Calls) keep(call *Call) error {
+	if call.before.tree == "" {
+		// After snapshots only matter for the call's files
+		for path := range call.after.files {
+			if !containsFile(call.Files, path) {
+				delete(call.after.files, path)
+			}
+		}
+		call.before.files = nil
+		return call.before.keepBackup(call.Files, c.remove)
+	}
+
+	ref := "refs/budgie/calls/" + call.ID
+	if _, err := gitOutput(call.Dir, nil, "update-ref", ref+"/before", call.before.tree); err != nil {
+		return err
+	}
+	_, err := gitOutput(call.Dir, nil, "update-ref", ref+"/after", call.after.tree)
+	return err
+}
+
+// Undo restores the files a call changed to their state before it, and
+// drops the call. If any of them changed again since, by a later call or
+// anyone else, nothing is restored and the error wraps ErrUndoConflict and
+// names the files and later calls, unless force is set; conflicts then
+// lists what was overwritten.
+func (c *Calls) Undo(id string, force bool) (call Call, conflicts []string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.calls[id]
+	if !ok {
+		return Call{}, nil, fmt.Errorf("unknown call: %s", id)
+	}
+
+	current, err := takeSnapshot(ch.Dir, "", 0)
+	if err != nil {
+		return Call{}, nil, err
+	}
+	if conflicts, err = ch.after.changed(current, ch.Files); err != nil {
+		return Call{}, nil, err
+	}
+	if len(conflicts) > 0 && !force {
+		msg := strings.Join(conflicts, ", ")
+		if later := c.laterCalls(ch, conflicts); len(later) > 0 {
+			msg += " (changed by later calls " + strings.Join(later, ", ") + ")"
+		}
+		return Call{}, conflicts, fmt.Errorf("%w: %s", ErrUndoConflict, msg)
+	}
+
+	for _, f := range ch.Files {
+		if f.Status == StatusAdded {
+			err = c.remove(filepath.Join(ch.Dir, filepath.FromSlash(f.Path)))
+		} else {
+			err = ch.before.restore(f.Path)
+		}
+		if err != nil {
+			return Call{}, conflicts, fmt.Errorf("failed to restore %s (call kept, earlier files already restored): %w", f.Path, err)
+		}
+	}
+
+	delete(c.calls, ch.ID)
+	c.drop(ch)
+	return *ch, conflicts, nil
+}
+
+// Get returns a recorded call.
+func (c *Calls) Get(id string) (Call, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.calls[id]
+	if !ok {
+		return Call{}, false
+	}
+	return *ch, true
+}
+
+// laterCalls returns the IDs of calls after ch in the same directory that
+// changed any of files.
+func (c *Calls) laterCalls(ch *Call, files []string) []string {
+	var ids []string
+	for _, other := range c.list() {
+		if other.Dir != ch.Dir || !other.CreatedAt.After(ch.CreatedAt) {
+			continue
+		}
+		for _, path := range files {
+			if containsFile(other.Files, path) {
+				ids = append(ids, other.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// Cleanup drops every recorded call.
+func (c *Calls) Cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, call := range c.calls {
+		delete(c.calls, id)
+		c.drop(call)
+	}
+	c.remove(c.baseDir)
+}
+
+// list returns the recorded calls, oldest first. Must be called with c.mu
+// held.
+func (c *Calls) list() []*Call {
+	calls := make([]*Call, 0, len(c.calls))
+	for _, call := range c.calls {
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].CreatedAt.Before(calls[j].CreatedAt) })
+	return calls
+}
+
+func (c *Calls) drop(call *Call) {
+	// A copy-isolated call's directory is gone once its change is applied
+	// or discarded, and its refs with it
+	if _, err := os.Stat(call.Dir); call.before.tree != "" && err == nil {
+		ref := "refs/budgie/calls/" + call.ID
+		for _, name := range []string{ref + "/before", ref + "/after"} {
+			if _, err := gitOutput(call.Dir, nil, "update-ref", "-d", name); err != nil {
+				log.Printf("Failed to delete %s: %v", name, err)
+			}
+		}
+	}
+	c.release(call.before)
+}
+
+// release removes a snapshot's backup.
+func (c *Calls) release(s *Snapshot) {
+	if s.backup != "" {
+		c.remove(s.backup)
+	}
+}
+
+func containsFile(files []FileChange, path string) bool {
+	for _, f := range files {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
+}
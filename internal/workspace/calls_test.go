@@ -0,0 +1,176 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCall records a call that makes edit's changes in dir.
+func runCall(t *testing.T, calls *Calls, dir string, edit func()) Call {
+	t.Helper()
+	before, err := calls.Begin(dir)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	edit()
+	call, err := calls.Finish("developer", "s1", before)
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	return call
+}
+
+func TestCalls_UndoGit(t *testing.T) {
+	repo := newRepo(t)
+	calls := NewCalls(t.TempDir(), os.RemoveAll)
+	os.Symlink("main.go", filepath.Join(repo, "src", "link"))
+	os.Chmod(filepath.Join(repo, "src", "old.txt"), 0755)
+
+	call := runCall(t, calls, repo, func() {
+		writeFile(t, filepath.Join(repo, "src", "main.go"), "package main\n")
+		writeFile(t, filepath.Join(repo, "src", "new.go"), "package main\n")
+		os.Remove(filepath.Join(repo, "src", "old.txt"))
+		os.Remove(filepath.Join(repo, "src", "link"))
+	})
+	if call.ID == "" || len(call.Files) != 4 {
+		t.Fatalf("Expected a recorded call with 4 files, got %+v", call)
+	}
+	if refs, _ := gitOutput(repo, nil, "for-each-ref", "refs/budgie/"); !strings.Contains(refs, call.ID) {
+		t.Errorf("Expected refs keeping the call's trees, got %q", refs)
+	}
+
+	if _, _, err := calls.Undo(call.ID, false); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(repo, "src", "main.go")); got != "package main\n\nfunc main() { run() }\n" {
+		t.Errorf("Expected the uncommitted edit restored, got %q", got)
+	}
+	if info, err := os.Stat(filepath.Join(repo, "src", "old.txt")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected the deleted file restored with its mode: %v", err)
+	}
+	if link, _ := os.Readlink(filepath.Join(repo, "src", "link")); link != "main.go" {
+		t.Errorf("Expected the symlink restored, got %q", link)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "src", "new.go")); err == nil {
+		t.Error("Expected the added file removed")
+	}
+	if refs, _ := gitOutput(repo, nil, "for-each-ref", "refs/budgie/"); refs != "" {
+		t.Errorf("Expected the refs deleted, got %q", refs)
+	}
+	if _, _, err := calls.Undo(call.ID, false); err == nil {
+		t.Error("Expected a call to be undone only once")
+	}
+}
+
+func TestCalls_UndoScan(t *testing.T) {
+	dir := newProject(t)
+	calls := NewCalls(t.TempDir(), os.RemoveAll)
+
+	call := runCall(t, calls, dir, func() {
+		writeFile(t, filepath.Join(dir, "main.go"), "package main\n")
+		writeFile(t, filepath.Join(dir, "new.go"), "package main\n")
+		os.Remove(filepath.Join(dir, "old.txt"))
+	})
+	if call.ID == "" {
+		t.Fatal("Expected the call to be recorded")
+	}
+
+	if _, _, err := calls.Undo(call.ID, false); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dir, "main.go")); got != "package main\n\nfunc main() {}\n" {
+		t.Errorf("Expected main.go restored, got %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "old.txt")); got != "remove me\n" {
+		t.Errorf("Expected old.txt restored, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.go")); err == nil {
+		t.Error("Expected the added file removed")
+	}
+}
+
+func TestCalls_MaxBackup(t *testing.T) {
+	dir := newProject(t)
+	baseDir := t.TempDir()
+	calls := NewCalls(baseDir, os.RemoveAll)
+	calls.SetMaxBackup(10)
+
+	call := runCall(t, calls, dir, func() { writeFile(t, filepath.Join(dir, "main.go"), "package main\n") })
+	if call.ID != "" || len(call.Files) != 1 {
+		t.Errorf("Expected the change reported but not recorded, got %+v", call)
+	}
+	if entries, _ := os.ReadDir(baseDir); len(entries) != 0 {
+		t.Errorf("Expected no backup, found %d entries", len(entries))
+	}
+}
+
+func TestCalls_UndoConflict(t *testing.T) {
+	repo := newRepo(t)
+	calls := NewCalls(t.TempDir(), os.RemoveAll)
+	main := filepath.Join(repo, "src", "main.go")
+
+	first := runCall(t, calls, repo, func() { writeFile(t, main, "first\n") })
+	second := runCall(t, calls, repo, func() { writeFile(t, main, "second\n") })
+
+	_, _, err := calls.Undo(first.ID, false)
+	if !errors.Is(err, ErrUndoConflict) || !strings.Contains(err.Error(), second.ID) {
+		t.Fatalf("Expected a conflict naming the later call, got %v", err)
+	}
+	if got := readFile(t, main); got != "second\n" {
+		t.Errorf("Expected nothing restored, got %q", got)
+	}
+
+	_, conflicts, err := calls.Undo(first.ID, true)
+	if err != nil || len(conflicts) != 1 {
+		t.Fatalf("Expected a forced undo to report the conflict, got %v (%v)", conflicts, err)
+	}
+	if got := readFile(t, main); got != "package main\n\nfunc main() { run() }\n" {
+		t.Errorf("Expected the state before the first call, got %q", got)
+	}
+}
+
+func TestCalls_NothingChanged(t *testing.T) {
+	dir := newProject(t)
+	baseDir := t.TempDir()
+	calls := NewCalls(baseDir, os.RemoveAll)
+
+	if call := runCall(t, calls, dir, func() {}); call.ID != "" || len(call.Files) != 0 {
+		t.Errorf("Expected no call recorded, got %+v", call)
+	}
+	if entries, _ := os.ReadDir(baseDir); len(entries) != 0 {
+		t.Errorf("Expected the backup removed, found %d entries", len(entries))
+	}
+}
+
+func TestCalls_UndoFilters(t *testing.T) {
+	repo := newRepo(t)
+	// The filter stores content upper-case and checks it out lower-case
+	for _, args := range [][]string{
+		{"config", "filter.upper.clean", "tr a-z A-Z"},
+		{"config", "filter.upper.smudge", "tr A-Z a-z"},
+	} {
+		if _, err := gitOutput(repo, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(repo, ".gitattributes"), "*.dat filter=upper\n")
+	writeFile(t, filepath.Join(repo, "notes.dat"), "keep me\n")
+	os.Chmod(filepath.Join(repo, "notes.dat"), 0600)
+	calls := NewCalls(t.TempDir(), os.RemoveAll)
+
+	call := runCall(t, calls, repo, func() {
+		writeFile(t, filepath.Join(repo, "notes.dat"), "changed\n")
+	})
+	if _, _, err := calls.Undo(call.ID, false); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if got := readFile(t, filepath.Join(repo, "notes.dat")); got != "keep me\n" {
+		t.Errorf("Expected the smudged content restored, got %q", got)
+	}
+	if info, _ := os.Stat(filepath.Join(repo, "notes.dat")); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file to keep its permissions, got %v", info.Mode().Perm())
+	}
+}
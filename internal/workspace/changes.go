@@ -0,0 +1,374 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Isolation is how an agent's calls are kept away from the real working
+// directory.
+type Isolation string
+
+const (
+	// IsolationNone runs the agent in the working directory itself
+	IsolationNone Isolation = ""
+	// IsolationCopy runs the agent in a copy and returns its edits as a
+	// pending change to apply or discard
+	IsolationCopy Isolation = "copy"
+	// IsolationWorktree runs each session in its own git worktree and branch
+	IsolationWorktree Isolation = "worktree"
+)
+
+// ParseIsolation returns the isolation mode with the given name; empty and
+// "none" mean no isolation.
+func ParseIsolation(s string) (Isolation, error) {
+	switch Isolation(s) {
+	case IsolationNone, "none":
+		return IsolationNone, nil
+	case IsolationCopy, IsolationWorktree:
+		return Isolation(s), nil
+	}
+	return "", fmt.Errorf("unknown isolation mode %q (expected none, copy or worktree)", s)
+}
+
+// Status is what happened to a changed file.
+type Status string
+
+const (
+	StatusAdded    Status = "added"
+	StatusModified Status = "modified"
+	StatusDeleted  Status = "deleted"
+)
+
+// FileChange is one file changed by an agent. Line counts are only set
+// where they are known; Binary marks files git does not count lines for.
+type FileChange struct {
+	Path         string `json:"path"`
+	Status       Status `json:"status"`
+	LinesAdded   int    `json:"linesAdded,omitempty"`
+	LinesDeleted int    `json:"linesDeleted,omitempty"`
+	Binary       bool   `json:"binary,omitempty"`
+}
+
+// ErrConflict is returned by Apply when the working directory changed under
+// a pending change.
+var ErrConflict = errors.New("working directory changed since the copy was made")
+
+// Change is a set of edits an agent made to an isolated copy of a working
+// directory, waiting to be applied or discarded.
+type Change struct {
+	ID        string
+	Agent     string
+	SessionID string
+	// Dir is the real working directory
+	Dir string
+	// CopyDir is the copy the agent works in
+	CopyDir   string
+	Files     []FileChange
+	Diff      string
+	CreatedAt time.Time
+
+	// baseDir holds Dir as it was copied, without .git, so diffs and
+	// conflict checks are against what the agent started from
+	baseDir  string
+	copyBase map[string]fileState // CopyDir when the copy was made
+}
+
+// Changes holds the pending changes of copy-isolated agent calls. Each
+// session keeps working in one copy per directory until its change is
+// applied or discarded, so later turns see the agent's earlier edits.
+type Changes struct {
+	baseDir string
+	remove  func(dir string) error
+
+	mu      sync.Mutex
+	pending map[string]*Change
+}
+
+// NewChanges creates a change store keeping copies under baseDir. remove
+// deletes a copy; sandboxed runs can leave files the host user cannot
+// delete directly.
+func NewChanges(baseDir string, remove func(dir string) error) *Changes {
+	return &Changes{
+		baseDir: baseDir,
+		remove:  remove,
+		pending: make(map[string]*Change),
+	}
+}
+
+// Prepare returns the change an agent call should work in: the session's
+// pending change for dir if there is one, otherwise a fresh copy of dir.
+// created reports which; a new change is only kept once Finish finds edits.
+// With shareObjects the copy's git repository borrows dir's object store
+// instead of copying it (see copy).
+func (c *Changes) Prepare(agent, sessionID, dir string, shareObjects bool) (change Change, created bool, err error) {
+	if ch, ok := c.SessionChange(sessionID, dir); ok {
+		return ch, false, nil
+	}
+
+	ch := &Change{
+		ID:        uuid.New().String()[:8],
+		Agent:     agent,
+		SessionID: sessionID,
+		Dir:       dir,
+		CreatedAt: time.Now(),
+	}
+	ch.CopyDir = filepath.Join(c.baseDir, ch.ID)
+	ch.baseDir = filepath.Join(c.baseDir, ch.ID+".base")
+
+	// Copying can take a while, so other calls are not held up meanwhile.
+	// The agent's copy is made from the base so the two match exactly.
+	if err := c.copy(ch, shareObjects); err != nil {
+		c.remove(ch.CopyDir)
+		c.remove(ch.baseDir)
+		return Change{}, false, fmt.Errorf("failed to copy %s: %w", dir, err)
+	}
+	if ch.copyBase, err = scanTree(ch.CopyDir); err != nil {
+		c.remove(ch.CopyDir)
+		c.remove(ch.baseDir)
+		return Change{}, false, err
+	}
+
+	c.mu.Lock()
+	c.pending[ch.ID] = ch
+	c.mu.Unlock()
+	return *ch, true, nil
+}
+
+// copy makes the base and the agent's copy of ch.Dir. With shareObjects,
+// .git/objects is not copied: the copy lists ch.Dir's object store as an
+// alternate, like git clone --shared, so the agent must see it at the same
+// path. Objects are only ever added, so the copy stays readable unless
+// git gc prunes objects only the copy refers to.
+func (c *Changes) copy(ch *Change, shareObjects bool) error {
+	if err := copyTreeSkipping(ch.Dir, ch.baseDir, skipDir); err != nil {
+		return err
+	}
+	if err := copyTree(ch.baseDir, ch.CopyDir); err != nil {
+		return err
+	}
+	gitDir := filepath.Join(ch.Dir, ".git")
+	info, err := os.Lstat(gitDir)
+	if err != nil {
+		return nil
+	}
+	copyGitDir := filepath.Join(ch.CopyDir, ".git")
+	if !shareObjects || !info.IsDir() {
+		return copyTree(gitDir, copyGitDir)
+	}
+	return shareGitDir(gitDir, copyGitDir)
+}
+
+// shareGitDir copies the git directory src to dst except its object store,
+// which dst refers to as an alternate.
+func shareGitDir(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == "objects" {
+			continue
+		}
+		if err := copyTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	objects, err := filepath.Abs(filepath.Join(src, "objects"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"info", "pack"} {
+		if err := os.MkdirAll(filepath.Join(dst, "objects", dir), 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dst, "objects", "info", "alternates"), []byte(objects+"\n"), 0644)
+}
+
+// SessionChange returns the session's pending change for dir, if any.
+func (c *Changes) SessionChange(sessionID, dir string) (Change, bool) {
+	if sessionID == "" {
+		return Change{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range c.pending {
+		if ch.SessionID == sessionID && ch.Dir == dir {
+			return *ch, true
+		}
+	}
+	return Change{}, false
+}
+
+// Finish records the session a call ran in and computes the change's files
+// and diff. A change without edits is dropped and returned with an empty ID.
+func (c *Changes) Finish(id, sessionID string) (Change, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.pending[id]
+	if !ok {
+		return Change{}, fmt.Errorf("unknown change: %s", id)
+	}
+	ch.SessionID = sessionID
+
+	current, err := scanTree(ch.CopyDir)
+	if err != nil {
+		return Change{}, err
+	}
+	ch.Files = compareTrees(ch.copyBase, current)
+	if len(ch.Files) == 0 {
+		c.drop(ch)
+		return Change{}, nil
+	}
+	ch.Diff = unifiedDiff(ch.baseDir, ch.CopyDir, ch.Files)
+	return *ch, nil
+}
+
+// Get returns a pending change.
+func (c *Changes) Get(id string) (Change, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.pending[id]
+	if !ok {
+		return Change{}, false
+	}
+	return *ch, true
+}
+
+// List returns the pending changes with edits, optionally only those of one
+// session, oldest first.
+func (c *Changes) List(sessionID string) []Change {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var changes []Change
+	for _, ch := range c.pending {
+		if len(ch.Files) > 0 && (sessionID == "" || ch.SessionID == sessionID) {
+			changes = append(changes, *ch)
+		}
+	}
+	sortByCreation(changes)
+	return changes
+}
+
+// Apply copies a pending change's edits into the real working directory
+// and drops the change. If any file it touches no longer matches the base
+// the copy was made from, nothing is written and the error wraps
+// ErrConflict and names the files.
+func (c *Changes) Apply(id string) (Change, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.pending[id]
+	if !ok {
+		return Change{}, fmt.Errorf("unknown change: %s", id)
+	}
+
+	current, err := scanTree(ch.CopyDir)
+	if err != nil {
+		return Change{}, err
+	}
+	ch.Files = compareTrees(ch.copyBase, current)
+
+	if conflicts := c.conflicts(ch); len(conflicts) > 0 {
+		return Change{}, fmt.Errorf("%w: %s", ErrConflict, strings.Join(conflicts, ", "))
+	}
+
+	for _, f := range ch.Files {
+		if err := applyFile(ch, f); err != nil {
+			return Change{}, fmt.Errorf("failed to apply %s (change left pending, earlier files already written): %w", f.Path, err)
+		}
+	}
+
+	applied := *ch
+	c.drop(ch)
+	return applied, nil
+}
+
+// Discard drops a pending change without touching the working directory.
+func (c *Changes) Discard(id string) (Change, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.pending[id]
+	if !ok {
+		return Change{}, fmt.Errorf("unknown change: %s", id)
+	}
+	discarded := *ch
+	c.drop(ch)
+	return discarded, nil
+}
+
+// Cleanup drops every pending change.
+func (c *Changes) Cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ch := range c.pending {
+		c.drop(ch)
+	}
+	c.remove(c.baseDir)
+}
+
+func (c *Changes) drop(ch *Change) {
+	delete(c.pending, ch.ID)
+	c.remove(ch.CopyDir)
+	c.remove(ch.baseDir)
+}
+
+// conflicts returns the files of ch whose content in the working directory
+// no longer matches the base, i.e. that someone else changed since the copy
+// was made.
+func (c *Changes) conflicts(ch *Change) []string {
+	var conflicts []string
+	for _, f := range ch.Files {
+		path := filepath.FromSlash(f.Path)
+		same, err := sameFile(filepath.Join(ch.baseDir, path), filepath.Join(ch.Dir, path))
+		if err != nil || !same {
+			conflicts = append(conflicts, f.Path)
+		}
+	}
+	return conflicts
+}
+
+// applyFile makes one file in the working directory match the copy.
+func applyFile(ch *Change, f FileChange) error {
+	target := filepath.Join(ch.Dir, filepath.FromSlash(f.Path))
+	if f.Status == StatusDeleted {
+		return os.Remove(target)
+	}
+
+	source := filepath.Join(ch.CopyDir, filepath.FromSlash(f.Path))
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		os.Remove(target)
+		return os.Symlink(link, target)
+	}
+	return copyFile(source, target, info)
+}
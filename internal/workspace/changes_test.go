@@ -0,0 +1,239 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// newProject creates a working directory with a few files.
+func newProject(t *testing.T) string {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "main.go"), "package main\n\nfunc main() {}\n")
+	writeFile(t, filepath.Join(dir, "old.txt"), "remove me\n")
+	writeFile(t, filepath.Join(dir, ".git", "HEAD"), "ref: refs/heads/main\n")
+	return dir
+}
+
+// edit makes an agent's edits in the copy.
+func edit(t *testing.T, copyDir string) {
+	writeFile(t, filepath.Join(copyDir, "main.go"), "package main\n\nfunc main() { println(\"hi\") }\n")
+	writeFile(t, filepath.Join(copyDir, "pkg", "new.go"), "package pkg\n")
+	os.Remove(filepath.Join(copyDir, "old.txt"))
+	writeFile(t, filepath.Join(copyDir, ".git", "index"), "ignored")
+}
+
+func TestChanges_ApplyCopiesEdits(t *testing.T) {
+	dir := newProject(t)
+	changes := NewChanges(t.TempDir(), os.RemoveAll)
+
+	change, created, err := changes.Prepare("developer", "", dir, false)
+	if err != nil || !created {
+		t.Fatalf("Prepare failed: %v (created %v)", err, created)
+	}
+	if readFile(t, filepath.Join(change.CopyDir, "main.go")) != readFile(t, filepath.Join(dir, "main.go")) {
+		t.Fatal("Expected the copy to match the working directory")
+	}
+
+	edit(t, change.CopyDir)
+	finished, err := changes.Finish(change.ID, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FileChange{
+		{Path: "main.go", Status: StatusModified},
+		{Path: "old.txt", Status: StatusDeleted},
+		{Path: "pkg/new.go", Status: StatusAdded},
+	}
+	if len(finished.Files) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, finished.Files)
+	}
+	for i := range want {
+		if finished.Files[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], finished.Files[i])
+		}
+	}
+	for _, line := range []string{"--- a/main.go", "+++ b/main.go", "+func main() { println(\"hi\") }", "+++ b/pkg/new.go", "-remove me"} {
+		if !strings.Contains(finished.Diff, line) {
+			t.Errorf("Expected %q in diff:\n%s", line, finished.Diff)
+		}
+	}
+
+	// Nothing touches the working directory before apply
+	if _, err := os.Stat(filepath.Join(dir, "pkg", "new.go")); err == nil {
+		t.Fatal("Working directory changed before apply")
+	}
+
+	if _, err := changes.Apply(change.ID); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !strings.Contains(readFile(t, filepath.Join(dir, "main.go")), "println") {
+		t.Error("Expected main.go to be updated")
+	}
+	if readFile(t, filepath.Join(dir, "pkg", "new.go")) != "package pkg\n" {
+		t.Error("Expected pkg/new.go to be added")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.txt")); err == nil {
+		t.Error("Expected old.txt to be deleted")
+	}
+	if _, err := os.Stat(change.CopyDir); err == nil {
+		t.Error("Expected the copy to be removed after apply")
+	}
+	if _, ok := changes.Get(change.ID); ok {
+		t.Error("Expected the change to be dropped after apply")
+	}
+}
+
+func TestChanges_ApplyRefusesConflicts(t *testing.T) {
+	dir := newProject(t)
+	changes := NewChanges(t.TempDir(), os.RemoveAll)
+
+	change, _, _ := changes.Prepare("developer", "", dir, false)
+	edit(t, change.CopyDir)
+	changes.Finish(change.ID, "s1")
+
+	// Someone else edits main.go in the meantime
+	writeFile(t, filepath.Join(dir, "main.go"), "package main // edited elsewhere\n")
+
+	_, err := changes.Apply(change.ID)
+	if !errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), "main.go") {
+		t.Fatalf("Expected a conflict on main.go, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pkg", "new.go")); err == nil {
+		t.Error("Expected nothing to be written on conflict")
+	}
+	if _, ok := changes.Get(change.ID); !ok {
+		t.Error("Expected the change to stay pending on conflict")
+	}
+}
+
+func TestChanges_DiffAgainstCopyTimeBase(t *testing.T) {
+	dir := newProject(t)
+	changes := NewChanges(t.TempDir(), os.RemoveAll)
+
+	change, _, _ := changes.Prepare("developer", "", dir, false)
+	// The working directory moves on while the agent works
+	writeFile(t, filepath.Join(dir, "main.go"), "package main // edited elsewhere\n")
+	writeFile(t, filepath.Join(dir, "old.txt"), "remove me\n")
+	edit(t, change.CopyDir)
+
+	finished, err := changes.Finish(change.ID, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(finished.Diff, "edited elsewhere") {
+		t.Errorf("Expected the diff against the copied state, got:\n%s", finished.Diff)
+	}
+	if !strings.Contains(finished.Diff, "-func main() {}") {
+		t.Errorf("Expected the agent's edit in the diff, got:\n%s", finished.Diff)
+	}
+
+	// Rewriting old.txt with the same content is not a conflict
+	_, err = changes.Apply(change.ID)
+	if !errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), "main.go") || strings.Contains(err.Error(), "old.txt") {
+		t.Fatalf("Expected a conflict on main.go only, got %v", err)
+	}
+}
+
+func TestChanges_DiscardAndEmptyChanges(t *testing.T) {
+	dir := newProject(t)
+	changes := NewChanges(t.TempDir(), os.RemoveAll)
+
+	change, _, _ := changes.Prepare("developer", "", dir, false)
+	finished, err := changes.Finish(change.ID, "s1")
+	if err != nil || finished.ID != "" {
+		t.Errorf("Expected a call without edits to leave no change, got %+v (%v)", finished, err)
+	}
+	if _, err := os.Stat(change.CopyDir); err == nil {
+		t.Error("Expected the unused copy to be removed")
+	}
+
+	change, _, _ = changes.Prepare("developer", "", dir, false)
+	edit(t, change.CopyDir)
+	changes.Finish(change.ID, "s1")
+	if _, err := changes.Discard(change.ID); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, filepath.Join(dir, "old.txt")) != "remove me\n" {
+		t.Error("Discard must not touch the working directory")
+	}
+	if len(changes.List("")) != 0 {
+		t.Error("Expected no pending changes after discard")
+	}
+}
+
+func TestChanges_SessionReusesCopy(t *testing.T) {
+	dir := newProject(t)
+	changes := NewChanges(t.TempDir(), os.RemoveAll)
+
+	first, _, _ := changes.Prepare("developer", "", dir, false)
+	edit(t, first.CopyDir)
+	changes.Finish(first.ID, "s1")
+
+	second, created, err := changes.Prepare("developer", "s1", dir, false)
+	if err != nil || created || second.ID != first.ID {
+		t.Fatalf("Expected the session's pending change to be reused, got %s (created %v, %v)", second.ID, created, err)
+	}
+
+	other, created, _ := changes.Prepare("developer", "s2", dir, false)
+	if !created || other.ID == first.ID {
+		t.Error("Expected another session to get its own copy")
+	}
+
+	if got := changes.List("s1"); len(got) != 1 || got[0].ID != first.ID {
+		t.Errorf("Expected one pending change for s1, got %v", got)
+	}
+}
+
+func TestParseIsolation(t *testing.T) {
+	for _, name := range []string{"", "none", "copy", "worktree"} {
+		if _, err := ParseIsolation(name); err != nil {
+			t.Errorf("ParseIsolation(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := ParseIsolation("overlay"); err == nil {
+		t.Error("Expected error for unknown isolation mode")
+	}
+}
+
+func TestChanges_ShareObjects(t *testing.T) {
+	repo := newRepo(t)
+	changes := NewChanges(t.TempDir(), os.RemoveAll)
+
+	change, _, err := changes.Prepare("developer", "", repo, true)
+	if err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(change.CopyDir, ".git", "objects", "pack")); len(entries) != 0 {
+		t.Fatal("Expected the copy not to duplicate the object store")
+	}
+	alternates := readFile(t, filepath.Join(change.CopyDir, ".git", "objects", "info", "alternates"))
+	if !strings.HasPrefix(alternates, filepath.Join(repo, ".git", "objects")) {
+		t.Fatalf("Expected the copy to borrow the source objects, got %q", alternates)
+	}
+	if out, err := gitOutput(change.CopyDir, nil, "status", "--porcelain"); err != nil || strings.TrimSpace(out) != "M src/main.go" {
+		t.Fatalf("Expected the copy's repository to read the shared objects, got %q: %v", out, err)
+	}
+}
@@ -0,0 +1,40 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// unifiedDiff returns a unified diff of every change between the trees at
+// oldRoot and newRoot. It uses diff(1) and lists the files instead if diff
+// is not installed.
+func unifiedDiff(oldRoot, newRoot string, changes []FileChange) string {
+	var out strings.Builder
+	for _, change := range changes {
+		oldPath, oldLabel := filepath.Join(oldRoot, filepath.FromSlash(change.Path)), "a/"+change.Path
+		newPath, newLabel := filepath.Join(newRoot, filepath.FromSlash(change.Path)), "b/"+change.Path
+		switch change.Status {
+		case StatusAdded:
+			oldPath, oldLabel = os.DevNull, os.DevNull
+		case StatusDeleted:
+			newPath, newLabel = os.DevNull, os.DevNull
+		}
+
+		cmd := exec.Command("diff", "-u", "--label", oldLabel, "--label", newLabel, oldPath, newPath)
+		output, err := cmd.Output()
+
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			// Same content: only the mode or modification time changed
+		case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+			out.Write(output)
+		default:
+			out.WriteString("Files " + oldLabel + " and " + newLabel + " differ\n")
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,381 @@
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Snapshot records the state of a working directory before an agent runs,
+// so the files the run changed can be reported and restored afterwards. In a git
+// repository it is a tree object of the working tree, ignored files left
+// out; elsewhere it is a metadata scan.
+type Snapshot struct {
+	dir   string
+	tree  string
+	files map[string]fileState
+	// backup is a copy of dir made with a scan, so its files can be
+	// restored; a tree object holds the content already
+	backup string
+}
+
+// takeSnapshot records the state of dir. Outside a git repository it also
+// copies dir to backup, if set, unless its files add up to more than
+// maxBackup bytes.
+func takeSnapshot(dir, backup string, maxBackup int64) (*Snapshot, error) {
+	if inside, _ := gitOutput(dir, nil, "rev-parse", "--is-inside-work-tree"); inside == "true" {
+		tree, err := workTree(dir)
+		if err != nil {
+			return nil, err
+		}
+		return &Snapshot{dir: dir, tree: tree}, nil
+	}
+
+	files, err := scanTree(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{dir: dir, files: files}
+	if backup != "" && totalSize(files) <= maxBackup {
+		if err := copyTree(dir, backup); err != nil {
+			os.RemoveAll(backup)
+			return nil, fmt.Errorf("failed to back up %s: %w", dir, err)
+		}
+		s.backup = backup
+	}
+	return s, nil
+}
+
+// diff returns the files that differ between s and a later snapshot of the
+// same directory, sorted by path. Line counts come from git; outside a
+// repository only added files are counted, since earlier content is not
+// kept.
+func (s *Snapshot) diff(later *Snapshot) ([]FileChange, error) {
+	if s.tree != "" {
+		return diffTrees(s.dir, s.tree, later.tree)
+	}
+
+	changes := compareTrees(s.files, later.files)
+	for i, change := range changes {
+		if change.Status == StatusAdded && later.files[change.Path].Mode.IsRegular() {
+			countLines(filepath.Join(s.dir, filepath.FromSlash(change.Path)), &changes[i])
+		}
+	}
+	return changes, nil
+}
+
+// changed returns which of files differ between s and a later snapshot.
+func (s *Snapshot) changed(later *Snapshot, files []FileChange) ([]string, error) {
+	differs := make(map[string]bool)
+	if s.tree != "" {
+		changes, err := diffTrees(s.dir, s.tree, later.tree)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range changes {
+			differs[change.Path] = true
+		}
+	} else {
+		for _, f := range files {
+			a, aok := s.files[f.Path]
+			b, bok := later.files[f.Path]
+			differs[f.Path] = aok != bok || !a.equal(b)
+		}
+	}
+
+	var changed []string
+	for _, f := range files {
+		if differs[f.Path] {
+			changed = append(changed, f.Path)
+		}
+	}
+	return changed, nil
+}
+
+// restorable reports whether files can be restored from the snapshot.
+func (s *Snapshot) restorable() bool {
+	return s.tree != "" || s.backup != ""
+}
+
+// restore writes one file back as it was in the snapshot. The file must
+// have existed then. In a repository the content goes through the path's
+// smudge filters and end-of-line conversion, as on checkout, and the file
+// keeps its other permission bits if it is still there.
+func (s *Snapshot) restore(path string) error {
+	target := filepath.Join(s.dir, filepath.FromSlash(path))
+	if s.tree == "" {
+		source := filepath.Join(s.backup, filepath.FromSlash(path))
+		info, err := os.Lstat(source)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(source)
+			if err != nil {
+				return err
+			}
+			return replaceWithSymlink(link, target)
+		}
+		return copyFile(source, target, info)
+	}
+
+	entry, err := gitOutput(s.dir, nil, "--literal-pathspecs", "ls-tree", s.tree, "--", path)
+	if err != nil {
+		return err
+	}
+	meta, _, _ := strings.Cut(entry, "\t")
+	fields := strings.Fields(meta)
+	if len(fields) != 3 || fields[1] != "blob" {
+		return fmt.Errorf("%s is not a file in the snapshot", path)
+	}
+	if fields[0] == "120000" {
+		content, err := gitRaw(s.dir, nil, "cat-file", "blob", fields[2])
+		if err != nil {
+			return err
+		}
+		return replaceWithSymlink(string(content), target)
+	}
+	// The snapshot holds what the clean filters made of the file, such as
+	// an LFS pointer; smudge it back
+	content, err := gitRaw(s.dir, nil, "cat-file", "--filters", "--path="+path, fields[2])
+	if err != nil {
+		return err
+	}
+	return writeContent(target, content, restoredMode(target, fields[0] == "100755"))
+}
+
+// restoredMode returns the permissions to restore a file with: those it has
+// now, or the defaults if it is gone, with the executable bits set as the
+// snapshot recorded them.
+func restoredMode(target string, executable bool) fs.FileMode {
+	perm := fs.FileMode(0644)
+	if info, err := os.Lstat(target); err == nil && info.Mode().IsRegular() {
+		perm = info.Mode().Perm()
+	}
+	if executable {
+		return perm | (perm&0444)>>2
+	}
+	return perm &^ 0111
+}
+
+// keepBackup trims the snapshot's backup to the given files, which are all
+// that can still be restored from it.
+func (s *Snapshot) keepBackup(files []FileChange, remove func(dir string) error) error {
+	if s.backup == "" {
+		return nil
+	}
+	kept := s.backup + ".kept"
+	for _, f := range files {
+		if f.Status == StatusAdded {
+			continue
+		}
+		target := filepath.Join(kept, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(s.backup, filepath.FromSlash(f.Path)), target); err != nil {
+			return err
+		}
+	}
+	if err := remove(s.backup); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(kept, 0755); err != nil {
+		return err
+	}
+	return os.Rename(kept, s.backup)
+}
+
+// diffTrees returns the files under dir that differ between two trees of
+// its repository.
+func diffTrees(dir, from, to string) ([]FileChange, error) {
+	if from == to {
+		return nil, nil
+	}
+
+	// --raw gives each file's status and --numstat its line counts; with
+	// --relative both are limited to, and relative to, the directory
+	output, err := gitOutput(dir, nil, "-c", "core.quotePath=false", "diff-tree", "-r", "--no-renames", "--relative", "--raw", "--numstat", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := make(map[string]*FileChange)
+	var changes []*FileChange
+	for _, line := range strings.Split(output, "\n") {
+		meta, path, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(meta, ":") {
+			fields := strings.Fields(meta)
+			change := &FileChange{Path: path, Status: StatusModified}
+			switch fields[len(fields)-1] {
+			case "A":
+				change.Status = StatusAdded
+			case "D":
+				change.Status = StatusDeleted
+			}
+			byPath[path] = change
+			changes = append(changes, change)
+			continue
+		}
+
+		deleted, path, _ := strings.Cut(path, "\t")
+		change := byPath[path]
+		if change == nil {
+			continue
+		}
+		if meta == "-" {
+			change.Binary = true
+			continue
+		}
+		change.LinesAdded, _ = strconv.Atoi(meta)
+		change.LinesDeleted, _ = strconv.Atoi(deleted)
+	}
+
+	result := make([]FileChange, len(changes))
+	for i, change := range changes {
+		result[i] = *change
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// workTree writes the working tree of dir's repository to a tree object,
+// staging through a copy of the index so the real one is left alone.
+// Copying the index keeps git's stat cache, so only changed files are
+// hashed. The objects are unreferenced and go away with git gc.
+func workTree(dir string) (string, error) {
+	indexPath, err := gitOutput(dir, nil, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(dir, indexPath)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "budgie-index-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpIndex := filepath.Join(tmpDir, "index")
+	if err := copyIndex(indexPath, tmpIndex); err != nil {
+		return "", err
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + tmpIndex}
+	if _, err := gitOutput(dir, env, "add", "-A", "--", "."); err != nil {
+		return "", err
+	}
+	return gitOutput(dir, env, "write-tree")
+}
+
+// copyIndex copies a git index. A repository without one starts from an
+// empty index, which git creates itself.
+func copyIndex(src, dst string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// countLines fills in the line count of an added file, or marks it binary.
+// Like git, a file with a NUL byte in its first 8000 bytes is binary.
+func countLines(path string, change *FileChange) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		change.Binary = true
+		return
+	}
+	change.LinesAdded = bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		change.LinesAdded++
+	}
+}
+
+func totalSize(files map[string]fileState) int64 {
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return size
+}
+
+// writeContent replaces target with content atomically.
+func writeContent(target string, content []byte, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".budgie-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func replaceWithSymlink(link, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+	return os.Symlink(link, target)
+}
+
+// gitOutput runs git in dir with extra environment variables and returns
+// its trimmed output.
+func gitOutput(dir string, env []string, args ...string) (string, error) {
+	output, err := gitRaw(dir, env, args...)
+	return strings.TrimSpace(string(output)), err
+}
+
+// gitRaw runs git in dir with extra environment variables and returns its
+// output as is.
+func gitRaw(dir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
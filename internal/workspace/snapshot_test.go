@@ -0,0 +1,120 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newRepo creates a git repository with one commit, an ignored file and an
+// uncommitted edit.
+func newRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := gitOutput(".", nil, "--version"); err != nil {
+		t.Skip("git not available")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	repo := t.TempDir()
+	writeFile(t, filepath.Join(repo, ".gitignore"), "*.log\n")
+	writeFile(t, filepath.Join(repo, "src", "main.go"), "package main\n\nfunc main() {}\n")
+	writeFile(t, filepath.Join(repo, "src", "old.txt"), "one\ntwo\n")
+	writeFile(t, filepath.Join(repo, "README"), "readme\n")
+	for _, args := range [][]string{{"init", "-q"}, {"add", "-A"}, {"commit", "-q", "-m", "init"}} {
+		if _, err := gitOutput(repo, nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(repo, "src", "main.go"), "package main\n\nfunc main() { run() }\n")
+	return repo
+}
+
+// changesSince returns the changes since snapshot was taken.
+func changesSince(t *testing.T, snapshot *Snapshot) ([]FileChange, error) {
+	t.Helper()
+	later, err := takeSnapshot(snapshot.dir, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.diff(later)
+}
+
+func TestSnapshot_Git(t *testing.T) {
+	repo := newRepo(t)
+	dir := filepath.Join(repo, "src")
+
+	snapshot, err := takeSnapshot(dir, "", 0)
+	if err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+
+	writeFile(t, filepath.Join(dir, "main.go"), "package main\n\nfunc main() {}\n\nfunc run() {}\n")
+	writeFile(t, filepath.Join(dir, "new.go"), "package main\n")
+	writeFile(t, filepath.Join(dir, "debug.log"), "ignored\n")
+	os.WriteFile(filepath.Join(dir, "blob.bin"), []byte{0, 1, 2}, 0644)
+	os.Remove(filepath.Join(dir, "old.txt"))
+	writeFile(t, filepath.Join(repo, "README"), "outside the directory\n")
+
+	changes, err := changesSince(t, snapshot)
+	if err != nil {
+		t.Fatalf("Changes failed: %v", err)
+	}
+	expected := []FileChange{
+		{Path: "blob.bin", Status: StatusAdded, Binary: true},
+		{Path: "main.go", Status: StatusModified, LinesAdded: 3, LinesDeleted: 1},
+		{Path: "new.go", Status: StatusAdded, LinesAdded: 1},
+		{Path: "old.txt", Status: StatusDeleted, LinesDeleted: 2},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, changes)
+	}
+
+	if status, _ := gitOutput(repo, nil, "status", "--porcelain", "--", "src/new.go"); status != "?? src/new.go" {
+		t.Errorf("Expected the real index to be untouched, got %q", status)
+	}
+}
+
+func TestSnapshot_Unchanged(t *testing.T) {
+	repo := newRepo(t)
+
+	snapshot, err := takeSnapshot(repo, "", 0)
+	if err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+	if changes, err := changesSince(t, snapshot); err != nil || len(changes) != 0 {
+		t.Errorf("Expected no changes, got %+v (%v)", changes, err)
+	}
+}
+
+func TestSnapshot_Scan(t *testing.T) {
+	dir := newProject(t)
+
+	snapshot, err := takeSnapshot(dir, "", 0)
+	if err != nil {
+		t.Fatalf("TakeSnapshot failed: %v", err)
+	}
+	if snapshot.tree != "" {
+		t.Fatal("Expected a scan outside a git repository")
+	}
+
+	writeFile(t, filepath.Join(dir, "main.go"), "package main\n\nfunc main() { run() }\n")
+	writeFile(t, filepath.Join(dir, "new.go"), "package main\n\nfunc run() {}")
+	os.Remove(filepath.Join(dir, "old.txt"))
+
+	changes, err := changesSince(t, snapshot)
+	if err != nil {
+		t.Fatalf("Changes failed: %v", err)
+	}
+	expected := []FileChange{
+		{Path: "main.go", Status: StatusModified},
+		{Path: "new.go", Status: StatusAdded, LinesAdded: 3},
+		{Path: "old.txt", Status: StatusDeleted},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, changes)
+	}
+}
@@ -0,0 +1,214 @@
+// Package workspace isolates agent runs from the real working directory and
+// tracks the changes they make to it.
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileState is what a scan records about one file. Files are compared by
+// metadata rather than content, so unchanged files are never read.
+type fileState struct {
+	Mode    fs.FileMode
+	Size    int64
+	ModTime time.Time
+	Link    string // symlink target
+}
+
+func (s fileState) equal(o fileState) bool {
+	return s.Mode == o.Mode && s.Size == o.Size && s.ModTime.Equal(o.ModTime) && s.Link == o.Link
+}
+
+// skipDir reports whether scans leave out a directory. Git metadata changes
+// whenever an agent runs git, but it is not part of the working tree.
+func skipDir(name string) bool {
+	return name == ".git"
+}
+
+// scanTree records every regular file and symlink under root, keyed by
+// slash-separated relative path.
+func scanTree(root string) (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && skipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		state := fileState{Mode: info.Mode(), Size: info.Size(), ModTime: info.ModTime()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if state.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+			state.ModTime = time.Time{}
+		case !info.Mode().IsRegular():
+			// Sockets, pipes and devices are not part of a project
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = state
+		return nil
+	})
+	return files, err
+}
+
+// compareTrees returns the files that differ between two scans, sorted by
+// path.
+func compareTrees(before, after map[string]fileState) []FileChange {
+	var changes []FileChange
+	for path, a := range after {
+		b, ok := before[path]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: path, Status: StatusAdded})
+		case !b.equal(a):
+			changes = append(changes, FileChange{Path: path, Status: StatusModified})
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Status: StatusDeleted})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// copyTree copies src to dst, which must not exist, keeping modes,
+// modification times and symlinks so a scan of the copy matches a scan of
+// the original.
+func copyTree(src, dst string) error {
+	return copyTreeSkipping(src, dst, func(string) bool { return false })
+}
+
+// copyTreeSkipping is copyTree leaving out directories below src whose
+// name skip reports.
+func copyTreeSkipping(src, dst string, skip func(name string) bool) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			if path != src && skip(d.Name()) {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info)
+		}
+		return nil
+	})
+}
+
+// copyFile copies a regular file, replacing target atomically.
+func copyFile(src, target string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".budgie-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// sameFile reports whether two paths hold the same file: both missing, the
+// same symlink, or regular files with the same permissions and content.
+func sameFile(a, b string) (bool, error) {
+	infoA, errA := os.Lstat(a)
+	infoB, errB := os.Lstat(b)
+	switch {
+	case errors.Is(errA, fs.ErrNotExist) && errors.Is(errB, fs.ErrNotExist):
+		return true, nil
+	case errA != nil && !errors.Is(errA, fs.ErrNotExist):
+		return false, errA
+	case errB != nil && !errors.Is(errB, fs.ErrNotExist):
+		return false, errB
+	case errA != nil || errB != nil:
+		return false, nil
+	case infoA.Mode() != infoB.Mode():
+		return false, nil
+	case infoA.Mode()&fs.ModeSymlink != 0:
+		linkA, err := os.Readlink(a)
+		if err != nil {
+			return false, err
+		}
+		linkB, err := os.Readlink(b)
+		return linkA == linkB, err
+	case infoA.Size() != infoB.Size():
+		return false, nil
+	}
+
+	contentA, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	contentB, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(contentA, contentB), nil
+}
+
+func sortByCreation(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].CreatedAt.Before(changes[j].CreatedAt) })
+}